@@ -2,46 +2,310 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/armagg/circular-arbitrage-finder/pkg/apiout"
 	"github.com/armagg/circular-arbitrage-finder/pkg/bookstore"
+	"github.com/armagg/circular-arbitrage-finder/pkg/capture"
 	"github.com/armagg/circular-arbitrage-finder/pkg/config"
 	"github.com/armagg/circular-arbitrage-finder/pkg/detector"
+	"github.com/armagg/circular-arbitrage-finder/pkg/feedback"
 	"github.com/armagg/circular-arbitrage-finder/pkg/graph"
+	"github.com/armagg/circular-arbitrage-finder/pkg/health"
 	"github.com/armagg/circular-arbitrage-finder/pkg/ingest"
+	"github.com/armagg/circular-arbitrage-finder/pkg/instruments"
 	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
+	"github.com/armagg/circular-arbitrage-finder/pkg/metrics"
 	"github.com/armagg/circular-arbitrage-finder/pkg/profit"
 	"github.com/armagg/circular-arbitrage-finder/pkg/registry"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health"
 )
 
 func main() {
-	cfg, err := config.Load("config.yaml")
+	if len(os.Args) > 1 && os.Args[1] == "backtest" { runBacktest(os.Args[2:]); return }
+	const configPath = "config.yaml"
+	cfg, err := config.Load(configPath)
 	if err != nil { logger.Log.Fatalf("failed to load config: %v", err) }
+	if err := cfg.Validate(); err != nil { logger.Log.Fatalf("invalid config: %v", err) }
 	if err := logger.Init(cfg.Log.Level); err != nil { logger.Log.Fatalf("failed to initialize logger: %v", err) }
+	// Registered first so it runs after every other deferred close
+	exitCode := 0
+	defer func() { if exitCode != 0 { os.Exit(exitCode) } }()
 
-	reg := registry.NewMarketRegistry()
-	idx := graph.NewIndex()
-	tob := bookstore.NewTopOfBookStore()
-	obs := bookstore.NewOrderBookStore()
-	sim := profit.NewTOBSimulator(cfg.Strategy.MinProfitEdge, cfg.Strategy.SlippageBp)
-	var publisher apiout.Publisher = apiout.LogPublisher{}
-	if addr := os.Getenv("EXECUTOR_ADDR"); addr != "" {
-		if _, _, err := net.SplitHostPort(addr); err != nil { logger.Log.Fatalf("invalid EXECUTOR_ADDR: %v", err) }
+	p := newPipeline(cfg)
+	reg, idx, tob, obs, sim := p.reg, p.idx, p.tob, p.obs, p.sim
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: "arb_graph_markets", Help: "Markets in the graph index."}, func() float64 { m, _ := idx.Size(); return float64(m) })
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: "arb_graph_cycles", Help: "Cycles (triangles and longer) in the graph index."}, func() float64 { _, c := idx.Size(); return float64(c) })
+	if p.freshness != nil {
+		metrics.NewCounterMapFunc(prometheus.DefaultRegisterer, "arb_quote_rejections_total", "Cycles dropped for old or skewed quotes, per reason.", "reason", func() map[string]float64 {
+			res := make(map[string]float64)
+			for reason, n := range p.freshness.Rejections() { res[reason] = float64(n) }
+			return res
+		})
+	}
+	var conns []*grpc.ClientConn
+	defer func() { for _, conn := range conns { conn.Close() } }()
+	dial := func(addr string) apiout.Publisher {
+		if _, _, err := net.SplitHostPort(addr); err != nil { logger.Log.Fatalf("invalid executor address %q: %v", addr, err) }
 		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
 		if err != nil { logger.Log.Fatalf("failed to dial executor: %v", err) }
-		defer conn.Close()
-		publisher = apiout.NewGRPCPublisher(conn)
+		conns = append(conns, conn)
+		return apiout.NewGRPCPublisher(conn)
+	}
+	tracker := feedback.NewTracker(cfg.Feedback.RejectThreshold, time.Duration(cfg.Feedback.CoolDownMs)*time.Millisecond)
+	var publisher apiout.Publisher = apiout.NewInstrumentedPublisher("log", apiout.LogPublisher{})
+	if addr := os.Getenv("EXECUTOR_ADDR"); addr != "" { publisher = apiout.NewInstrumentedPublisher("executor", dial(addr)) }
+	// A queued fanout returns before the sinks publish, so the end-to-end
+	// latency is taken at the sinks whose replies count
+	sinkQueue := cfg.PublishQueue.SinkCapacity
+	endToEnd := len(cfg.Sinks) == 0 || sinkQueue <= 0
+	if len(cfg.Sinks) > 0 {
+		sinks := make([]apiout.Sink, 0, len(cfg.Sinks))
+		for _, s := range cfg.Sinks {
+			sink := apiout.Sink{Name: s.Name, Feedback: s.Feedback, Filter: apiout.Filter{Exchanges: s.Exchanges, MinProfit: s.MinProfit, QuoteCurrencies: s.QuoteCurrencies}, Timeout: time.Duration(s.TimeoutMs) * time.Millisecond}
+			switch s.Type {
+			case "log": sink.Publisher = apiout.LogPublisher{}
+			case "grpc": sink.Publisher = dial(s.Addr)
+			case "journal":
+				journal, err := apiout.NewJournalPublisher(s.Dir, s.MaxBytes, time.Duration(s.MaxAgeMs)*time.Millisecond)
+				if err != nil { logger.Log.Fatalf("failed to open journal for sink %s: %v", s.Name, err) }
+				defer journal.Close()
+				sink.Publisher = journal
+			default: logger.Log.Fatalf("unknown type %q for sink %s", s.Type, s.Name)
+			}
+			instrumented := apiout.NewInstrumentedPublisher(s.Name, sink.Publisher)
+			instrumented.EndToEnd = !endToEnd && s.Feedback
+			sink.Publisher = instrumented
+			sinks = append(sinks, sink)
+		}
+		if sinkQueue > 0 {
+			fanout := apiout.NewQueuedFanoutPublisher(sinkQueue, sinks...)
+			fanout.OnResult = tracker.Record
+			defer fanout.Close()
+			if ms := cfg.PublishQueue.StatsIntervalMs; ms > 0 {
+				go func() {
+					for range time.Tick(time.Duration(ms) * time.Millisecond) {
+						for name, s := range fanout.Stats() { logger.Log.WithFields(logrus.Fields{"sink": name, "depth": s.Depth, "published": s.Published, "failed": s.Failed, "dropped": s.Dropped}).Info("apiout: sink queue") }
+					}
+				}()
+			}
+			metrics.NewCounterMapFunc(prometheus.DefaultRegisterer, "arb_sink_queue_dropped_total", "Plans a sink queue dropped on overflow, per sink.", "sink", func() map[string]float64 {
+				res := make(map[string]float64)
+				for name, s := range fanout.Stats() { res[name] = float64(s.Dropped) }
+				return res
+			})
+			publisher = fanout
+		} else {
+			publisher = apiout.NewFanoutPublisher(sinks...)
+		}
 	}
+	all := apiout.NewInstrumentedPublisher("all", publisher)
+	all.EndToEnd = endToEnd
+	publisher = all
+	if q := cfg.PublishQueue; q.Capacity > 0 {
+		policy, err := apiout.ParseOverflowPolicy(q.Overflow)
+		if err != nil { logger.Log.Fatalf("invalid publish queue config: %v", err) }
+		queue := apiout.NewQueuedPublisher(publisher, q.Capacity, q.Workers, policy)
+		queue.OnResult = tracker.Record
+		defer queue.Close()
+		if ms := q.StatsIntervalMs; ms > 0 {
+			go func() { for range time.Tick(time.Duration(ms) * time.Millisecond) { queue.LogStats() } }()
+		}
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: "arb_publish_queue_depth", Help: "Plans waiting in the publish queue."}, func() float64 { return float64(queue.Stats().Depth) })
+		promauto.NewCounterFunc(prometheus.CounterOpts{Name: "arb_publish_queue_dropped_total", Help: "Plans the publish queue dropped on overflow."}, func() float64 { return float64(queue.Stats().Dropped) })
+		publisher = queue
+	}
+	if cfg.Dedup.WindowMs > 0 { publisher = apiout.NewDedupPublisher(publisher, time.Duration(cfg.Dedup.WindowMs)*time.Millisecond, cfg.Dedup.MinChange) }
 	det := detector.NewDetector(idx, tob, reg, sim, publisher)
+	det.Feedback = tracker
+	if ms := cfg.Feedback.StatsIntervalMs; ms > 0 {
+		go func() { for range time.Tick(time.Duration(ms) * time.Millisecond) { det.Feedback.LogStats() } }()
+	}
+	det.Source = p.newSource(cfg)
 	listenAddr := os.Getenv("INGRESS_ADDR"); if listenAddr == "" { listenAddr = ":50051" }
 	ctx, cancel := context.WithCancel(context.Background()); defer cancel()
 	srv := ingest.NewGRPCServer(tob, det, cfg, obs)
-	go func() { if err := ingest.Serve(ctx, listenAddr, srv); err != nil { logger.Log.Fatalf("ingress server error: %v", err) } }()
+	srv.Feed = ingest.NewFeedServer(obs)
+	srv.Health = grpchealth.NewServer()
+	if c := cfg.Capture; c.Dir != "" {
+		rec, err := capture.NewRecorder(c.Dir, c.MaxBytes, time.Duration(c.MaxAgeMs)*time.Millisecond)
+		if err != nil { logger.Log.Fatalf("failed to start capture: %v", err) }
+		defer rec.Close()
+		srv.Recorder = rec
+	}
+	// Pricing, fees, quote assets and trade amounts follow the file; the
+	// rest of the config needs a restart
+	watcher := config.NewWatcher(configPath, cfg, time.Duration(cfg.Reload.WatchIntervalMs)*time.Millisecond, func(next *config.Config) {
+		if err := logger.Init(next.Log.Level); err != nil { logger.Log.WithField("error", err).Warn("arb-finder: failed to apply log level") }
+		srv.SetConfig(next)
+		det.Reconfigure(p.newSimulator(next), p.newSource(next), p.fees(next))
+	})
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go watcher.Run(ctx, hup)
+	ready := health.NewReadiness(idx, srv.LastDelta, time.Duration(cfg.Health.MaxFeedAgeMs)*time.Millisecond)
+	ready.Exchanges = cfg.Health.Exchanges
+	go ready.Watch(ctx, srv.Health, time.Second)
+	httpAddr := os.Getenv("METRICS_ADDR"); if httpAddr == "" { httpAddr = ":9102" }
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/readyz", ready.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) { w.Write([]byte("ok\n")) })
+	httpSrv := &http.Server{Addr: httpAddr, Handler: mux}
+
+	// A server that fails stops the finder the same way a signal does
+	errs := make(chan error, 2+len(cfg.Feeds))
+	var running sync.WaitGroup
+	run := func(name string, fn func() error) {
+		running.Add(1)
+		go func() { defer running.Done(); if err := fn(); err != nil { errs <- fmt.Errorf("%s: %w", name, err) } }()
+	}
+	run("ingress server", func() error { return ingest.Serve(ctx, listenAddr, srv) })
+	go func() { if err := httpSrv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) { errs <- fmt.Errorf("http server: %w", err) } }()
+	for _, f := range cfg.Feeds {
+		markets, err := f.MarketKeys()
+		if err != nil { logger.Log.Fatalf("invalid feed config: %v", err) }
+		client := ingest.NewFeedClient(f.Addr, markets, srv)
+		if f.MinBackoffMs > 0 { client.MinBackoff = time.Duration(f.MinBackoffMs) * time.Millisecond }
+		if f.MaxBackoffMs > 0 { client.MaxBackoff = time.Duration(f.MaxBackoffMs) * time.Millisecond }
+		run("feed client "+f.Addr, func() error { return client.Run(ctx) })
+	}
 	logger.Log.Infof("arb-finder listening on %s", listenAddr)
-	select {}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case sig := <-signals:
+		logger.Log.WithField("signal", sig.String()).Info("arb-finder: shutting down")
+	case err := <-errs:
+		logger.Log.WithField("error", err).Error("arb-finder: shutting down after failure")
+		exitCode = 1
+	}
+	// Report not ready so traffic drains, then stop taking deltas. The
+	// deferred closes then drain the publish queue and close the sinks and
+	// executor connections, in that order.
+	ready.ShutDown()
+	srv.Health.Shutdown()
+	cancel()
+	running.Wait()
+	shutdownCtx, done := context.WithTimeout(context.Background(), 5*time.Second); defer done()
+	if err := httpSrv.Shutdown(shutdownCtx); err != nil { logger.Log.WithField("error", err).Warn("arb-finder: http server did not stop cleanly") }
+	logger.Log.Info("arb-finder: ingress stopped, draining publishers")
+}
+
+// pipeline is the market state and pricing shared by the live finder and
+// the backtest.
+type pipeline struct {
+	reg       *registry.MarketRegistry
+	idx       *graph.Index
+	tob       *bookstore.TopOfBookStore
+	obs       *bookstore.OrderBookStore
+	sim       profit.Simulator
+	transfers *profit.Transfers
+	freshness *profit.Freshness
+	// Fees set by instrument files, nil where the config's apply
+	instrumentFees map[types.MarketKey]*types.Fee
+}
+
+func newPipeline(cfg *config.Config) *pipeline {
+	p := &pipeline{
+		reg:            registry.NewMarketRegistry(),
+		idx:            graph.NewIndex(),
+		tob:            bookstore.NewTopOfBookStore(),
+		obs:            bookstore.NewOrderBookStore(),
+		instrumentFees: make(map[types.MarketKey]*types.Fee),
+	}
+	p.idx.MaxCycleLen = cfg.Strategy.MaxCycleLength
+	if cfg.CrossExchange.Enabled {
+		p.idx.CrossExchange = true
+		p.transfers = profit.NewTransfers()
+		for asset, c := range cfg.CrossExchange.Transfers { p.transfers.SetAsset(asset, profit.TransferCost{Fixed: c.Fixed, Bp: c.Bp}) }
+		for _, l := range cfg.CrossExchange.Latency {
+			if len(l.Venues) != 2 { logger.Log.Fatalf("cross_exchange latency needs exactly two venues, got %v", l.Venues) }
+			p.transfers.SetLatency(l.Venues[0], l.Venues[1], l.Bp)
+		}
+	}
+	for _, path := range cfg.InstrumentFiles {
+		list, err := instruments.Load(path)
+		if err != nil { logger.Log.Fatalf("failed to load instruments: %v", err) }
+		// The first file listing a market wins, as in Apply
+		for _, inst := range list {
+			if _, ok := p.instrumentFees[inst.Market.Key()]; !ok { p.instrumentFees[inst.Market.Key()] = inst.Fee }
+		}
+		instruments.Apply(list, p.idx, p.reg, cfg)
+	}
+	if q := cfg.QuoteAge; q.Enabled() {
+		p.freshness = profit.NewFreshness(time.Duration(q.MaxAgeMs)*time.Millisecond, time.Duration(q.MaxSkewMs)*time.Millisecond)
+		for ex, ms := range q.Exchanges { p.freshness.SetExchangeMaxAge(ex, time.Duration(ms)*time.Millisecond) }
+	}
+	p.sim = p.newSimulator(cfg)
+	return p
+}
+
+// newSimulator prices cycles with the strategy and inventory of cfg. A
+// reload builds a new one; transfers and freshness carry over.
+func (p *pipeline) newSimulator(cfg *config.Config) profit.Simulator {
+	var inventory *profit.Inventory
+	if len(cfg.Inventory) > 0 {
+		inventory = profit.NewInventory()
+		for ex, balances := range cfg.Inventory {
+			for asset, amount := range balances { inventory.SetBalance(ex, asset, amount) }
+		}
+		for asset, amount := range cfg.Strategy.TradeAmounts { inventory.SetTradeAmount(asset, amount) }
+	}
+	tobSim := profit.NewTOBSimulator(cfg.Strategy.MinProfitEdge, cfg.Strategy.SlippageBp)
+	tobSim.Transfers = p.transfers
+	tobSim.Inventory = inventory
+	tobSim.Freshness = p.freshness
+	var sim profit.Simulator = tobSim
+	switch cfg.Strategy.Simulator {
+	case "depth":
+		depth := profit.NewDepthSimulator(cfg.Strategy.MinProfitEdge, cfg.Strategy.SlippageBp, cfg.Strategy.MinFillRatio, p.obs.Get)
+		depth.Transfers = p.transfers
+		depth.Inventory = inventory
+		depth.Freshness = p.freshness
+		sim = depth
+	case "optimize":
+		bounds := make(map[string]profit.SizeBounds, len(cfg.Strategy.SizeBounds))
+		for q, b := range cfg.Strategy.SizeBounds { bounds[q] = profit.SizeBounds{Min: b.Min, Max: b.Max} }
+		depth := profit.NewDepthSimulator(cfg.Strategy.MinProfitEdge, cfg.Strategy.SlippageBp, cfg.Strategy.MinFillRatio, p.obs.Get)
+		depth.Transfers = p.transfers
+		depth.Inventory = inventory
+		depth.Freshness = p.freshness
+		sim = profit.NewSizeOptimizer(depth, bounds)
+	}
+	return sim
+}
+
+func (p *pipeline) newSource(cfg *config.Config) detector.CycleSource {
+	if cfg.Strategy.Detector == "bellman_ford" {
+		search := detector.NewNegativeCycleSearch(p.idx, p.tob, p.reg, cfg.Strategy.SlippageBp)
+		search.Transfers = p.transfers
+		return search
+	}
+	return detector.IndexCycles{Index: p.idx}
+}
+
+// fees prices m from its instrument file when it set a fee, else from cfg.
+func (p *pipeline) fees(cfg *config.Config) func(types.Market) types.Fee {
+	return func(m types.Market) types.Fee {
+		if f := p.instrumentFees[m.Key()]; f != nil { return *f }
+		return cfg.GetFee(m.Exchange, m.Quote)
+	}
 }
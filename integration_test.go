@@ -30,14 +30,14 @@ func TestFullArbitrageFlow(t *testing.T) {
 	// Add markets to registry and index
 	for _, market := range markets {
 		reg.UpsertMarket(market)
-		reg.SetFee(market.Symbol, types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+		reg.SetFee(market.Key(), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
 		idx.AddMarket(market)
 	}
 
 	// Setup profitable arbitrage prices
 	tobData := testutils.CreateProfitableArbitragePrices()
-	for symbol, tob := range tobData {
-		books.Set(symbol, tob)
+	for key, tob := range tobData {
+		books.Set(key, tob)
 	}
 
 	// Trigger arbitrage detection
@@ -94,9 +94,9 @@ func TestConfigToRegistryIntegration(t *testing.T) {
 
 	// Test fee retrieval from config
 	fee := cfg.GetFee("binance", "USDT")
-	reg.SetFee("BTCUSDT", fee)
+	reg.SetFee(types.NewMarketKey("binance", "BTCUSDT"), fee)
 
-	retrievedMarket, exists := reg.GetMarket("BTCUSDT")
+	retrievedMarket, exists := reg.GetMarket(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("Market should exist in registry")
 	}
@@ -105,7 +105,7 @@ func TestConfigToRegistryIntegration(t *testing.T) {
 		t.Errorf("Expected symbol BTCUSDT, got %s", retrievedMarket.Symbol)
 	}
 
-	retrievedFee, exists := reg.GetFee("BTCUSDT")
+	retrievedFee, exists := reg.GetFee(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("Fee should exist in registry")
 	}
@@ -134,10 +134,10 @@ func TestBookstoreSortingIntegration(t *testing.T) {
 		{Price: 50040.0, Qty: 0.8}, // Should be last (highest)
 	}
 
-	// Upsert with sorting
-	orderStore.Upsert("BTCUSDT", bids, asks, 12345, 1640995200000000000, 0)
+	// A snapshot sorts the levels
+	orderStore.ApplyDelta(types.NewMarketKey("binance", "BTCUSDT"), bids, asks, true, 12345, 1640995200000000000, 0)
 
-	retrieved, exists := orderStore.Get("BTCUSDT")
+	retrieved, exists := orderStore.Get(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("Order book should exist")
 	}
@@ -159,9 +159,9 @@ func TestBookstoreSortingIntegration(t *testing.T) {
 	}
 
 	// Test depth limiting
-	orderStore.Upsert("BTCUSDT", bids, asks, 12346, 1640995200000000000, 2)
+	orderStore.ApplyDelta(types.NewMarketKey("binance", "BTCUSDT"), bids, asks, true, 12346, 1640995200000000000, 2)
 
-	retrievedLimited, _ := orderStore.Get("BTCUSDT")
+	retrievedLimited, _ := orderStore.Get(types.NewMarketKey("binance", "BTCUSDT"))
 	if len(retrievedLimited.Bids) != 2 {
 		t.Errorf("Expected 2 bids after depth limit, got %d", len(retrievedLimited.Bids))
 	}
@@ -183,12 +183,12 @@ func TestGraphTriangleIntegration(t *testing.T) {
 	}
 
 	// Should have found triangles
-	if len(idx.Triangles) == 0 {
+	if len(idx.Cycles) == 0 {
 		t.Error("Should have found triangles")
 	}
 
 	// Verify triangle indexing
-	for i, triangle := range idx.Triangles {
+	for i, triangle := range idx.Cycles {
 		// Each triangle should have 3 market IDs
 		if len(triangle.MarketIds) != 3 {
 			t.Errorf("Triangle %d should have 3 market IDs, got %d", i, len(triangle.MarketIds))
@@ -204,7 +204,7 @@ func TestGraphTriangleIntegration(t *testing.T) {
 		// Each market should reference this triangle
 		for _, marketID := range triangle.MarketIds {
 			found := false
-			for _, triID := range idx.TrianglesByMarket[marketID] {
+			for _, triID := range idx.CyclesByMarket[marketID] {
 				if triID == i {
 					found = true
 					break
@@ -216,7 +216,7 @@ func TestGraphTriangleIntegration(t *testing.T) {
 		}
 	}
 
-	t.Logf("Found %d triangles", len(idx.Triangles))
+	t.Logf("Found %d triangles", len(idx.Cycles))
 }
 
 // TestProfitSimulatorIntegration tests profit calculation with realistic data
@@ -224,27 +224,27 @@ func TestProfitSimulatorIntegration(t *testing.T) {
 	sim := profit.NewTOBSimulator(0.001, 5.0)
 
 	markets := testutils.CreateTestMarkets()
-	triangle := testutils.CreateTestTriangle(markets, [3]int{0, 1, 2})
+	triangle := testutils.CreateTestTriangle(markets, []int{0, 1, 2})
 
 	// Test with profitable prices - use the graph's triangle configuration
-	graphTriangle := types.Triangle{
-		MarketIds: [3]int{1, 2, 0},    // ETHUSDT, ETHBTC, BTCUSDT
-		Dirs:      [3]int8{1, -1, -1}, // Buy ETHUSDT, Sell ETHBTC, Sell BTCUSDT
+	graphTriangle := types.Cycle{
+		MarketIds: []int{1, 2, 0},    // ETHUSDT, ETHBTC, BTCUSDT
+		Dirs:      []int8{1, -1, -1}, // Buy ETHUSDT, Sell ETHBTC, Sell BTCUSDT
 		QuoteCcy:  "USDT",
 	}
 
 	profitablePrices := testutils.CreateProfitableArbitragePrices()
 
-	tobBySymbol := func(symbol string) (types.TopOfBook, bool) {
-		tob, exists := profitablePrices[symbol]
+	tobByMarket := func(key types.MarketKey) (types.TopOfBook, bool) {
+		tob, exists := profitablePrices[key]
 		return tob, exists
 	}
 
-	feeBySymbol := func(symbol string) (types.Fee, bool) {
+	feeByMarket := func(key types.MarketKey) (types.Fee, bool) {
 		return types.Fee{TakerBp: 0.1, MakerBp: 0.05}, true
 	}
 
-	plan, found := sim.EvaluateTOB(graphTriangle, markets, tobBySymbol, feeBySymbol, 1000.0)
+	plan, found := sim.EvaluateTOB(graphTriangle, markets, tobByMarket, feeByMarket, 1000.0)
 
 	t.Logf("Profit simulation found: %v, profit: %f", found, plan.ExpectedProfitQuote)
 
@@ -263,12 +263,12 @@ func TestProfitSimulatorIntegration(t *testing.T) {
 	// Test with non-profitable prices
 	noProfitPrices := testutils.CreateNoArbitragePrices()
 
-	tobBySymbolNoProfit := func(symbol string) (types.TopOfBook, bool) {
-		tob, exists := noProfitPrices[symbol]
+	tobByMarketNoProfit := func(key types.MarketKey) (types.TopOfBook, bool) {
+		tob, exists := noProfitPrices[key]
 		return tob, exists
 	}
 
-	_, found = sim.EvaluateTOB(triangle, markets, tobBySymbolNoProfit, feeBySymbol, 1000.0)
+	_, found = sim.EvaluateTOB(triangle, markets, tobByMarketNoProfit, feeByMarket, 1000.0)
 
 	if found {
 		t.Error("Expected no profitable arbitrage with equal prices")
@@ -291,9 +291,9 @@ func TestConcurrencyIntegration(t *testing.T) {
 	markets := testutils.CreateTestMarkets()
 	for _, market := range markets {
 		reg.UpsertMarket(market)
-		reg.SetFee(market.Symbol, types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+		reg.SetFee(market.Key(), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
 		idx.AddMarket(market)
-		books.Set(market.Symbol, types.TopOfBook{
+		books.Set(market.Key(), types.TopOfBook{
 			BidPx: 100.0,
 			AskPx: 101.0,
 			BidSz: 10.0,
@@ -317,7 +317,7 @@ func TestConcurrencyIntegration(t *testing.T) {
 		for i := 0; i < 50; i++ {
 			bids := []types.Level{{Price: 100.0 + float64(i), Qty: 10.0}}
 			asks := []types.Level{{Price: 101.0 + float64(i), Qty: 10.0}}
-			orderBooks.Upsert("BTCUSDT", bids, asks, uint64(i), 1640995200000000000, 0)
+			orderBooks.ApplyDelta(types.NewMarketKey("binance", "BTCUSDT"), bids, asks, true, uint64(i), 1640995200000000000, 0)
 		}
 		done <- true
 	}()
@@ -327,7 +327,7 @@ func TestConcurrencyIntegration(t *testing.T) {
 	<-done
 
 	// Verify system still works
-	_, exists := books.Get("BTCUSDT")
+	_, exists := books.Get(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("Data should still exist after concurrent operations")
 	}
@@ -358,7 +358,7 @@ func TestMemoryManagementIntegration(t *testing.T) {
 		if isNew {
 			addedMarkets++
 		}
-		books.Set(market.Symbol, types.TopOfBook{BidPx: 100.0, AskPx: 101.0})
+		books.Set(market.Key(), types.TopOfBook{BidPx: 100.0, AskPx: 101.0})
 	}
 
 	// Verify we added some markets (exact count may vary due to duplicates)
@@ -389,13 +389,13 @@ func BenchmarkFullSystem(b *testing.B) {
 	markets := testutils.CreateTestMarkets()
 	for _, market := range markets {
 		reg.UpsertMarket(market)
-		reg.SetFee(market.Symbol, types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+		reg.SetFee(market.Key(), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
 		idx.AddMarket(market)
 	}
 
 	tobData := testutils.CreateProfitableArbitragePrices()
-	for symbol, tob := range tobData {
-		books.Set(symbol, tob)
+	for key, tob := range tobData {
+		books.Set(key, tob)
 	}
 
 	b.ResetTimer()
@@ -1,6 +1,8 @@
 package apiout
 
 import (
+	"fmt"
+
 	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
 	"github.com/armagg/circular-arbitrage-finder/pkg/types"
 
@@ -12,21 +14,34 @@ type Publisher interface {
 	Publish(p types.Plan) error
 }
 
+// RejectedError is returned by Publish when the executor turned the plan down.
+type RejectedError struct {
+	Reason string
+}
+
+func (e *RejectedError) Error() string {
+	return "plan rejected by executor: " + e.Reason
+}
+
 
 type LogPublisher struct{}
 
 func (p LogPublisher) Publish(plan types.Plan) error {
-	logger.Log.WithFields(logrus.Fields{
+	fields := logrus.Fields{
 		"exchange":       plan.Exchange,
 		"profit_quote":   plan.ExpectedProfitQuote,
 		"quote_currency": plan.QuoteCurrency,
-		"leg1":           formatLeg(plan.Legs[0]),
-		"leg2":           formatLeg(plan.Legs[1]),
-		"leg3":           formatLeg(plan.Legs[2]),
-	}).Info("publishing plan")
+	}
+	for i, leg := range plan.Legs {
+		fields[fmt.Sprintf("leg%d", i+1)] = formatLeg(leg)
+	}
+	logger.Log.WithFields(fields).Info("publishing plan")
 	return nil
 }
 
-func formatLeg(leg types.TriangleLeg) string {
-	return string(leg.Side) + " " + leg.Market
+func formatLeg(leg types.Leg) string {
+	if leg.Exchange == "" {
+		return string(leg.Side) + " " + leg.Market
+	}
+	return string(leg.Side) + " " + leg.Exchange + ":" + leg.Market
 }
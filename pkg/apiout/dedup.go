@@ -0,0 +1,104 @@
+package apiout
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// ErrDuplicate is returned by DedupPublisher for plans it held back.
+var ErrDuplicate = errors.New("plan repeats one already published")
+
+// PlanID is stable for a plan's content: a hash of its route followed by a
+// hash of its sizes and prices. Plans on the same route share the prefix.
+func PlanID(plan types.Plan) string {
+	route := fnv.New32a()
+	route.Write([]byte(plan.Route()))
+	content := fnv.New32a()
+	for _, l := range plan.Legs {
+		fmt.Fprintf(content, "%g@%g;", l.Qty, l.LimitPrice)
+	}
+	return fmt.Sprintf("%08x-%08x", route.Sum32(), content.Sum32())
+}
+
+type sentPlan struct {
+	at     time.Time
+	profit float64
+	amount float64
+}
+
+// DedupPublisher holds back plans on a route that was published less than
+// Window ago, unless the expected profit or the amount traded moved by more
+// than MinChange (relative, 0.05 = 5%) since then.
+type DedupPublisher struct {
+	Next      Publisher
+	Window    time.Duration
+	MinChange float64
+	Now       func() time.Time
+
+	mu   sync.Mutex
+	sent map[string]sentPlan
+}
+
+func NewDedupPublisher(next Publisher, window time.Duration, minChange float64) *DedupPublisher {
+	return &DedupPublisher{Next: next, Window: window, MinChange: minChange, Now: time.Now, sent: make(map[string]sentPlan)}
+}
+
+func (p *DedupPublisher) Publish(plan types.Plan) error {
+	route := plan.Route()
+	now := p.Now()
+	cur := sentPlan{at: now, profit: plan.ExpectedProfitQuote, amount: plan.StartAmount()}
+
+	p.mu.Lock()
+	last, ok := p.sent[route]
+	if ok && now.Sub(last.at) < p.Window && !p.changed(last, cur) {
+		p.mu.Unlock()
+		return ErrDuplicate
+	}
+	p.sent[route] = cur
+	// Forget routes whose window has passed so the map does not grow forever.
+	if len(p.sent) > 4096 {
+		for k, v := range p.sent {
+			if now.Sub(v.at) >= p.Window {
+				delete(p.sent, k)
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	// Only a plan that went out, or is queued to, holds back the route. A
+	// failed or dropped one leaves the route as it was, so a retry is let
+	// through.
+	err := p.Next.Publish(plan)
+	if err != nil && !errors.Is(err, ErrQueued) {
+		p.mu.Lock()
+		if p.sent[route] == cur {
+			if ok {
+				p.sent[route] = last
+			} else {
+				delete(p.sent, route)
+			}
+		}
+		p.mu.Unlock()
+	}
+	return err
+}
+
+func (p *DedupPublisher) changed(last, cur sentPlan) bool {
+	return relChange(last.profit, cur.profit) > p.MinChange || relChange(last.amount, cur.amount) > p.MinChange
+}
+
+func relChange(old, cur float64) float64 {
+	if old == 0 {
+		if cur == 0 {
+			return 0
+		}
+		return math.Inf(1)
+	}
+	return math.Abs(cur-old) / math.Abs(old)
+}
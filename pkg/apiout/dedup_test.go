@@ -0,0 +1,126 @@
+package apiout
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+type recordingPublisher struct {
+	plans []types.Plan
+}
+
+func (r *recordingPublisher) Publish(plan types.Plan) error {
+	r.plans = append(r.plans, plan)
+	return nil
+}
+
+func dedupTestPlan(profit, ethQty float64) types.Plan {
+	return types.Plan{
+		Exchange: "BINANCE",
+		Legs: []types.Leg{
+			{Exchange: "BINANCE", Market: "ETHUSDT", Side: types.SideBuy, Qty: ethQty, LimitPrice: 3000},
+			{Exchange: "BINANCE", Market: "ETHBTC", Side: types.SideSell, Qty: ethQty, LimitPrice: 0.0605},
+			{Exchange: "BINANCE", Market: "BTCUSDT", Side: types.SideSell, Qty: ethQty * 0.0605, LimitPrice: 50100},
+		},
+		ExpectedProfitQuote: profit,
+		QuoteCurrency:       "USDT",
+	}
+}
+
+func TestPlanID(t *testing.T) {
+	a := PlanID(dedupTestPlan(1.0, 0.1))
+	if a != PlanID(dedupTestPlan(1.0, 0.1)) {
+		t.Error("Expected identical plans to share an ID")
+	}
+	b := PlanID(dedupTestPlan(1.2, 0.12))
+	if a == b {
+		t.Error("Expected a different size to change the ID")
+	}
+	if strings.Split(a, "-")[0] != strings.Split(b, "-")[0] {
+		t.Errorf("Expected plans on one route to share the route hash, got %s and %s", a, b)
+	}
+
+	reverse := dedupTestPlan(1.0, 0.1)
+	reverse.Legs[0].Side = types.SideSell
+	if strings.Split(PlanID(reverse), "-")[0] == strings.Split(a, "-")[0] {
+		t.Error("Expected another route to change the route hash")
+	}
+}
+
+func TestDedupPublisher(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	next := &recordingPublisher{}
+	pub := NewDedupPublisher(next, time.Second, 0.05)
+	pub.Now = func() time.Time { return now }
+
+	steps := []struct {
+		name      string
+		advance   time.Duration
+		plan      types.Plan
+		published bool
+	}{
+		{"First plan", 0, dedupTestPlan(1.0, 0.1), true},
+		{"Identical plan", 10 * time.Millisecond, dedupTestPlan(1.0, 0.1), false},
+		{"Small profit change", 10 * time.Millisecond, dedupTestPlan(1.04, 0.1), false},
+		{"Material profit change", 10 * time.Millisecond, dedupTestPlan(1.2, 0.1), true},
+		{"Material size change", 10 * time.Millisecond, dedupTestPlan(1.2, 0.2), true},
+		{"Window passed", time.Second, dedupTestPlan(1.2, 0.2), true},
+	}
+
+	for _, step := range steps {
+		now = now.Add(step.advance)
+		err := pub.Publish(step.plan)
+		if step.published && err != nil {
+			t.Errorf("%s: unexpected error %v", step.name, err)
+		}
+		if !step.published && !errors.Is(err, ErrDuplicate) {
+			t.Errorf("%s: expected ErrDuplicate, got %v", step.name, err)
+		}
+	}
+	if len(next.plans) != 4 {
+		t.Errorf("Expected 4 plans to reach the executor, got %d", len(next.plans))
+	}
+
+	// Another route is never held back by this one
+	other := dedupTestPlan(1.2, 0.2)
+	other.Legs[1].Exchange = "KUCOIN"
+	if err := pub.Publish(other); err != nil {
+		t.Errorf("Expected another route to publish, got %v", err)
+	}
+}
+
+func TestDedupPublisherRetriesFailedPlans(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var result error
+	var calls int
+	pub := NewDedupPublisher(publisherFunc(func(types.Plan) error { calls++; return result }), time.Second, 0.05)
+	pub.Now = func() time.Time { return now }
+
+	// A plan that failed or was dropped does not hold back its retry
+	for _, err := range []error{errors.New("connection refused"), ErrQueueFull} {
+		result = err
+		if got := pub.Publish(dedupTestPlan(1.0, 0.1)); got != err {
+			t.Fatalf("Expected %v, got %v", err, got)
+		}
+	}
+
+	// A queued plan does, and so does the last plan that went out when a
+	// later one fails
+	result = ErrQueued
+	pub.Publish(dedupTestPlan(1.0, 0.1))
+	if err := pub.Publish(dedupTestPlan(1.0, 0.1)); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Expected a queued plan to hold back repeats, got %v", err)
+	}
+	result = errors.New("timeout")
+	pub.Publish(dedupTestPlan(2.0, 0.1))
+	if err := pub.Publish(dedupTestPlan(1.0, 0.1)); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Expected the queued plan to still hold back repeats, got %v", err)
+	}
+	if calls != 4 {
+		t.Errorf("Expected 4 plans to reach the next publisher, got %d", calls)
+	}
+}
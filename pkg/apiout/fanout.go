@@ -0,0 +1,215 @@
+package apiout
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Filter selects the plans a sink receives. Empty fields match everything.
+type Filter struct {
+	Exchanges       []string // every leg must trade on one of these
+	MinProfit       float64
+	QuoteCurrencies []string
+}
+
+func (f Filter) Match(plan types.Plan) bool {
+	if plan.ExpectedProfitQuote < f.MinProfit {
+		return false
+	}
+	if len(f.QuoteCurrencies) > 0 && !containsFold(f.QuoteCurrencies, plan.QuoteCurrency) {
+		return false
+	}
+	if len(f.Exchanges) > 0 {
+		for _, l := range plan.Legs {
+			exchange := l.Exchange
+			if exchange == "" {
+				exchange = plan.Exchange
+			}
+			if !containsFold(f.Exchanges, exchange) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrSinkTimeout is returned for a sink that did not answer within its
+// Timeout.
+var ErrSinkTimeout = errors.New("sink timed out")
+
+// Sink is one destination of a FanoutPublisher. Errors from a sink with
+// Feedback unset are only logged, so a paper executor's rejections never
+// cool down cycles for the live one.
+type Sink struct {
+	Name      string
+	Publisher Publisher
+	Filter    Filter
+	Feedback  bool
+	// Timeout bounds how long an unqueued fanout waits for the sink; zero
+	// waits as long as it takes.
+	Timeout time.Duration
+}
+
+// FanoutPublisher sends every plan to each sink whose filter it passes.
+// Sinks publish concurrently and a failing sink never stops the others.
+// Built with NewFanoutPublisher, Publish waits for every sink, so the
+// slowest one holds up the caller up to its Timeout. Built with
+// NewQueuedFanoutPublisher, each sink has its own queue instead, so a slow
+// sink only holds up itself.
+type FanoutPublisher struct {
+	Sinks []Sink
+	// OnResult gets the reply of each Feedback sink to a queued plan; may
+	// be nil.
+	OnResult func(plan types.Plan, err error)
+
+	queues []*QueuedPublisher // one per sink when queued
+}
+
+func NewFanoutPublisher(sinks ...Sink) *FanoutPublisher {
+	return &FanoutPublisher{Sinks: sinks}
+}
+
+// NewQueuedFanoutPublisher gives every sink a queue of capacity plans,
+// dropping the oldest when full, and one worker. Call Close to drain them.
+func NewQueuedFanoutPublisher(capacity int, sinks ...Sink) *FanoutPublisher {
+	p := &FanoutPublisher{Sinks: sinks, queues: make([]*QueuedPublisher, len(sinks))}
+	for i, sink := range sinks {
+		p.queues[i] = NewQueuedPublisher(sink.Publisher, capacity, 1, DropOldest)
+		p.queues[i].OnResult = func(plan types.Plan, err error) {
+			if err != nil {
+				err = fmt.Errorf("sink %s: %w", sink.Name, err)
+			}
+			if !sink.Feedback {
+				p.logFailure(sink, plan, err)
+				return
+			}
+			if p.OnResult != nil {
+				p.OnResult(plan, err)
+			}
+		}
+	}
+	return p
+}
+
+func (p *FanoutPublisher) Publish(plan types.Plan) error {
+	if p.queues != nil {
+		return p.enqueue(plan)
+	}
+	errs := make([]error, len(p.Sinks))
+	var wg sync.WaitGroup
+	for i, sink := range p.Sinks {
+		if !sink.Filter.Match(plan) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := publishWithin(sink, plan); err != nil {
+				errs[i] = fmt.Errorf("sink %s: %w", sink.Name, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var reported []error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if p.Sinks[i].Feedback {
+			reported = append(reported, err)
+			continue
+		}
+		p.logFailure(p.Sinks[i], plan, err)
+	}
+	return errors.Join(reported...)
+}
+
+// publishWithin gives up on sink after its Timeout. The sink's Publish keeps
+// running until it returns, and its result is then dropped.
+func publishWithin(sink Sink, plan types.Plan) error {
+	if sink.Timeout <= 0 {
+		return sink.Publisher.Publish(plan)
+	}
+	done := make(chan error, 1)
+	go func() { done <- sink.Publisher.Publish(plan) }()
+	timer := time.NewTimer(sink.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return ErrSinkTimeout
+	}
+}
+
+// enqueue returns ErrQueued when a Feedback sink took the plan, so its reply
+// arrives through OnResult, and the error of a Feedback sink that could not.
+func (p *FanoutPublisher) enqueue(plan types.Plan) error {
+	var queued bool
+	var reported []error
+	for i, sink := range p.Sinks {
+		if !sink.Filter.Match(plan) {
+			continue
+		}
+		err := p.queues[i].Publish(plan)
+		if errors.Is(err, ErrQueued) {
+			queued = queued || sink.Feedback
+			continue
+		}
+		err = fmt.Errorf("sink %s: %w", sink.Name, err)
+		if sink.Feedback {
+			reported = append(reported, err)
+			continue
+		}
+		p.logFailure(sink, plan, err)
+	}
+	if queued {
+		return ErrQueued
+	}
+	return errors.Join(reported...)
+}
+
+func (p *FanoutPublisher) logFailure(sink Sink, plan types.Plan, err error) {
+	if err == nil {
+		return
+	}
+	logger.Log.WithFields(logrus.Fields{"sink": sink.Name, "plan_id": plan.PlanID, "error": err}).Warn("apiout: sink failed to publish plan")
+}
+
+// Stats returns the queue counts of each sink by name, or nil when sinks
+// are not queued.
+func (p *FanoutPublisher) Stats() map[string]QueueStats {
+	if p.queues == nil {
+		return nil
+	}
+	res := make(map[string]QueueStats, len(p.queues))
+	for i, q := range p.queues {
+		res[p.Sinks[i].Name] = q.Stats()
+	}
+	return res
+}
+
+// Close drains the sink queues. It does nothing when sinks are not queued.
+func (p *FanoutPublisher) Close() {
+	for _, q := range p.queues {
+		q.Close()
+	}
+}
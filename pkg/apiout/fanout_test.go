@@ -0,0 +1,163 @@
+package apiout
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+func TestFilterMatch(t *testing.T) {
+	plan := dedupTestPlan(1.0, 0.1)
+	cross := dedupTestPlan(1.0, 0.1)
+	cross.Legs[1].Exchange = "KUCOIN"
+
+	tests := []struct {
+		name   string
+		filter Filter
+		plan   types.Plan
+		want   bool
+	}{
+		{"Empty filter", Filter{}, plan, true},
+		{"Exchange listed", Filter{Exchanges: []string{"binance"}}, plan, true},
+		{"Exchange not listed", Filter{Exchanges: []string{"KUCOIN"}}, plan, false},
+		{"One leg elsewhere", Filter{Exchanges: []string{"BINANCE"}}, cross, false},
+		{"Every leg listed", Filter{Exchanges: []string{"BINANCE", "KUCOIN"}}, cross, true},
+		{"Profit too small", Filter{MinProfit: 2}, plan, false},
+		{"Profit enough", Filter{MinProfit: 1}, plan, true},
+		{"Quote listed", Filter{QuoteCurrencies: []string{"usdt"}}, plan, true},
+		{"Quote not listed", Filter{QuoteCurrencies: []string{"BTC"}}, plan, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Match(tt.plan); got != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFanoutPublisher(t *testing.T) {
+	live := &recordingPublisher{}
+	paper := publisherFunc(func(types.Plan) error { return &RejectedError{Reason: "paper"} })
+	kucoin := &recordingPublisher{}
+
+	pub := NewFanoutPublisher(
+		Sink{Name: "live", Publisher: live, Feedback: true},
+		Sink{Name: "paper", Publisher: paper},
+		Sink{Name: "kucoin", Publisher: kucoin, Filter: Filter{Exchanges: []string{"KUCOIN"}}},
+		Sink{Name: "picky", Publisher: kucoin, Filter: Filter{MinProfit: 10}},
+	)
+	if err := pub.Publish(dedupTestPlan(1.0, 0.1)); err != nil {
+		t.Errorf("Expected the paper sink's rejection to stay out of the result, got %v", err)
+	}
+	if len(live.plans) != 1 || len(kucoin.plans) != 0 {
+		t.Errorf("Expected only the live sink to get the plan, got %d and %d", len(live.plans), len(kucoin.plans))
+	}
+
+	stuck := make(chan struct{})
+	slow := publisherFunc(func(types.Plan) error { <-stuck; return nil })
+	// A sink that hangs holds up the call but not the other sinks
+	published := make(chan struct{}, 1)
+	hanging := NewFanoutPublisher(
+		Sink{Name: "live", Publisher: publisherFunc(func(types.Plan) error { published <- struct{}{}; return nil })},
+		Sink{Name: "slow", Publisher: slow},
+	)
+	done := make(chan error)
+	go func() { done <- hanging.Publish(dedupTestPlan(1.0, 0.1)) }()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Error("Expected the live sink to publish while another sink hangs")
+	}
+	close(stuck)
+	<-done
+
+	failing := NewFanoutPublisher(
+		Sink{Name: "live", Publisher: paper, Feedback: true},
+		Sink{Name: "log", Publisher: LogPublisher{}},
+	)
+	var rejected *RejectedError
+	if err := failing.Publish(dedupTestPlan(1.0, 0.1)); !errors.As(err, &rejected) || rejected.Reason != "paper" {
+		t.Errorf("Expected the feedback sink's rejection, got %v", err)
+	}
+}
+
+func TestFanoutPublisherSinkTimeout(t *testing.T) {
+	stuck := &gatedPublisher{release: make(chan struct{})}
+	defer close(stuck.release)
+	pub := NewFanoutPublisher(
+		Sink{Name: "webhook", Publisher: stuck, Feedback: true, Timeout: 20 * time.Millisecond},
+		Sink{Name: "log", Publisher: publisherFunc(func(types.Plan) error { return nil })},
+	)
+
+	done := make(chan error, 1)
+	go func() { done <- pub.Publish(queueTestPlan("A", 1)) }()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrSinkTimeout) || !strings.Contains(err.Error(), "sink webhook") {
+			t.Errorf("Expected the webhook to time out, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Publish to stop waiting for the blocked sink")
+	}
+}
+
+func TestQueuedFanoutPublisherIsolatesSinks(t *testing.T) {
+	stuck := &gatedPublisher{release: make(chan struct{})}
+	log := make(chan types.Plan, 4)
+	live := make(chan types.Plan, 4)
+	pub := NewQueuedFanoutPublisher(2,
+		Sink{Name: "webhook", Publisher: stuck},
+		Sink{Name: "log", Publisher: publisherFunc(func(plan types.Plan) error { log <- plan; return nil })},
+		Sink{Name: "live", Publisher: publisherFunc(func(plan types.Plan) error { live <- plan; return &RejectedError{Reason: "stale"} }), Feedback: true},
+	)
+	results := make(chan error, 4)
+	pub.OnResult = func(plan types.Plan, err error) { results <- err }
+
+	// The webhook never answers, yet every call returns and the other
+	// sinks see every plan
+	for i := 0; i < 3; i++ {
+		done := make(chan error, 1)
+		go func() { done <- pub.Publish(queueTestPlan("A", float64(i+1))) }()
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrQueued) {
+				t.Errorf("Expected ErrQueued for the live sink, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected Publish not to wait for the blocked sink")
+		}
+		for name, ch := range map[string]chan types.Plan{"log": log, "live": live} {
+			select {
+			case <-ch:
+			case <-time.After(time.Second):
+				t.Fatalf("Expected the %s sink to get plan %d while the webhook blocks", name, i)
+			}
+		}
+		var rejected *RejectedError
+		if err := <-results; !errors.As(err, &rejected) || !strings.Contains(err.Error(), "sink live") {
+			t.Errorf("Expected the live sink's rejection through OnResult, got %v", err)
+		}
+	}
+
+	// One plan in flight and two queued: nothing dropped yet
+	if s := pub.Stats()["webhook"]; s.Depth != 2 || s.Dropped != 0 {
+		t.Errorf("Unexpected webhook queue %+v", s)
+	}
+	pub.Publish(queueTestPlan("A", 4))
+	if s := pub.Stats()["webhook"]; s.Dropped != 1 {
+		t.Errorf("Expected the full webhook queue to drop its oldest plan, got %+v", s)
+	}
+	close(stuck.release)
+	pub.Close()
+	if len(stuck.plans) != 3 {
+		t.Errorf("Expected the webhook to publish what it had queued, got %d plans", len(stuck.plans))
+	}
+	if len(results) != 1 {
+		t.Errorf("Expected only feedback sinks to report, got %d more results", len(results))
+	}
+}
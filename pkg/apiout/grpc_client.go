@@ -20,13 +20,14 @@ func NewGRPCPublisher(conn *grpc.ClientConn) *GRPCPublisher {
 }
 
 func (p *GRPCPublisher) Publish(plan types.Plan) error {
-	legs := make([]*exppb.TriangleLeg, 0, 3)
+	legs := make([]*exppb.TriangleLeg, 0, len(plan.Legs))
 	for _, l := range plan.Legs {
 		legs = append(legs, &exppb.TriangleLeg{
 			Market:     l.Market,
 			Side:       string(l.Side),
 			Qty:        l.Qty,
 			LimitPrice: l.LimitPrice,
+			Exchange:   l.Exchange,
 		})
 	}
 	req := &exppb.Plan{
@@ -40,7 +41,13 @@ func (p *GRPCPublisher) Publish(plan types.Plan) error {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
 	defer cancel()
-	_, err := p.client.ProposePlan(ctx, req)
-	return err
+	reply, err := p.client.ProposePlan(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !reply.GetAccepted() {
+		return &RejectedError{Reason: reply.GetReason()}
+	}
+	return nil
 }
 
@@ -0,0 +1,73 @@
+package apiout
+
+import (
+	"errors"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/metrics"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	publishDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{Name: "arb_publish_duration_seconds", Help: "Time spent in Publish, per publisher.", Buckets: metrics.DefaultBuckets}, []string{"publisher"})
+	publishErrors   = promauto.NewCounterVec(prometheus.CounterOpts{Name: "arb_publish_errors_total", Help: "Plans a publisher did not publish, per publisher and kind (rejected, duplicate, queue_full or failed)."}, []string{"publisher", "kind"})
+	endToEnd        = promauto.NewHistogram(prometheus.HistogramOpts{Name: "arb_delta_to_publish_seconds", Help: "Time from the newest quote a plan was priced from (delta ts_ns) to the plan being published.", Buckets: metrics.DefaultBuckets})
+)
+
+// InstrumentedPublisher times Next and counts its errors under Name. With
+// EndToEnd set it also observes how old the plan's newest quote is once
+// published.
+type InstrumentedPublisher struct {
+	Name     string
+	Next     Publisher
+	EndToEnd bool
+	Now      func() time.Time
+}
+
+func NewInstrumentedPublisher(name string, next Publisher) *InstrumentedPublisher {
+	return &InstrumentedPublisher{Name: name, Next: next, Now: time.Now}
+}
+
+func (p *InstrumentedPublisher) Publish(plan types.Plan) error {
+	start := p.Now()
+	err := p.Next.Publish(plan)
+	end := p.Now()
+	publishDuration.WithLabelValues(p.Name).Observe(end.Sub(start).Seconds())
+
+	// A queued plan is handed on; the queue's own publishers report how
+	// it went
+	var rejected *RejectedError
+	switch {
+	case err == nil || errors.Is(err, ErrQueued):
+	case errors.As(err, &rejected):
+		publishErrors.WithLabelValues(p.Name, "rejected").Inc()
+	case errors.Is(err, ErrDuplicate):
+		publishErrors.WithLabelValues(p.Name, "duplicate").Inc()
+	case errors.Is(err, ErrQueueFull):
+		publishErrors.WithLabelValues(p.Name, "queue_full").Inc()
+	default:
+		publishErrors.WithLabelValues(p.Name, "failed").Inc()
+	}
+	if p.EndToEnd && (err == nil || errors.Is(err, ErrQueued)) {
+		if ts := newestQuote(plan); ts > 0 {
+			endToEnd.Observe(end.Sub(time.Unix(0, ts)).Seconds())
+		}
+	}
+	return err
+}
+
+func newestQuote(plan types.Plan) int64 {
+	if plan.Decision == nil {
+		return 0
+	}
+	var newest int64
+	for _, q := range plan.Decision.Quotes {
+		if q.TsNs > newest {
+			newest = q.TsNs
+		}
+	}
+	return newest
+}
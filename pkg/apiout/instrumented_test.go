@@ -0,0 +1,101 @@
+package apiout
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// observations returns how many values h has observed.
+func observations(t *testing.T, h prometheus.Observer) uint64 {
+	t.Helper()
+	var m dto.Metric
+	if err := h.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestInstrumentedPublisher(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	var result error
+	pub := NewInstrumentedPublisher("test-sink", publisherFunc(func(types.Plan) error {
+		now = now.Add(20 * time.Millisecond)
+		return result
+	}))
+	pub.Now = func() time.Time { return now }
+	pub.EndToEnd = true
+
+	plan := dedupTestPlan(1.0, 0.1)
+	plan.Decision = &types.Decision{Quotes: []types.TopOfBook{
+		{TsNs: now.Add(-time.Second).UnixNano()},
+		{TsNs: now.Add(-30 * time.Millisecond).UnixNano()},
+	}}
+	e2eBefore := observations(t, endToEnd)
+	if err := pub.Publish(plan); err != nil {
+		t.Fatal(err)
+	}
+	if n := observations(t, publishDuration.WithLabelValues("test-sink")); n != 1 {
+		t.Errorf("Expected one timing, got %d", n)
+	}
+	if n := observations(t, endToEnd); n != e2eBefore+1 {
+		t.Errorf("Expected one end-to-end observation, got %d", n-e2eBefore)
+	}
+
+	result = &RejectedError{Reason: "stale"}
+	pub.Publish(plan)
+	result = errors.New("connection refused")
+	pub.Publish(plan)
+	if v := testutil.ToFloat64(publishErrors.WithLabelValues("test-sink", "rejected")); v != 1 {
+		t.Errorf("Expected one rejection, got %f", v)
+	}
+	if v := testutil.ToFloat64(publishErrors.WithLabelValues("test-sink", "failed")); v != 1 {
+		t.Errorf("Expected one failure, got %f", v)
+	}
+	if n := observations(t, endToEnd); n != e2eBefore+1 {
+		t.Error("Expected failed publishes to stay out of the end-to-end latency")
+	}
+}
+
+func TestInstrumentedPublisherQueuedFanout(t *testing.T) {
+	sink := publisherFunc(func(types.Plan) error { return nil })
+	fanout := NewQueuedFanoutPublisher(8, Sink{Name: "journal", Publisher: sink, Feedback: true})
+	defer fanout.Close()
+	pub := NewInstrumentedPublisher("queued-all", fanout)
+	pub.EndToEnd = true
+
+	plan := dedupTestPlan(1.0, 0.1)
+	plan.Decision = &types.Decision{Quotes: []types.TopOfBook{{TsNs: time.Now().UnixNano()}}}
+	e2eBefore := observations(t, endToEnd)
+	if err := pub.Publish(plan); !errors.Is(err, ErrQueued) {
+		t.Fatalf("Expected the plan to be queued, got %v", err)
+	}
+	if v := testutil.ToFloat64(publishErrors.WithLabelValues("queued-all", "failed")); v != 0 {
+		t.Errorf("Expected a queued plan not to count as failed, got %f", v)
+	}
+	if n := observations(t, publishDuration.WithLabelValues("queued-all")); n != 1 {
+		t.Errorf("Expected one timing, got %d", n)
+	}
+	if n := observations(t, endToEnd); n != e2eBefore+1 {
+		t.Errorf("Expected a queued plan to be observed end to end, got %d", n-e2eBefore)
+	}
+
+	for _, err := range []error{ErrDuplicate, ErrQueueFull} {
+		pub.Next = publisherFunc(func(types.Plan) error { return err })
+		pub.Publish(plan)
+	}
+	for _, kind := range []string{"duplicate", "queue_full"} {
+		if v := testutil.ToFloat64(publishErrors.WithLabelValues("queued-all", kind)); v != 1 {
+			t.Errorf("Expected one %s, got %f", kind, v)
+		}
+	}
+	if v := testutil.ToFloat64(publishErrors.WithLabelValues("queued-all", "failed")); v != 0 {
+		t.Errorf("Expected no failures, got %f", v)
+	}
+}
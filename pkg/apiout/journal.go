@@ -0,0 +1,99 @@
+package apiout
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+type journalRecord struct {
+	Time time.Time  `json:"time"`
+	Plan types.Plan `json:"plan"`
+	Edge float64    `json:"edge"`
+}
+
+// JournalPublisher appends every plan as one JSON line to a file in Dir.
+// It starts a new file once the current one holds MaxBytes or is older than
+// MaxAge; zero turns either limit off.
+type JournalPublisher struct {
+	Dir      string
+	MaxBytes int64
+	MaxAge   time.Duration
+	Now      func() time.Time
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	created time.Time
+}
+
+func NewJournalPublisher(dir string, maxBytes int64, maxAge time.Duration) (*JournalPublisher, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	return &JournalPublisher{Dir: dir, MaxBytes: maxBytes, MaxAge: maxAge, Now: time.Now}, nil
+}
+
+func (p *JournalPublisher) Publish(plan types.Plan) error {
+	now := p.Now()
+	line, err := json.Marshal(journalRecord{Time: now.UTC(), Plan: plan, Edge: plan.Edge()})
+	if err != nil {
+		return fmt.Errorf("failed to encode journal record: %w", err)
+	}
+	line = append(line, '\n')
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil || p.full(now) {
+		if err := p.rotate(now); err != nil {
+			return err
+		}
+	}
+	n, err := p.file.Write(line)
+	p.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write journal record: %w", err)
+	}
+	return nil
+}
+
+func (p *JournalPublisher) full(now time.Time) bool {
+	return (p.MaxBytes > 0 && p.size >= p.MaxBytes) || (p.MaxAge > 0 && now.Sub(p.created) >= p.MaxAge)
+}
+
+func (p *JournalPublisher) rotate(now time.Time) error {
+	if p.file != nil {
+		if err := p.file.Close(); err != nil {
+			return fmt.Errorf("failed to close journal file: %w", err)
+		}
+		p.file = nil
+	}
+	name := filepath.Join(p.Dir, "plans-"+now.UTC().Format("20060102T150405.000000000Z")+".jsonl")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat journal file: %w", err)
+	}
+	p.file, p.size, p.created = f, info.Size(), now
+	return nil
+}
+
+func (p *JournalPublisher) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
@@ -0,0 +1,124 @@
+package apiout
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+func readJournal(t *testing.T, dir string) [][]journalRecord {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "plans-*.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	var files [][]journalRecord
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []journalRecord
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var r journalRecord
+			if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+				t.Fatalf("Invalid journal line %q: %v", scanner.Text(), err)
+			}
+			records = append(records, r)
+		}
+		f.Close()
+		files = append(files, records)
+	}
+	return files
+}
+
+func TestJournalPublisher(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+	pub, err := NewJournalPublisher(dir, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.Now = func() time.Time { return now }
+
+	plan := dedupTestPlan(3.0, 0.1)
+	plan.PlanID = PlanID(plan)
+	plan.Decision = &types.Decision{
+		MarketIds: []int{1, 2, 0},
+		Quotes:    []types.TopOfBook{{BidPx: 2999, AskPx: 3000}, {BidPx: 0.0605, AskPx: 0.0606}, {BidPx: 50100, AskPx: 50110}},
+		Fees:      []types.Fee{{TakerBp: 10}, {TakerBp: 10}, {TakerBp: 10}},
+		TsNs:      now.UnixNano(),
+	}
+	if err := pub.Publish(plan); err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Publish(dedupTestPlan(1.0, 0.1)); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour)
+	if err := pub.Publish(dedupTestPlan(2.0, 0.1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := readJournal(t, dir)
+	if len(files) != 2 || len(files[0]) != 2 || len(files[1]) != 1 {
+		t.Fatalf("Expected an hour-old journal to rotate, got %d files", len(files))
+	}
+	got := files[0][0]
+	if got.Plan.PlanID != plan.PlanID || len(got.Plan.Legs) != 3 {
+		t.Errorf("Expected the full plan, got %+v", got.Plan)
+	}
+	if got.Plan.Decision == nil || got.Plan.Decision.MarketIds[0] != 1 || got.Plan.Decision.Quotes[2].BidPx != 50100 || got.Plan.Decision.Fees[1].TakerBp != 10 {
+		t.Errorf("Expected the decision context, got %+v", got.Plan.Decision)
+	}
+	if got.Edge != 0.01 {
+		t.Errorf("Expected an edge of 3/300, got %f", got.Edge)
+	}
+	if files[1][0].Plan.Decision != nil {
+		t.Error("Expected no decision for a plan without one")
+	}
+
+	names, _ := filepath.Glob(filepath.Join(dir, "plans-*.jsonl"))
+	sort.Strings(names)
+	raw, err := os.ReadFile(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{`"expected_profit_quote":3`, `"limit_price":`, `"plan_id":`, `"market_ids":[1,2,0]`, `"bid_px":2999`, `"taker_bp":10`} {
+		if !strings.Contains(string(raw), field) {
+			t.Errorf("Expected %s in the journal line, got %s", field, raw)
+		}
+	}
+}
+
+func TestJournalPublisherRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+	pub, err := NewJournalPublisher(dir, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub.Now = func() time.Time { now = now.Add(time.Millisecond); return now }
+	for i := 0; i < 3; i++ {
+		if err := pub.Publish(dedupTestPlan(1.0, 0.1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pub.Close()
+
+	if files := readJournal(t, dir); len(files) != 3 {
+		t.Errorf("Expected one file per record, got %d", len(files))
+	}
+}
@@ -0,0 +1,189 @@
+package apiout
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrQueued is returned by QueuedPublisher once a plan is queued. The
+	// outcome of publishing it is reported to OnResult later.
+	ErrQueued = errors.New("plan queued for publishing")
+	// ErrQueueFull is returned for plans the overflow policy dropped.
+	ErrQueueFull = errors.New("publish queue full")
+	// ErrQueueClosed is returned for plans offered after Close.
+	ErrQueueClosed = errors.New("publish queue closed")
+)
+
+// OverflowPolicy decides what a full queue does with one more plan.
+type OverflowPolicy string
+
+const (
+	DropOldest OverflowPolicy = "drop_oldest"
+	DropNewest OverflowPolicy = "drop_newest"
+	// BestPerRoute, once the queue is full, lets a plan replace the least
+	// profitable queued plan on its route if it beats it, and drops it
+	// otherwise. Below capacity every plan is queued.
+	BestPerRoute OverflowPolicy = "best_per_route"
+)
+
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(s); p {
+	case DropOldest, DropNewest, BestPerRoute:
+		return p, nil
+	case "":
+		return DropOldest, nil
+	}
+	return "", fmt.Errorf("unknown overflow policy %q", s)
+}
+
+// QueueStats counts what went through a QueuedPublisher.
+type QueueStats struct {
+	Depth     int
+	Enqueued  uint64
+	Published uint64
+	Failed    uint64
+	Dropped   uint64
+	Replaced  uint64 // queued plans superseded by a better one on the same route
+}
+
+// QueuedPublisher hands plans to Next from worker goroutines, so a slow
+// executor never blocks the caller. The queue holds at most Capacity plans;
+// Policy decides what happens beyond that.
+type QueuedPublisher struct {
+	Next     Publisher
+	Capacity int
+	Policy   OverflowPolicy
+	OnResult func(plan types.Plan, err error) // called from the workers; may be nil
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []types.Plan
+	closed bool
+	stats  QueueStats
+	wg     sync.WaitGroup
+}
+
+func NewQueuedPublisher(next Publisher, capacity, workers int, policy OverflowPolicy) *QueuedPublisher {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	q := &QueuedPublisher{Next: next, Capacity: capacity, Policy: policy}
+	q.cond = sync.NewCond(&q.mu)
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+func (q *QueuedPublisher) Publish(plan types.Plan) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	if len(q.queue) >= q.Capacity {
+		switch q.Policy {
+		case DropOldest:
+			q.queue = q.queue[1:]
+			q.stats.Dropped++
+		case BestPerRoute:
+			// Room is made only by superseding the least profitable plan
+			// already queued on the same route
+			worst := -1
+			route := plan.Route()
+			for i := range q.queue {
+				if q.queue[i].Route() == route && (worst < 0 || q.queue[i].ExpectedProfitQuote < q.queue[worst].ExpectedProfitQuote) {
+					worst = i
+				}
+			}
+			if worst < 0 || plan.ExpectedProfitQuote <= q.queue[worst].ExpectedProfitQuote {
+				q.stats.Dropped++
+				return ErrQueueFull
+			}
+			q.queue[worst] = plan
+			q.stats.Replaced++
+			return ErrQueued
+		default:
+			q.stats.Dropped++
+			return ErrQueueFull
+		}
+	}
+	q.queue = append(q.queue, plan)
+	q.stats.Enqueued++
+	q.cond.Signal()
+	return ErrQueued
+}
+
+func (q *QueuedPublisher) work() {
+	defer q.wg.Done()
+	for {
+		q.mu.Lock()
+		for len(q.queue) == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if len(q.queue) == 0 {
+			q.mu.Unlock()
+			return
+		}
+		plan := q.queue[0]
+		q.queue = q.queue[1:]
+		q.mu.Unlock()
+
+		err := q.Next.Publish(plan)
+		// Queued again further down, whose own OnResult reports the reply
+		handedOn := errors.Is(err, ErrQueued)
+
+		q.mu.Lock()
+		if err != nil && !handedOn {
+			q.stats.Failed++
+		} else {
+			q.stats.Published++
+		}
+		q.mu.Unlock()
+		if q.OnResult != nil && !handedOn {
+			q.OnResult(plan, err)
+		}
+	}
+}
+
+// Close stops taking plans and waits for the workers to publish what is
+// already queued.
+func (q *QueuedPublisher) Close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+func (q *QueuedPublisher) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	s := q.stats
+	s.Depth = len(q.queue)
+	return s
+}
+
+func (q *QueuedPublisher) LogStats() {
+	s := q.Stats()
+	logger.Log.WithFields(logrus.Fields{
+		"depth":     s.Depth,
+		"enqueued":  s.Enqueued,
+		"published": s.Published,
+		"failed":    s.Failed,
+		"dropped":   s.Dropped,
+		"replaced":  s.Replaced,
+	}).Info("apiout: publish queue")
+}
@@ -0,0 +1,143 @@
+package apiout
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// gatedPublisher blocks every Publish until release is closed.
+type gatedPublisher struct {
+	release chan struct{}
+	mu      sync.Mutex
+	plans   []types.Plan
+}
+
+func (g *gatedPublisher) Publish(plan types.Plan) error {
+	<-g.release
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.plans = append(g.plans, plan)
+	return nil
+}
+
+func queueTestPlan(market string, profit float64) types.Plan {
+	plan := dedupTestPlan(profit, 0.1)
+	plan.Legs[0].Market = market
+	return plan
+}
+
+// newStalledQueue returns a queue whose single worker holds one plan in
+// flight, so every further plan stays queued until release is closed.
+func newStalledQueue(t *testing.T, capacity int, policy OverflowPolicy) (*QueuedPublisher, *gatedPublisher) {
+	next := &gatedPublisher{release: make(chan struct{})}
+	q := NewQueuedPublisher(next, capacity, 1, policy)
+	if err := q.Publish(queueTestPlan("INFLIGHT", 1)); !errors.Is(err, ErrQueued) {
+		t.Fatalf("Expected ErrQueued, got %v", err)
+	}
+	for q.Stats().Depth != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	return q, next
+}
+
+func TestQueuedPublisherPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  OverflowPolicy
+		plans   []types.Plan
+		want    []string // markets of the first legs published after INFLIGHT
+		dropped uint64
+	}{
+		{"Drop oldest", DropOldest,
+			[]types.Plan{queueTestPlan("A", 1), queueTestPlan("B", 1), queueTestPlan("C", 1)},
+			[]string{"B", "C"}, 1},
+		{"Drop newest", DropNewest,
+			[]types.Plan{queueTestPlan("A", 1), queueTestPlan("B", 1), queueTestPlan("C", 1)},
+			[]string{"A", "B"}, 1},
+		{"Best per route", BestPerRoute,
+			[]types.Plan{queueTestPlan("A", 3), queueTestPlan("A", 1), queueTestPlan("B", 1), queueTestPlan("A", 0.5), queueTestPlan("A", 2)},
+			[]string{"A", "A"}, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, next := newStalledQueue(t, 2, tt.policy)
+			for _, plan := range tt.plans {
+				q.Publish(plan)
+			}
+			s := q.Stats()
+			if s.Depth != 2 || s.Dropped != tt.dropped {
+				t.Errorf("Expected depth 2 and %d dropped, got %+v", tt.dropped, s)
+			}
+
+			close(next.release)
+			q.Close()
+			if len(next.plans) != len(tt.want)+1 {
+				t.Fatalf("Expected %d plans published, got %d", len(tt.want)+1, len(next.plans))
+			}
+			for i, market := range tt.want {
+				if got := next.plans[i+1].Legs[0].Market; got != market {
+					t.Errorf("Plan %d: expected %s, got %s", i, market, got)
+				}
+			}
+			if tt.policy == BestPerRoute && (next.plans[1].ExpectedProfitQuote != 3 || next.plans[2].ExpectedProfitQuote != 2) {
+				t.Errorf("Expected A 2 to replace only the least profitable plan on route A, got %f and %f", next.plans[1].ExpectedProfitQuote, next.plans[2].ExpectedProfitQuote)
+			}
+			if s := q.Stats(); s.Depth != 0 || s.Published != uint64(len(tt.want)+1) {
+				t.Errorf("Unexpected stats after close %+v", s)
+			}
+		})
+	}
+}
+
+func TestQueuedPublisherResults(t *testing.T) {
+	var mu sync.Mutex
+	var results []error
+	rejecting := publisherFunc(func(types.Plan) error { return &RejectedError{Reason: "stale"} })
+	q := NewQueuedPublisher(rejecting, 8, 2, DropNewest)
+	q.OnResult = func(plan types.Plan, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		results = append(results, err)
+	}
+	for i := 0; i < 3; i++ {
+		q.Publish(queueTestPlan("A", 1))
+	}
+	q.Close()
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	var rejected *RejectedError
+	for _, err := range results {
+		if !errors.As(err, &rejected) {
+			t.Errorf("Expected the executor's rejection, got %v", err)
+		}
+	}
+	if s := q.Stats(); s.Failed != 3 {
+		t.Errorf("Expected 3 failures, got %+v", s)
+	}
+	if err := q.Publish(queueTestPlan("A", 1)); !errors.Is(err, ErrQueueClosed) {
+		t.Errorf("Expected ErrQueueClosed, got %v", err)
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	if p, err := ParseOverflowPolicy(""); err != nil || p != DropOldest {
+		t.Errorf("Expected drop_oldest by default, got %q %v", p, err)
+	}
+	if p, err := ParseOverflowPolicy("best_per_route"); err != nil || p != BestPerRoute {
+		t.Errorf("Expected best_per_route, got %q %v", p, err)
+	}
+	if _, err := ParseOverflowPolicy("drop_all"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+type publisherFunc func(types.Plan) error
+
+func (f publisherFunc) Publish(plan types.Plan) error { return f(plan) }
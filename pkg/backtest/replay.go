@@ -0,0 +1,134 @@
+// Package backtest replays captured market data through the detector.
+package backtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/capture"
+	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
+	mdpb "github.com/armagg/circular-arbitrage-finder/proto/md"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Clock is the simulated time of a replay: the receive time of the last
+// record applied.
+type Clock struct {
+	ns atomic.Int64
+}
+
+func (c *Clock) Now() time.Time {
+	return time.Unix(0, c.ns.Load())
+}
+
+func (c *Clock) Set(t time.Time) {
+	c.ns.Store(t.UnixNano())
+}
+
+// Files lists the capture files to replay: path itself, or every .cap file
+// in it when it is a directory, oldest first.
+func Files(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open capture path: %w", err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	files, err := filepath.Glob(filepath.Join(path, "*.cap"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list capture files: %w", err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no capture files in %s", path)
+	}
+	// Recorder file names start with their creation time
+	sort.Strings(files)
+	return files, nil
+}
+
+// Replayer hands every record of the capture files to Apply in order,
+// moving Clock to each record's receive time first. Speed 1 keeps the
+// recorded pace, 10 replays ten times faster and 0 as fast as possible.
+type Replayer struct {
+	Clock *Clock
+	Speed float64
+	Apply func(d *mdpb.OrderBookDelta)
+
+	last int64
+}
+
+// Run replays files and returns how many records it applied.
+func (r *Replayer) Run(ctx context.Context, files []string) (int, error) {
+	total := 0
+	for _, name := range files {
+		n, err := r.runFile(ctx, name)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func (r *Replayer) runFile(ctx context.Context, name string) (int, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open capture file: %w", err)
+	}
+	defer f.Close()
+	reader, err := capture.NewReader(f)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	n := 0
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			// The recorder was stopped mid-write; the rest of the file is lost
+			logger.Log.WithFields(logrus.Fields{"file": name, "records": n}).Warn("backtest: capture file ends in a partial record")
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		if err := r.wait(ctx, rec.RecvNs); err != nil {
+			return n, err
+		}
+		r.Clock.Set(time.Unix(0, rec.RecvNs))
+		r.Apply(rec.Delta)
+		n++
+	}
+}
+
+// wait sleeps for the recorded gap before a record, scaled by Speed.
+func (r *Replayer) wait(ctx context.Context, recvNs int64) error {
+	last := r.last
+	r.last = recvNs
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r.Speed <= 0 || last == 0 || recvNs <= last {
+		return nil
+	}
+	timer := time.NewTimer(time.Duration(float64(recvNs-last) / r.Speed))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
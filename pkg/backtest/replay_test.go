@@ -0,0 +1,121 @@
+package backtest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/capture"
+	mdpb "github.com/armagg/circular-arbitrage-finder/proto/md"
+)
+
+// writeCapture records one delta per receive time into dir.
+func writeCapture(t *testing.T, dir string, maxAge time.Duration, recv ...time.Time) {
+	t.Helper()
+	rec, err := capture.NewRecorder(dir, 0, maxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := 0
+	rec.Now = func() time.Time { return recv[i] }
+	for ; i < len(recv); i++ {
+		d := &mdpb.OrderBookDelta{Market: &mdpb.MarketId{Exchange: "BINANCE", Symbol: "ETHUSDT"}, Sequence: uint64(i + 1)}
+		if err := rec.Record(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplayerRun(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Unix(1700000000, 0)
+	recv := []time.Time{start, start.Add(time.Second), start.Add(time.Hour), start.Add(time.Hour + time.Second)}
+	writeCapture(t, dir, time.Hour, recv...)
+
+	files, err := Files(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 capture files, got %v", files)
+	}
+
+	clock := &Clock{}
+	var seqs []uint64
+	var times []time.Time
+	r := &Replayer{Clock: clock, Apply: func(d *mdpb.OrderBookDelta) {
+		seqs = append(seqs, d.GetSequence())
+		times = append(times, clock.Now())
+	}}
+	n, err := r.Run(context.Background(), files)
+	if err != nil || n != 4 {
+		t.Fatalf("Expected 4 records, got %d %v", n, err)
+	}
+	for i := range recv {
+		if seqs[i] != uint64(i+1) || !times[i].Equal(recv[i]) {
+			t.Errorf("Record %d: expected seq %d at %v, got %d at %v", i, i+1, recv[i], seqs[i], times[i])
+		}
+	}
+}
+
+func TestReplayerSpeed(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Unix(1700000000, 0)
+	writeCapture(t, dir, 0, start, start.Add(200*time.Millisecond))
+	files, _ := Files(dir)
+
+	r := &Replayer{Clock: &Clock{}, Speed: 10, Apply: func(*mdpb.OrderBookDelta) {}}
+	began := time.Now()
+	if _, err := r.Run(context.Background(), files); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(began); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected a 200ms gap to take 20ms at speed 10, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = &Replayer{Clock: &Clock{}, Apply: func(*mdpb.OrderBookDelta) {}}
+	if n, err := r.Run(ctx, files); err == nil || n != 0 {
+		t.Errorf("Expected a cancelled replay to stop, got %d %v", n, err)
+	}
+}
+
+func TestReplayerPartialRecord(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Unix(1700000000, 0)
+	writeCapture(t, dir, 0, start, start.Add(time.Second))
+	files, _ := Files(dir)
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(files[0], data[:len(data)-2], 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Replayer{Clock: &Clock{}, Apply: func(*mdpb.OrderBookDelta) {}}
+	if n, err := r.Run(context.Background(), files); err != nil || n != 1 {
+		t.Errorf("Expected the complete record to replay, got %d %v", n, err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Files(dir); err == nil {
+		t.Error("Expected an empty directory to fail")
+	}
+	if _, err := Files(filepath.Join(dir, "missing.cap")); err == nil {
+		t.Error("Expected a missing file to fail")
+	}
+	name := filepath.Join(dir, "one.cap")
+	os.WriteFile(name, []byte(capture.Magic), 0o644)
+	if files, err := Files(name); err != nil || len(files) != 1 || files[0] != name {
+		t.Errorf("Expected the file itself, got %v %v", files, err)
+	}
+}
@@ -0,0 +1,98 @@
+package backtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// Totals sums the plans of one group. Profit is kept per quote currency
+// since a group can mix them.
+type Totals struct {
+	Plans   int                `json:"plans"`
+	Profit  map[string]float64 `json:"profit"`
+	MaxEdge float64            `json:"max_edge"`
+}
+
+func (t *Totals) add(plan types.Plan) {
+	if t.Profit == nil {
+		t.Profit = make(map[string]float64)
+	}
+	t.Plans++
+	t.Profit[plan.QuoteCurrency] += plan.ExpectedProfitQuote
+	if edge := plan.Edge(); edge > t.MaxEdge {
+		t.MaxEdge = edge
+	}
+}
+
+// Report is a Publisher that totals plans per triangle (route), exchange
+// and hour. A plan crossing venues counts once for every venue it trades on.
+type Report struct {
+	Now func() time.Time `json:"-"`
+
+	mu        sync.Mutex
+	Total     Totals             `json:"total"`
+	Triangles map[string]*Totals `json:"triangles"`
+	Exchanges map[string]*Totals `json:"exchanges"`
+	Hours     map[string]*Totals `json:"hours"` // UTC, as 2006-01-02T15
+}
+
+func NewReport() *Report {
+	return &Report{
+		Now:       time.Now,
+		Triangles: make(map[string]*Totals),
+		Exchanges: make(map[string]*Totals),
+		Hours:     make(map[string]*Totals),
+	}
+}
+
+func (r *Report) Publish(plan types.Plan) error {
+	hour := r.Now().UTC().Format("2006-01-02T15")
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Total.add(plan)
+	group(r.Triangles, plan.Route()).add(plan)
+	group(r.Hours, hour).add(plan)
+	seen := make(map[string]bool, 2)
+	for _, l := range plan.Legs {
+		exchange := l.Exchange
+		if exchange == "" {
+			exchange = plan.Exchange
+		}
+		exchange = strings.ToUpper(exchange)
+		if !seen[exchange] {
+			seen[exchange] = true
+			group(r.Exchanges, exchange).add(plan)
+		}
+	}
+	return nil
+}
+
+func group(m map[string]*Totals, key string) *Totals {
+	t, ok := m[key]
+	if !ok {
+		t = &Totals{}
+		m[key] = t
+	}
+	return t
+}
+
+// WriteJSON writes the totals as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %w", err)
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
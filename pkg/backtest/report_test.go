@@ -0,0 +1,62 @@
+package backtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+func reportTestPlan(exchange2 string, profit float64) types.Plan {
+	return types.Plan{
+		Exchange: "BINANCE",
+		Legs: []types.Leg{
+			{Exchange: "BINANCE", Market: "ETHUSDT", Side: types.SideBuy, Qty: 0.1, LimitPrice: 3000},
+			{Exchange: exchange2, Market: "ETHBTC", Side: types.SideSell, Qty: 0.1, LimitPrice: 0.0605},
+			{Exchange: "BINANCE", Market: "BTCUSDT", Side: types.SideSell, Qty: 0.00605, LimitPrice: 50100},
+		},
+		ExpectedProfitQuote: profit,
+		QuoteCurrency:       "USDT",
+	}
+}
+
+func TestReport(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 59, 0, 0, time.UTC)
+	report := NewReport()
+	report.Now = func() time.Time { return now }
+
+	report.Publish(reportTestPlan("BINANCE", 1.5))
+	report.Publish(reportTestPlan("BINANCE", 3))
+	now = now.Add(2 * time.Minute)
+	report.Publish(reportTestPlan("KUCOIN", 0.5))
+
+	if report.Total.Plans != 3 || report.Total.Profit["USDT"] != 5 || report.Total.MaxEdge != 0.01 {
+		t.Errorf("Unexpected total %+v", report.Total)
+	}
+	if len(report.Triangles) != 2 || report.Triangles[reportTestPlan("BINANCE", 0).Route()].Plans != 2 {
+		t.Errorf("Unexpected triangles %v", report.Triangles)
+	}
+	if report.Exchanges["BINANCE"].Plans != 3 || report.Exchanges["KUCOIN"].Plans != 1 {
+		t.Errorf("Expected a cross plan to count for both venues, got %v", report.Exchanges)
+	}
+	if report.Hours["2026-10-16T09"].Plans != 2 || report.Hours["2026-10-16T10"].Plans != 1 {
+		t.Errorf("Unexpected hours %v", report.Hours)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Total Totals            `json:"total"`
+		Hours map[string]Totals `json:"hours"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Total.Plans != 3 || len(decoded.Hours) != 2 {
+		t.Errorf("Unexpected JSON report %s", buf.String())
+	}
+}
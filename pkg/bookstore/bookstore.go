@@ -7,78 +7,38 @@ import (
 	"github.com/armagg/circular-arbitrage-finder/pkg/types"
 )
 
-// isSortedDescending checks if levels are already sorted by price descending (highest first)
-func isSortedDescending(levels []types.Level) bool {
-	for i := 1; i < len(levels); i++ {
-		if levels[i].Price > levels[i-1].Price {
-			return false
-		}
-	}
-	return true
-}
-
-// isSortedAscending checks if levels are already sorted by price ascending (lowest first)
-func isSortedAscending(levels []types.Level) bool {
-	for i := 1; i < len(levels); i++ {
-		if levels[i].Price < levels[i-1].Price {
-			return false
-		}
-	}
-	return true
-}
-
-// insertionSortDescending sorts small slices efficiently when nearly sorted
-func insertionSortDescending(levels []types.Level) {
-	for i := 1; i < len(levels); i++ {
-		key := levels[i]
-		j := i - 1
-		for j >= 0 && levels[j].Price < key.Price {
-			levels[j+1] = levels[j]
-			j--
-		}
-		levels[j+1] = key
-	}
-}
-
-// insertionSortAscending sorts small slices efficiently when nearly sorted
-func insertionSortAscending(levels []types.Level) {
-	for i := 1; i < len(levels); i++ {
-		key := levels[i]
-		j := i - 1
-		for j >= 0 && levels[j].Price > key.Price {
-			levels[j+1] = levels[j]
-			j--
-		}
-		levels[j+1] = key
-	}
-}
-
 type TopOfBookStore struct {
 	mu   sync.RWMutex
-	data map[string]types.TopOfBook
+	data map[types.MarketKey]types.TopOfBook
 }
 
 func NewTopOfBookStore() *TopOfBookStore {
-	return &TopOfBookStore{data: make(map[string]types.TopOfBook)}
+	return &TopOfBookStore{data: make(map[types.MarketKey]types.TopOfBook)}
 }
 
-func (s *TopOfBookStore) Set(symbol string, tob types.TopOfBook) {
+func (s *TopOfBookStore) Set(key types.MarketKey, tob types.TopOfBook) {
 	s.mu.Lock()
-	s.data[symbol] = tob
+	s.data[key] = tob
 	s.mu.Unlock()
 }
 
-func (s *TopOfBookStore) Get(symbol string) (types.TopOfBook, bool) {
+func (s *TopOfBookStore) Get(key types.MarketKey) (types.TopOfBook, bool) {
 	s.mu.RLock()
-	v, ok := s.data[symbol]
+	v, ok := s.data[key]
 	s.mu.RUnlock()
 	return v, ok
 }
 
-func (s *TopOfBookStore) Snapshot() map[string]types.TopOfBook {
+func (s *TopOfBookStore) Delete(key types.MarketKey) {
+	s.mu.Lock()
+	delete(s.data, key)
+	s.mu.Unlock()
+}
+
+func (s *TopOfBookStore) Snapshot() map[types.MarketKey]types.TopOfBook {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	cp := make(map[string]types.TopOfBook, len(s.data))
+	cp := make(map[types.MarketKey]types.TopOfBook, len(s.data))
 	for k, v := range s.data {
 		cp[k] = v
 	}
@@ -87,52 +47,70 @@ func (s *TopOfBookStore) Snapshot() map[string]types.TopOfBook {
 
 type OrderBookStore struct {
 	mu   sync.RWMutex
-	data map[string]types.OrderBook
+	data map[types.MarketKey]types.OrderBook
 }
 
 func NewOrderBookStore() *OrderBookStore {
-	return &OrderBookStore{data: make(map[string]types.OrderBook)}
+	return &OrderBookStore{data: make(map[types.MarketKey]types.OrderBook)}
 }
 
-func (s *OrderBookStore) Upsert(symbol string, bids []types.Level, asks []types.Level, seq uint64, ts int64, depth int) {
+// ApplyDelta updates the book from one feed message and returns the result.
+// A snapshot replaces the book; otherwise each level sets the quantity at its
+// price, with a zero quantity removing the level. The book is trimmed to
+// depth afterwards.
+func (s *OrderBookStore) ApplyDelta(key types.MarketKey, bids []types.Level, asks []types.Level, snapshot bool, seq uint64, ts int64, depth int) types.OrderBook {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Optimize sorting: only sort if not already sorted, use efficient method for small/nearly sorted data
-	if len(bids) > 0 {
-		if !isSortedDescending(bids) {
-			if len(bids) <= 32 {
-				// Use insertion sort for small slices (very efficient when nearly sorted)
-				insertionSortDescending(bids)
-			} else {
-				// Use Go's optimized sort for larger slices
-				sort.Slice(bids, func(i, j int) bool { return bids[i].Price > bids[j].Price })
-			}
-		}
+	var book types.OrderBook
+	if !snapshot {
+		book = s.data[key]
+	}
+	book.Bids = applyLevels(book.Bids, bids, func(a, b float64) bool { return a > b })
+	book.Asks = applyLevels(book.Asks, asks, func(a, b float64) bool { return a < b })
+	if depth > 0 {
+		if len(book.Bids) > depth { book.Bids = book.Bids[:depth] }
+		if len(book.Asks) > depth { book.Asks = book.Asks[:depth] }
 	}
+	book.Seq, book.TsNs = seq, ts
+	s.data[key] = book
+	return book
+}
 
-	if len(asks) > 0 {
-		if !isSortedAscending(asks) {
-			if len(asks) <= 32 {
-				// Use insertion sort for small slices (very efficient when nearly sorted)
-				insertionSortAscending(asks)
-			} else {
-				// Use Go's optimized sort for larger slices
-				sort.Slice(asks, func(i, j int) bool { return asks[i].Price < asks[j].Price })
+// applyLevels returns a copy of side with every update applied, where better
+// orders prices from the top of the book down. Readers may still hold the
+// old slice, so it is never changed in place.
+func applyLevels(side, updates []types.Level, better func(a, b float64) bool) []types.Level {
+	res := make([]types.Level, len(side), len(side)+len(updates))
+	copy(res, side)
+	for _, u := range updates {
+		i := sort.Search(len(res), func(i int) bool { return !better(res[i].Price, u.Price) })
+		found := i < len(res) && res[i].Price == u.Price
+		switch {
+		case u.Qty <= 0:
+			if found {
+				res = append(res[:i], res[i+1:]...)
 			}
+		case found:
+			res[i].Qty = u.Qty
+		default:
+			res = append(res, types.Level{})
+			copy(res[i+1:], res[i:])
+			res[i] = u
 		}
 	}
-
-	if depth > 0 {
-		if len(bids) > depth { bids = bids[:depth] }
-		if len(asks) > depth { asks = asks[:depth] }
-	}
-	s.data[symbol] = types.OrderBook{Bids: bids, Asks: asks, Seq: seq, TsNs: ts}
+	return res
 }
 
-func (s *OrderBookStore) Get(symbol string) (types.OrderBook, bool) {
+func (s *OrderBookStore) Get(key types.MarketKey) (types.OrderBook, bool) {
 	s.mu.RLock()
-	v, ok := s.data[symbol]
+	v, ok := s.data[key]
 	s.mu.RUnlock()
 	return v, ok
 }
+
+func (s *OrderBookStore) Delete(key types.MarketKey) {
+	s.mu.Lock()
+	delete(s.data, key)
+	s.mu.Unlock()
+}
@@ -1,7 +1,7 @@
 package bookstore
 
 import (
-	"sort"
+	"reflect"
 	"sync"
 	"testing"
 
@@ -36,10 +36,10 @@ func TestTopOfBookStoreSet(t *testing.T) {
 		TsNs:  1640995200000000000,
 	}
 
-	store.Set("BTCUSDT", tob)
+	store.Set(types.NewMarketKey("binance", "BTCUSDT"), tob)
 
 	// Verify the data was stored
-	retrieved, exists := store.Get("BTCUSDT")
+	retrieved, exists := store.Get(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("Expected data to exist after Set")
 	}
@@ -53,16 +53,16 @@ func TestTopOfBookStoreGet(t *testing.T) {
 	store := NewTopOfBookStore()
 
 	// Test getting non-existent data
-	_, exists := store.Get("NONEXISTENT")
+	_, exists := store.Get(types.NewMarketKey("binance", "NONEXISTENT"))
 	if exists {
 		t.Error("Get should return false for non-existent data")
 	}
 
 	// Test getting existing data
 	tob := types.TopOfBook{BidPx: 50000.0, AskPx: 50005.0}
-	store.Set("BTCUSDT", tob)
+	store.Set(types.NewMarketKey("binance", "BTCUSDT"), tob)
 
-	retrieved, exists := store.Get("BTCUSDT")
+	retrieved, exists := store.Get(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("Get should return true for existing data")
 	}
@@ -76,13 +76,13 @@ func TestTopOfBookStoreSnapshot(t *testing.T) {
 	store := NewTopOfBookStore()
 
 	// Add some test data
-	data := map[string]types.TopOfBook{
-		"BTCUSDT": {BidPx: 50000.0, AskPx: 50005.0},
-		"ETHUSDT": {BidPx: 3000.0, AskPx: 3005.0},
+	data := map[types.MarketKey]types.TopOfBook{
+		types.NewMarketKey("binance", "BTCUSDT"): {BidPx: 50000.0, AskPx: 50005.0},
+		types.NewMarketKey("binance", "ETHUSDT"): {BidPx: 3000.0, AskPx: 3005.0},
 	}
 
-	for symbol, tob := range data {
-		store.Set(symbol, tob)
+	for key, tob := range data {
+		store.Set(key, tob)
 	}
 
 	snapshot := store.Snapshot()
@@ -91,17 +91,36 @@ func TestTopOfBookStoreSnapshot(t *testing.T) {
 		t.Errorf("Snapshot should have %d items, got %d", len(data), len(snapshot))
 	}
 
-	for symbol, expected := range data {
-		actual, exists := snapshot[symbol]
+	for key, expected := range data {
+		actual, exists := snapshot[key]
 		if !exists {
-			t.Errorf("Snapshot should contain symbol %s", symbol)
+			t.Errorf("Snapshot should contain market %s", key)
 		}
 		if actual != expected {
-			t.Errorf("Snapshot data for %s should match original", symbol)
+			t.Errorf("Snapshot data for %s should match original", key)
 		}
 	}
 }
 
+func TestTopOfBookStoreExchangeScoped(t *testing.T) {
+	store := NewTopOfBookStore()
+
+	binance := types.NewMarketKey("binance", "BTCUSDT")
+	kucoin := types.NewMarketKey("KUCOIN", "btcusdt")
+	store.Set(binance, types.TopOfBook{BidPx: 50000.0, AskPx: 50005.0})
+	store.Set(kucoin, types.TopOfBook{BidPx: 50100.0, AskPx: 50110.0})
+
+	if tob, _ := store.Get(binance); tob.BidPx != 50000.0 {
+		t.Errorf("Expected BINANCE quote to survive a KUCOIN update, got %f", tob.BidPx)
+	}
+	if tob, _ := store.Get(types.NewMarketKey("kucoin", "BTCUSDT")); tob.BidPx != 50100.0 {
+		t.Errorf("Expected KUCOIN quote, got %f", tob.BidPx)
+	}
+	if len(store.Snapshot()) != 2 {
+		t.Errorf("Expected 2 markets in snapshot, got %d", len(store.Snapshot()))
+	}
+}
+
 func TestTopOfBookStoreConcurrency(t *testing.T) {
 	store := NewTopOfBookStore()
 
@@ -115,14 +134,14 @@ func TestTopOfBookStoreConcurrency(t *testing.T) {
 		go func(id int) {
 			defer wg.Done()
 			for j := 0; j < numOperations; j++ {
-				symbol := "BTCUSDT"
+				key := types.NewMarketKey("binance", "BTCUSDT")
 				tob := types.TopOfBook{
 					BidPx: float64(id*1000 + j),
 					AskPx: float64(id*1000 + j + 5),
 				}
-				store.Set(symbol, tob)
+				store.Set(key, tob)
 
-				_, _ = store.Get(symbol)
+				_, _ = store.Get(key)
 			}
 		}(i)
 	}
@@ -130,7 +149,7 @@ func TestTopOfBookStoreConcurrency(t *testing.T) {
 	wg.Wait()
 
 	// Verify final state
-	_, exists := store.Get("BTCUSDT")
+	_, exists := store.Get(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("Data should exist after concurrent operations")
 	}
@@ -152,7 +171,7 @@ func TestNewOrderBookStore(t *testing.T) {
 	}
 }
 
-func TestOrderBookStoreUpsert(t *testing.T) {
+func TestOrderBookStoreSnapshot(t *testing.T) {
 	store := NewOrderBookStore()
 
 	bids := []types.Level{
@@ -167,11 +186,11 @@ func TestOrderBookStoreUpsert(t *testing.T) {
 		{Price: 50010.0, Qty: 2.5},
 	}
 
-	store.Upsert("BTCUSDT", bids, asks, 12345, 1640995200000000000, 0)
+	store.ApplyDelta(types.NewMarketKey("binance", "BTCUSDT"), bids, asks, true, 12345, 1640995200000000000, 0)
 
-	retrieved, exists := store.Get("BTCUSDT")
+	retrieved, exists := store.Get(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
-		t.Error("Order book should exist after upsert")
+		t.Error("Order book should exist after a snapshot")
 	}
 
 	// Check that bids are sorted descending
@@ -197,7 +216,7 @@ func TestOrderBookStoreUpsert(t *testing.T) {
 	}
 }
 
-func TestOrderBookStoreUpsertWithDepth(t *testing.T) {
+func TestOrderBookStoreSnapshotWithDepth(t *testing.T) {
 	store := NewOrderBookStore()
 
 	// Create more levels than depth limit
@@ -218,11 +237,11 @@ func TestOrderBookStoreUpsertWithDepth(t *testing.T) {
 	}
 
 	depth := 3
-	store.Upsert("BTCUSDT", bids, asks, 12345, 1640995200000000000, depth)
+	store.ApplyDelta(types.NewMarketKey("binance", "BTCUSDT"), bids, asks, true, 12345, 1640995200000000000, depth)
 
-	retrieved, exists := store.Get("BTCUSDT")
+	retrieved, exists := store.Get(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
-		t.Error("Order book should exist after upsert")
+		t.Error("Order book should exist after a snapshot")
 	}
 
 	if len(retrieved.Bids) != depth {
@@ -238,7 +257,7 @@ func TestOrderBookStoreGet(t *testing.T) {
 	store := NewOrderBookStore()
 
 	// Test getting non-existent data
-	_, exists := store.Get("NONEXISTENT")
+	_, exists := store.Get(types.NewMarketKey("binance", "NONEXISTENT"))
 	if exists {
 		t.Error("Get should return false for non-existent data")
 	}
@@ -253,9 +272,9 @@ func TestOrderBookStoreGet(t *testing.T) {
 
 	bids := []types.Level{{Price: 50000.0, Qty: 1.0}}
 	asks := []types.Level{{Price: 50005.0, Qty: 1.0}}
-	store.Upsert("BTCUSDT", bids, asks, 12345, 1640995200000000000, 0)
+	store.ApplyDelta(types.NewMarketKey("binance", "BTCUSDT"), bids, asks, true, 12345, 1640995200000000000, 0)
 
-	retrieved, exists := store.Get("BTCUSDT")
+	retrieved, exists := store.Get(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("Get should return true for existing data")
 	}
@@ -265,185 +284,78 @@ func TestOrderBookStoreGet(t *testing.T) {
 	}
 }
 
-// Test the sorting optimization functions
-func TestIsSortedDescending(t *testing.T) {
-	tests := []struct {
-		name     string
-		levels   []types.Level
-		expected bool
-	}{
-		{
-			name: "Already sorted descending",
-			levels: []types.Level{
-				{Price: 50000.0, Qty: 1.0},
-				{Price: 49990.0, Qty: 1.5},
-				{Price: 49980.0, Qty: 2.0},
-			},
-			expected: true,
-		},
-		{
-			name: "Not sorted descending",
-			levels: []types.Level{
-				{Price: 49980.0, Qty: 2.0},
-				{Price: 50000.0, Qty: 1.0},
-				{Price: 49990.0, Qty: 1.5},
-			},
-			expected: false,
-		},
-		{
-			name:     "Empty slice",
-			levels:   []types.Level{},
-			expected: true,
-		},
-		{
-			name: "Single element",
-			levels: []types.Level{
-				{Price: 50000.0, Qty: 1.0},
-			},
-			expected: true,
-		},
-	}
+func TestOrderBookStoreApplyDelta(t *testing.T) {
+	store := NewOrderBookStore()
+	key := types.NewMarketKey("binance", "BTCUSDT")
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			result := isSortedDescending(tt.levels)
-			if result != tt.expected {
-				t.Errorf("isSortedDescending() = %v, expected %v", result, tt.expected)
-			}
-		})
-	}
-}
+	store.ApplyDelta(key,
+		[]types.Level{{Price: 49980.0, Qty: 1.5}, {Price: 49990.0, Qty: 2.1}, {Price: 49970.0, Qty: 0.8}},
+		[]types.Level{{Price: 50020.0, Qty: 1.8}, {Price: 50010.0, Qty: 2.5}, {Price: 50030.0, Qty: 1.2}},
+		true, 1, 100, 0)
+	before, _ := store.Get(key)
 
-func TestIsSortedAscending(t *testing.T) {
 	tests := []struct {
-		name     string
-		levels   []types.Level
-		expected bool
+		name         string
+		bids         []types.Level
+		asks         []types.Level
+		snapshot     bool
+		depth        int
+		expectedBids []types.Level
+		expectedAsks []types.Level
 	}{
 		{
-			name: "Already sorted ascending",
-			levels: []types.Level{
-				{Price: 49980.0, Qty: 2.0},
-				{Price: 49990.0, Qty: 1.5},
-				{Price: 50000.0, Qty: 1.0},
-			},
-			expected: true,
+			name:         "Update, insert and remove",
+			bids:         []types.Level{{Price: 49990.0, Qty: 3.0}, {Price: 49985.0, Qty: 0.4}, {Price: 49970.0, Qty: 0}},
+			asks:         []types.Level{{Price: 50010.0, Qty: 0}, {Price: 50040.0, Qty: 0.9}},
+			expectedBids: []types.Level{{Price: 49990.0, Qty: 3.0}, {Price: 49985.0, Qty: 0.4}, {Price: 49980.0, Qty: 1.5}},
+			expectedAsks: []types.Level{{Price: 50020.0, Qty: 1.8}, {Price: 50030.0, Qty: 1.2}, {Price: 50040.0, Qty: 0.9}},
 		},
 		{
-			name: "Not sorted ascending",
-			levels: []types.Level{
-				{Price: 50000.0, Qty: 1.0},
-				{Price: 49980.0, Qty: 2.0},
-				{Price: 49990.0, Qty: 1.5},
-			},
-			expected: false,
+			name:         "Removing an unknown level is a no-op",
+			bids:         []types.Level{{Price: 1.0, Qty: 0}},
+			expectedBids: []types.Level{{Price: 49990.0, Qty: 3.0}, {Price: 49985.0, Qty: 0.4}, {Price: 49980.0, Qty: 1.5}},
+			expectedAsks: []types.Level{{Price: 50020.0, Qty: 1.8}, {Price: 50030.0, Qty: 1.2}, {Price: 50040.0, Qty: 0.9}},
 		},
 		{
-			name:     "Empty slice",
-			levels:   []types.Level{},
-			expected: true,
+			name:         "New best prices are trimmed to depth",
+			bids:         []types.Level{{Price: 49995.0, Qty: 1.0}},
+			asks:         []types.Level{{Price: 50005.0, Qty: 1.0}},
+			depth:        2,
+			expectedBids: []types.Level{{Price: 49995.0, Qty: 1.0}, {Price: 49990.0, Qty: 3.0}},
+			expectedAsks: []types.Level{{Price: 50005.0, Qty: 1.0}, {Price: 50020.0, Qty: 1.8}},
 		},
 		{
-			name: "Single element",
-			levels: []types.Level{
-				{Price: 50000.0, Qty: 1.0},
-			},
-			expected: true,
+			name:         "Snapshot replaces the book",
+			bids:         []types.Level{{Price: 49000.0, Qty: 1.0}, {Price: 48900.0, Qty: 0}},
+			asks:         []types.Level{{Price: 49100.0, Qty: 2.0}},
+			snapshot:     true,
+			expectedBids: []types.Level{{Price: 49000.0, Qty: 1.0}},
+			expectedAsks: []types.Level{{Price: 49100.0, Qty: 2.0}},
 		},
 	}
 
-	for _, tt := range tests {
+	for i, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := isSortedAscending(tt.levels)
-			if result != tt.expected {
-				t.Errorf("isSortedAscending() = %v, expected %v", result, tt.expected)
+			book := store.ApplyDelta(key, tt.bids, tt.asks, tt.snapshot, uint64(i+2), int64(i+200), tt.depth)
+			if !reflect.DeepEqual(book.Bids, tt.expectedBids) {
+				t.Errorf("Expected bids %v, got %v", tt.expectedBids, book.Bids)
+			}
+			if !reflect.DeepEqual(book.Asks, tt.expectedAsks) {
+				t.Errorf("Expected asks %v, got %v", tt.expectedAsks, book.Asks)
+			}
+			if book.Seq != uint64(i+2) || book.TsNs != int64(i+200) {
+				t.Errorf("Expected seq %d ts %d, got %d %d", i+2, i+200, book.Seq, book.TsNs)
+			}
+			if stored, _ := store.Get(key); !reflect.DeepEqual(stored, book) {
+				t.Errorf("Expected the returned book to be stored, got %+v", stored)
 			}
 		})
 	}
-}
-
-func TestInsertionSortDescending(t *testing.T) {
-	levels := []types.Level{
-		{Price: 49980.0, Qty: 2.0},
-		{Price: 50000.0, Qty: 1.0},
-		{Price: 49990.0, Qty: 1.5},
-	}
-
-	insertionSortDescending(levels)
-
-	// Verify sorted descending
-	for i := 1; i < len(levels); i++ {
-		if levels[i].Price > levels[i-1].Price {
-			t.Error("Levels should be sorted descending by price")
-		}
-	}
-
-	// Check specific order
-	expected := []float64{50000.0, 49990.0, 49980.0}
-	for i, level := range levels {
-		if level.Price != expected[i] {
-			t.Errorf("Expected price %f at index %d, got %f", expected[i], i, level.Price)
-		}
-	}
-}
-
-func TestInsertionSortAscending(t *testing.T) {
-	levels := []types.Level{
-		{Price: 50000.0, Qty: 1.0},
-		{Price: 49980.0, Qty: 2.0},
-		{Price: 49990.0, Qty: 1.5},
-	}
-
-	insertionSortAscending(levels)
-
-	// Verify sorted ascending
-	for i := 1; i < len(levels); i++ {
-		if levels[i].Price < levels[i-1].Price {
-			t.Error("Levels should be sorted ascending by price")
-		}
-	}
 
-	// Check specific order
-	expected := []float64{49980.0, 49990.0, 50000.0}
-	for i, level := range levels {
-		if level.Price != expected[i] {
-			t.Errorf("Expected price %f at index %d, got %f", expected[i], i, level.Price)
-		}
-	}
-}
-
-// Test sorting optimization behavior
-func TestSortingOptimization(t *testing.T) {
-	store := NewOrderBookStore()
-
-	// Test with already sorted data (should skip sorting)
-	sortedBids := []types.Level{
-		{Price: 50000.0, Qty: 1.0},
-		{Price: 49990.0, Qty: 1.5},
-		{Price: 49980.0, Qty: 2.0},
-	}
-	sortedAsks := []types.Level{
-		{Price: 50010.0, Qty: 2.0},
-		{Price: 50020.0, Qty: 1.5},
-		{Price: 50030.0, Qty: 1.0},
-	}
-
-	store.Upsert("BTCUSDT", sortedBids, sortedAsks, 12345, 1640995200000000000, 0)
-
-	retrieved, _ := store.Get("BTCUSDT")
-
-	// Verify bids are still sorted descending
-	for i := 1; i < len(retrieved.Bids); i++ {
-		if retrieved.Bids[i].Price > retrieved.Bids[i-1].Price {
-			t.Error("Bids should remain sorted descending")
-		}
-	}
-
-	// Verify asks are still sorted ascending
-	for i := 1; i < len(retrieved.Asks); i++ {
-		if retrieved.Asks[i].Price < retrieved.Asks[i-1].Price {
-			t.Error("Asks should remain sorted ascending")
-		}
+	// Books handed out earlier are never changed underneath their reader
+	expected := []types.Level{{Price: 49990.0, Qty: 2.1}, {Price: 49980.0, Qty: 1.5}, {Price: 49970.0, Qty: 0.8}}
+	if !reflect.DeepEqual(before.Bids, expected) {
+		t.Errorf("Expected the first snapshot to stay %v, got %v", expected, before.Bids)
 	}
 }
 
@@ -460,16 +372,16 @@ func TestOrderBookStoreConcurrency(t *testing.T) {
 		go func(id int) {
 			defer wg.Done()
 			for j := 0; j < numOperations; j++ {
-				symbol := "BTCUSDT"
+				key := types.NewMarketKey("binance", "BTCUSDT")
 				bids := []types.Level{
 					{Price: float64(50000 + id*10 + j), Qty: 1.0},
 				}
 				asks := []types.Level{
 					{Price: float64(50010 + id*10 + j), Qty: 1.0},
 				}
-				store.Upsert(symbol, bids, asks, uint64(id*numOperations+j), 1640995200000000000, 0)
+				store.ApplyDelta(key, bids, asks, true, uint64(id*numOperations+j), 1640995200000000000, 0)
 
-				_, _ = store.Get(symbol)
+				_, _ = store.Get(key)
 			}
 		}(i)
 	}
@@ -477,57 +389,8 @@ func TestOrderBookStoreConcurrency(t *testing.T) {
 	wg.Wait()
 
 	// Verify final state
-	_, exists := store.Get("BTCUSDT")
+	_, exists := store.Get(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("Data should exist after concurrent operations")
 	}
 }
-
-// Benchmark tests for sorting performance
-func BenchmarkInsertionSortDescending(b *testing.B) {
-	levels := make([]types.Level, 32)
-	for i := range levels {
-		levels[i] = types.Level{Price: float64(32 - i), Qty: 1.0}
-	}
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		insertionSortDescending(levels)
-	}
-}
-
-func BenchmarkGoSortDescending(b *testing.B) {
-	levels := make([]types.Level, 32)
-	for i := range levels {
-		levels[i] = types.Level{Price: float64(32 - i), Qty: 1.0}
-	}
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		sort.Slice(levels, func(j, k int) bool { return levels[j].Price > levels[k].Price })
-	}
-}
-
-func BenchmarkInsertionSortAscending(b *testing.B) {
-	levels := make([]types.Level, 32)
-	for i := range levels {
-		levels[i] = types.Level{Price: float64(i), Qty: 1.0}
-	}
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		insertionSortAscending(levels)
-	}
-}
-
-func BenchmarkGoSortAscending(b *testing.B) {
-	levels := make([]types.Level, 32)
-	for i := range levels {
-		levels[i] = types.Level{Price: float64(i), Qty: 1.0}
-	}
-
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		sort.Slice(levels, func(j, k int) bool { return levels[j].Price < levels[k].Price })
-	}
-}
@@ -0,0 +1,219 @@
+// Package capture records ingress deltas to disk and reads them back.
+//
+// A capture file starts with Magic. Each record that follows is the
+// uvarint length of the encoded delta, the receive time in Unix nanoseconds
+// as 8 big-endian bytes, and the delta as protobuf.
+package capture
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
+	mdpb "github.com/armagg/circular-arbitrage-finder/proto/md"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+)
+
+const Magic = "ARBCAP01"
+
+// flushInterval bounds how long a record may sit in the write buffer.
+var flushInterval = time.Second
+
+type Record struct {
+	RecvNs int64
+	Delta  *mdpb.OrderBookDelta
+}
+
+// Recorder appends deltas to capture files in Dir. It starts a new file once
+// the current one holds MaxBytes or is older than MaxAge; zero turns either
+// limit off. Buffered records are flushed every flushInterval until Close.
+// A nil Recorder records nothing.
+type Recorder struct {
+	Dir      string
+	MaxBytes int64
+	MaxAge   time.Duration
+	Now      func() time.Time
+
+	mu      sync.Mutex
+	file    *os.File
+	w       *bufio.Writer
+	size    int64
+	created time.Time
+	buf     []byte
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewRecorder(dir string, maxBytes int64, maxAge time.Duration) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create capture directory: %w", err)
+	}
+	r := &Recorder{Dir: dir, MaxBytes: maxBytes, MaxAge: maxAge, Now: time.Now, stop: make(chan struct{}), done: make(chan struct{})}
+	go r.flushLoop()
+	return r, nil
+}
+
+func (r *Recorder) flushLoop() {
+	defer close(r.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if err := r.flush(); err != nil {
+				logger.Log.WithFields(logrus.Fields{"dir": r.Dir, "error": err}).Error("capture: flush failed")
+			}
+		}
+	}
+}
+
+func (r *Recorder) flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w == nil || r.w.Buffered() == 0 {
+		return nil
+	}
+	if err := r.w.Flush(); err != nil {
+		return fmt.Errorf("failed to flush capture file: %w", err)
+	}
+	return nil
+}
+
+func (r *Recorder) Record(d *mdpb.OrderBookDelta) error {
+	if r == nil {
+		return nil
+	}
+	now := r.Now()
+	payload, err := proto.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("failed to encode delta: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil || r.full(now) {
+		if err := r.rotate(now); err != nil {
+			return err
+		}
+	}
+	r.buf = binary.AppendUvarint(r.buf[:0], uint64(len(payload)))
+	r.buf = binary.BigEndian.AppendUint64(r.buf, uint64(now.UnixNano()))
+	r.buf = append(r.buf, payload...)
+	n, err := r.w.Write(r.buf)
+	r.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write capture record: %w", err)
+	}
+	return nil
+}
+
+func (r *Recorder) full(now time.Time) bool {
+	return (r.MaxBytes > 0 && r.size >= r.MaxBytes) || (r.MaxAge > 0 && now.Sub(r.created) >= r.MaxAge)
+}
+
+func (r *Recorder) rotate(now time.Time) error {
+	if err := r.closeFile(); err != nil {
+		return err
+	}
+	name := filepath.Join(r.Dir, "deltas-"+now.UTC().Format("20060102T150405.000000000Z")+".cap")
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create capture file: %w", err)
+	}
+	r.file, r.w = f, bufio.NewWriter(f)
+	r.created = now
+	n, err := r.w.WriteString(Magic)
+	r.size = int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write capture header: %w", err)
+	}
+	return nil
+}
+
+func (r *Recorder) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.w.Flush()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file, r.w = nil, nil
+	if err != nil {
+		return fmt.Errorf("failed to close capture file: %w", err)
+	}
+	return nil
+}
+
+// Close stops the periodic flush, then flushes and closes the current file.
+func (r *Recorder) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.closeOnce.Do(func() {
+		if r.stop != nil {
+			close(r.stop)
+			<-r.done
+		}
+	})
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeFile()
+}
+
+// Reader reads the records of one capture file in order.
+type Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(br, magic); err != nil {
+		return nil, fmt.Errorf("failed to read capture header: %w", err)
+	}
+	if string(magic) != Magic {
+		return nil, fmt.Errorf("not a capture file: bad header %q", magic)
+	}
+	return &Reader{r: br}, nil
+}
+
+// Next returns the next record, or io.EOF after the last one. A record cut
+// short, as a crash leaves the last one, is io.ErrUnexpectedEOF.
+func (r *Reader) Next() (Record, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return Record{}, io.EOF
+		}
+		return Record{}, fmt.Errorf("failed to read record length: %w", err)
+	}
+	if cap(r.buf) < int(n)+8 {
+		r.buf = make([]byte, int(n)+8)
+	}
+	buf := r.buf[:int(n)+8]
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, err
+	}
+	d := &mdpb.OrderBookDelta{}
+	if err := proto.Unmarshal(buf[8:], d); err != nil {
+		return Record{}, fmt.Errorf("failed to decode delta: %w", err)
+	}
+	return Record{RecvNs: int64(binary.BigEndian.Uint64(buf[:8])), Delta: d}, nil
+}
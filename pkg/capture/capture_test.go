@@ -0,0 +1,184 @@
+package capture
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	mdpb "github.com/armagg/circular-arbitrage-finder/proto/md"
+)
+
+func testDelta(symbol string, seq uint64) *mdpb.OrderBookDelta {
+	return &mdpb.OrderBookDelta{
+		Market:   &mdpb.MarketId{Exchange: "BINANCE", Symbol: symbol},
+		Bids:     []*mdpb.Level{{Price: 2999, Qty: 1}},
+		Asks:     []*mdpb.Level{{Price: 3000, Qty: 2}},
+		Sequence: seq,
+		TsNs:     1700000000000000000 + seq,
+	}
+}
+
+func readFile(t *testing.T, name string) []Record {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []Record
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return records
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, rec)
+	}
+}
+
+func captureFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "deltas-*.cap"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestRecorderRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+	rec, err := NewRecorder(dir, 0, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Now = func() time.Time { now = now.Add(time.Millisecond); return now }
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		if err := rec.Record(testDelta("ETHUSDT", seq)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now = now.Add(time.Minute)
+	if err := rec.Record(testDelta("BTCUSDT", 4)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := captureFiles(t, dir)
+	if len(files) != 2 {
+		t.Fatalf("Expected a minute-old capture to rotate, got %d files", len(files))
+	}
+	first := readFile(t, files[0])
+	if len(first) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(first))
+	}
+	got := first[1]
+	if got.Delta.GetSequence() != 2 || got.Delta.GetMarket().GetSymbol() != "ETHUSDT" || got.Delta.GetAsks()[0].GetQty() != 2 || got.Delta.GetTsNs() != 1700000000000000002 {
+		t.Errorf("Unexpected delta %v", got.Delta)
+	}
+	if got.RecvNs != time.Unix(1700000000, 2*int64(time.Millisecond)).UnixNano() {
+		t.Errorf("Unexpected receive time %d", got.RecvNs)
+	}
+	if second := readFile(t, files[1]); len(second) != 1 || second[0].Delta.GetMarket().GetSymbol() != "BTCUSDT" {
+		t.Errorf("Unexpected second file %v", second)
+	}
+}
+
+func TestRecorderRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Unix(1700000000, 0)
+	rec, err := NewRecorder(dir, 64, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.Now = func() time.Time { now = now.Add(time.Millisecond); return now }
+	for seq := uint64(1); seq <= 6; seq++ {
+		rec.Record(testDelta("ETHUSDT", seq))
+	}
+	rec.Close()
+
+	files := captureFiles(t, dir)
+	if len(files) < 2 {
+		t.Fatalf("Expected several files, got %d", len(files))
+	}
+	total := 0
+	for _, name := range files {
+		total += len(readFile(t, name))
+	}
+	if total != 6 {
+		t.Errorf("Expected 6 records across files, got %d", total)
+	}
+
+	var none *Recorder
+	if err := none.Record(testDelta("ETHUSDT", 1)); err != nil || none.Close() != nil {
+		t.Error("Expected a nil recorder to do nothing")
+	}
+}
+
+func TestRecorderFlushesWhileIdle(t *testing.T) {
+	defer func(d time.Duration) { flushInterval = d }(flushInterval)
+	flushInterval = time.Millisecond
+	dir := t.TempDir()
+	rec, err := NewRecorder(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Close()
+	if err := rec.Record(testDelta("ETHUSDT", 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		files := captureFiles(t, dir)
+		if info, err := os.Stat(files[0]); err == nil && info.Size() > int64(len(Magic)) {
+			if records := readFile(t, files[0]); len(records) != 1 {
+				t.Fatalf("Expected 1 record, got %d", len(records))
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the record to be flushed without further traffic")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("NOTACAPT"))); err == nil {
+		t.Error("Expected a bad header to fail")
+	}
+
+	dir := t.TempDir()
+	rec, _ := NewRecorder(dir, 0, 0)
+	rec.Record(testDelta("ETHUSDT", 1))
+	rec.Close()
+	data, err := os.ReadFile(captureFiles(t, dir)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A record cut short by a crash
+	r, err := NewReader(bytes.NewReader(data[:len(data)-3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Next(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
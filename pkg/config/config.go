@@ -3,18 +3,32 @@ package config
 import (
 	"fmt"
 	"os"
+	"reflect"
 	"sort"
 	"strings"
 
 	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	QuoteAssets []string  `yaml:"quote_assets"`
-	Fees        Fees      `yaml:"fees"`
-	Strategy    Strategy  `yaml:"strategy"`
-	Log         LogConfig `yaml:"log"`
+	QuoteAssets     []string      `yaml:"quote_assets"`
+	InstrumentFiles []string      `yaml:"instrument_files"`
+	Fees            Fees          `yaml:"fees"`
+	Strategy        Strategy      `yaml:"strategy"`
+	CrossExchange   CrossExchange `yaml:"cross_exchange"`
+	Inventory       Inventory     `yaml:"inventory"`
+	Feeds           []Feed        `yaml:"feeds"`
+	QuoteAge        QuoteAge      `yaml:"quote_age"`
+	Feedback        Feedback      `yaml:"feedback"`
+	Dedup           Dedup         `yaml:"dedup"`
+	PublishQueue    PublishQueue  `yaml:"publish_queue"`
+	Sinks           []Sink        `yaml:"sinks"`
+	Capture         Capture       `yaml:"capture"`
+	Health          Health        `yaml:"health"`
+	Reload          Reload        `yaml:"reload"`
+	Log             LogConfig     `yaml:"log"`
 }
 
 type Fees struct {
@@ -30,11 +44,155 @@ type FeeConfig struct {
 }
 
 type Strategy struct {
-	MinProfitEdge float64            `yaml:"min_profit_edge"`
-	SlippageBp    float64            `yaml:"slippage_bp"`
-	TradeAmount   float64            `yaml:"trade_amount"`
-	OrderbookDepth int               `yaml:"orderbook_depth"`
-	TradeAmounts  map[string]float64 `yaml:"trade_amounts"`
+	MinProfitEdge  float64              `yaml:"min_profit_edge"`
+	SlippageBp     float64              `yaml:"slippage_bp"`
+	TradeAmount    float64              `yaml:"trade_amount"`
+	OrderbookDepth int                  `yaml:"orderbook_depth"`
+	TradeAmounts   map[string]float64   `yaml:"trade_amounts"`
+	Simulator      string               `yaml:"simulator"`
+	MinFillRatio   float64              `yaml:"min_fill_ratio"`
+	SizeBounds     map[string]SizeBound `yaml:"size_bounds"`
+	MaxCycleLength int                  `yaml:"max_cycle_length"`
+	Detector       string               `yaml:"detector"`
+}
+
+type SizeBound struct {
+	Min float64 `yaml:"min"`
+	Max float64 `yaml:"max"`
+}
+
+// CrossExchange enables cycles whose legs run on different venues. Moving an
+// asset between venues costs its transfer fee plus the latency penalty of
+// the venue pair; assets without a transfer entry are never moved.
+type CrossExchange struct {
+	Enabled   bool                      `yaml:"enabled"`
+	Transfers map[string]TransferConfig `yaml:"transfers"`
+	Latency   []LatencyPenalty          `yaml:"latency"`
+}
+
+type TransferConfig struct {
+	Fixed float64 `yaml:"fixed"`
+	Bp    float64 `yaml:"bp"`
+}
+
+type LatencyPenalty struct {
+	Venues []string `yaml:"venues"`
+	Bp     float64  `yaml:"bp"`
+}
+
+// Inventory lists the balances available per exchange and asset. When set,
+// cycles start in a held asset and are sized no larger than its balance.
+type Inventory map[string]map[string]float64
+
+// Feed is an OrderBookFeed server to subscribe to, on top of the push
+// ingress. Markets are EXCHANGE:SYMBOL; leave them out to subscribe to every
+// market in the index.
+type Feed struct {
+	Addr         string   `yaml:"addr"`
+	Markets      []string `yaml:"markets"`
+	MinBackoffMs int      `yaml:"min_backoff_ms"`
+	MaxBackoffMs int      `yaml:"max_backoff_ms"`
+}
+
+func (f Feed) MarketKeys() ([]types.MarketKey, error) {
+	if len(f.Markets) == 0 {
+		return nil, nil
+	}
+	keys := make([]types.MarketKey, 0, len(f.Markets))
+	for _, m := range f.Markets {
+		exchange, symbol, ok := strings.Cut(m, ":")
+		if !ok || exchange == "" || symbol == "" {
+			return nil, fmt.Errorf("invalid market %q for feed %s, expected EXCHANGE:SYMBOL", m, f.Addr)
+		}
+		keys = append(keys, types.NewMarketKey(exchange, symbol))
+	}
+	return keys, nil
+}
+
+// QuoteAge drops cycles priced from old quotes or from quotes taken too far
+// apart. Zero turns a limit off.
+type QuoteAge struct {
+	MaxAgeMs  int            `yaml:"max_age_ms"`
+	Exchanges map[string]int `yaml:"exchanges"` // max_age_ms per exchange
+	MaxSkewMs int            `yaml:"max_skew_ms"`
+}
+
+func (q QuoteAge) Enabled() bool {
+	return q.MaxAgeMs > 0 || q.MaxSkewMs > 0 || len(q.Exchanges) > 0
+}
+
+// Feedback cools down cycles the executor keeps rejecting. Zero values fall
+// back to the tracker's defaults.
+type Feedback struct {
+	RejectThreshold int `yaml:"reject_threshold"`
+	CoolDownMs      int `yaml:"cool_down_ms"`
+	StatsIntervalMs int `yaml:"stats_interval_ms"`
+}
+
+// Dedup holds back a plan when the same route was published within
+// WindowMs and neither its profit nor its size moved by more than MinChange
+// (relative). A zero window publishes every plan.
+type Dedup struct {
+	WindowMs  int     `yaml:"window_ms"`
+	MinChange float64 `yaml:"min_change"`
+}
+
+// PublishQueue publishes plans from Workers goroutines off a queue of at
+// most Capacity plans. Overflow is drop_oldest, drop_newest or
+// best_per_route. A zero capacity publishes synchronously. With sinks, each
+// sink also gets a queue of SinkCapacity plans, dropping the oldest, so a
+// slow sink never holds up the others. A zero SinkCapacity waits for every
+// sink on each plan, each for up to its TimeoutMs.
+type PublishQueue struct {
+	Capacity        int    `yaml:"capacity"`
+	Workers         int    `yaml:"workers"`
+	Overflow        string `yaml:"overflow"`
+	SinkCapacity    int    `yaml:"sink_capacity"`
+	StatsIntervalMs int    `yaml:"stats_interval_ms"`
+}
+
+// Sink is one destination for plans: type log, grpc with an executor Addr,
+// or journal, which appends JSON lines to files in Dir rotated after
+// MaxBytes or MaxAgeMs. A plan reaches the sink only when every leg trades on one of
+// Exchanges, it earns at least MinProfit and its quote is one of
+// QuoteCurrencies; empty filters match everything. Only sinks with Feedback
+// set count towards executor rejection cool-downs. TimeoutMs bounds the wait
+// for an unqueued sink; 0 waits as long as it takes.
+type Sink struct {
+	Name            string   `yaml:"name"`
+	Type            string   `yaml:"type"`
+	Addr            string   `yaml:"addr"`
+	Dir             string   `yaml:"dir"`
+	MaxBytes        int64    `yaml:"max_bytes"`
+	MaxAgeMs        int      `yaml:"max_age_ms"`
+	Exchanges       []string `yaml:"exchanges"`
+	MinProfit       float64  `yaml:"min_profit"`
+	QuoteCurrencies []string `yaml:"quote_currencies"`
+	Feedback        bool     `yaml:"feedback"`
+	TimeoutMs       int      `yaml:"timeout_ms"`
+}
+
+// Capture records every ingress delta to files in Dir for replay, starting
+// a new file every MaxBytes or MaxAgeMs. An empty Dir records nothing.
+type Capture struct {
+	Dir      string `yaml:"dir"`
+	MaxBytes int64  `yaml:"max_bytes"`
+	MaxAgeMs int    `yaml:"max_age_ms"`
+}
+
+// Health marks the finder ready once markets are loaded and every exchange
+// in Exchanges sent a delta within MaxFeedAgeMs; zero only waits for the
+// first delta. Without Exchanges, every exchange that has sent a delta is
+// checked.
+type Health struct {
+	MaxFeedAgeMs int      `yaml:"max_feed_age_ms"`
+	Exchanges    []string `yaml:"exchanges"`
+}
+
+// Reload re-reads the config file when it changes, checked every
+// WatchIntervalMs, and on SIGHUP. Zero only reloads on SIGHUP.
+type Reload struct {
+	WatchIntervalMs int `yaml:"watch_interval_ms"`
 }
 
 type LogConfig struct {
@@ -54,6 +212,120 @@ func Load(path string) (*Config, error) {
 	return &cfg, nil
 }
 
+// maxCycleLength caps strategy.max_cycle_length, as the number of cycles
+// through a market grows exponentially with their length.
+const maxCycleLength = 6
+
+// Validate rejects values the finder cannot run with. Load does not call
+// it, so partial configs still load in tests.
+func (c *Config) Validate() error {
+	if len(c.QuoteAssets) == 0 {
+		return fmt.Errorf("quote_assets is empty")
+	}
+	for _, q := range c.QuoteAssets {
+		if q == "" {
+			return fmt.Errorf("quote_assets has an empty entry")
+		}
+	}
+	if err := c.Fees.Default.validate(); err != nil {
+		return fmt.Errorf("invalid default fee: %w", err)
+	}
+	for ex, quotes := range c.Fees.Exchanges {
+		for q, fee := range quotes {
+			if err := fee.validate(); err != nil {
+				return fmt.Errorf("invalid fee for %s %s: %w", ex, q, err)
+			}
+		}
+	}
+
+	s := c.Strategy
+	if !(s.MinProfitEdge >= 1) {
+		return fmt.Errorf("strategy.min_profit_edge must be at least 1, got %v", s.MinProfitEdge)
+	}
+	if !(s.SlippageBp >= 0 && s.SlippageBp < 10000) {
+		return fmt.Errorf("strategy.slippage_bp must be in [0, 10000), got %v", s.SlippageBp)
+	}
+	if !(s.TradeAmount > 0) {
+		return fmt.Errorf("strategy.trade_amount must be positive, got %v", s.TradeAmount)
+	}
+	for q, amt := range s.TradeAmounts {
+		if !(amt > 0) {
+			return fmt.Errorf("strategy.trade_amounts.%s must be positive, got %v", q, amt)
+		}
+	}
+	if s.OrderbookDepth < 0 {
+		return fmt.Errorf("strategy.orderbook_depth must not be negative, got %d", s.OrderbookDepth)
+	}
+	if !(s.MinFillRatio >= 0 && s.MinFillRatio <= 1) {
+		return fmt.Errorf("strategy.min_fill_ratio must be in [0, 1], got %v", s.MinFillRatio)
+	}
+	for q, b := range s.SizeBounds {
+		if !(b.Min >= 0 && b.Max >= b.Min) {
+			return fmt.Errorf("strategy.size_bounds.%s needs 0 <= min <= max, got [%v, %v]", q, b.Min, b.Max)
+		}
+	}
+	// 0 keeps the graph's default of 3
+	if s.MaxCycleLength != 0 && (s.MaxCycleLength < 2 || s.MaxCycleLength > maxCycleLength) {
+		return fmt.Errorf("strategy.max_cycle_length must be in [2, %d], got %d", maxCycleLength, s.MaxCycleLength)
+	}
+	switch s.Simulator {
+	case "", "tob", "depth", "optimize":
+	default:
+		return fmt.Errorf("unknown strategy.simulator %q", s.Simulator)
+	}
+	switch s.Detector {
+	case "", "cycles", "bellman_ford":
+	default:
+		return fmt.Errorf("unknown strategy.detector %q", s.Detector)
+	}
+
+	if c.Log.Level != "" {
+		if _, err := logrus.ParseLevel(strings.ToLower(c.Log.Level)); err != nil {
+			return fmt.Errorf("invalid log.level: %w", err)
+		}
+	}
+	for ex, balances := range c.Inventory {
+		for asset, amount := range balances {
+			if !(amount >= 0) {
+				return fmt.Errorf("inventory.%s.%s must not be negative, got %v", ex, asset, amount)
+			}
+		}
+	}
+	return nil
+}
+
+func (f FeeConfig) validate() error {
+	if !(f.Taker >= 0 && f.Taker < 10000) || !(f.Maker >= 0 && f.Maker < 10000) {
+		return fmt.Errorf("taker and maker must be in [0, 10000) bp, got %v and %v", f.Taker, f.Maker)
+	}
+	return nil
+}
+
+// RestartNeeded lists the sections that differ in next but only take
+// effect on restart. Quote assets, fees, the strategy pricing knobs,
+// inventory and the log level are applied live.
+func (c *Config) RestartNeeded(next *Config) []string {
+	var res []string
+	check := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			res = append(res, name)
+		}
+	}
+	check("instrument_files", c.InstrumentFiles, next.InstrumentFiles)
+	check("strategy.max_cycle_length", c.Strategy.MaxCycleLength, next.Strategy.MaxCycleLength)
+	check("cross_exchange", c.CrossExchange, next.CrossExchange)
+	check("feeds", c.Feeds, next.Feeds)
+	check("quote_age", c.QuoteAge, next.QuoteAge)
+	check("feedback", c.Feedback, next.Feedback)
+	check("dedup", c.Dedup, next.Dedup)
+	check("publish_queue", c.PublishQueue, next.PublishQueue)
+	check("sinks", c.Sinks, next.Sinks)
+	check("capture", c.Capture, next.Capture)
+	check("health", c.Health, next.Health)
+	check("reload", c.Reload, next.Reload)
+	return res
+}
+
 func (c *Config) ParseMarket(exchange, symbol string) (types.Market, error) {
 	base, quote, err := parseSymbol(symbol, c.QuoteAssets)
 	if err != nil {
@@ -80,4 +352,4 @@ func (c *Config) GetFee(exchange, quoteAsset string) types.Fee {
 		}
 	}
 	return types.Fee{TakerBp: c.Fees.Default.Taker, MakerBp: c.Fees.Default.Maker}
-}
\ No newline at end of file
+}
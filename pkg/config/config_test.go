@@ -357,6 +357,218 @@ log:
 	}
 }
 
+func TestLoadCrossExchangeConfig(t *testing.T) {
+	configYAML := `
+cross_exchange:
+  enabled: true
+  transfers:
+    USDT:
+      fixed: 1.0
+    BTC:
+      fixed: 0.0001
+      bp: 2.0
+  latency:
+    - venues: [BINANCE, KUCOIN]
+      bp: 5.0
+`
+
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(configYAML), &cfg); err != nil {
+		t.Fatalf("Failed to unmarshal config YAML: %v", err)
+	}
+
+	if !cfg.CrossExchange.Enabled {
+		t.Error("Expected cross-exchange mode to be enabled")
+	}
+	if btc := cfg.CrossExchange.Transfers["BTC"]; btc.Fixed != 0.0001 || btc.Bp != 2.0 {
+		t.Errorf("Unexpected BTC transfer cost %+v", btc)
+	}
+	expected := []LatencyPenalty{{Venues: []string{"BINANCE", "KUCOIN"}, Bp: 5.0}}
+	if !reflect.DeepEqual(cfg.CrossExchange.Latency, expected) {
+		t.Errorf("Expected latency %+v, got %+v", expected, cfg.CrossExchange.Latency)
+	}
+}
+
+func TestLoadInventoryConfig(t *testing.T) {
+	configYAML := `
+inventory:
+  BINANCE:
+    USDT: 2000.0
+    BTC: 0.05
+  KUCOIN:
+    ETH: 1.5
+`
+
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(configYAML), &cfg); err != nil {
+		t.Fatalf("Failed to unmarshal config YAML: %v", err)
+	}
+
+	expected := Inventory{
+		"BINANCE": {"USDT": 2000.0, "BTC": 0.05},
+		"KUCOIN":  {"ETH": 1.5},
+	}
+	if !reflect.DeepEqual(cfg.Inventory, expected) {
+		t.Errorf("Expected inventory %+v, got %+v", expected, cfg.Inventory)
+	}
+}
+
+func TestLoadQuoteAgeConfig(t *testing.T) {
+	var cfg Config
+	if cfg.QuoteAge.Enabled() {
+		t.Error("Expected the quote age guard to be off by default")
+	}
+	configYAML := `
+quote_age:
+  max_age_ms: 2000
+  max_skew_ms: 500
+  exchanges:
+    KUCOIN: 5000
+`
+	if err := yaml.Unmarshal([]byte(configYAML), &cfg); err != nil {
+		t.Fatalf("Failed to unmarshal config YAML: %v", err)
+	}
+	expected := QuoteAge{MaxAgeMs: 2000, MaxSkewMs: 500, Exchanges: map[string]int{"KUCOIN": 5000}}
+	if !reflect.DeepEqual(cfg.QuoteAge, expected) || !cfg.QuoteAge.Enabled() {
+		t.Errorf("Expected quote age %+v, got %+v", expected, cfg.QuoteAge)
+	}
+}
+
+func TestLoadSinksConfig(t *testing.T) {
+	configYAML := `
+sinks:
+  - name: live
+    type: grpc
+    addr: executor:50052
+    feedback: true
+  - name: paper
+    type: grpc
+    addr: paper:50052
+    exchanges: [BINANCE]
+    min_profit: 0.5
+    quote_currencies: [USDT]
+    timeout_ms: 2000
+`
+
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(configYAML), &cfg); err != nil {
+		t.Fatalf("Failed to unmarshal config YAML: %v", err)
+	}
+
+	expected := []Sink{
+		{Name: "live", Type: "grpc", Addr: "executor:50052", Feedback: true},
+		{Name: "paper", Type: "grpc", Addr: "paper:50052", Exchanges: []string{"BINANCE"}, MinProfit: 0.5, QuoteCurrencies: []string{"USDT"}, TimeoutMs: 2000},
+	}
+	if !reflect.DeepEqual(cfg.Sinks, expected) {
+		t.Errorf("Expected sinks %+v, got %+v", expected, cfg.Sinks)
+	}
+}
+
+func validTestConfig() *Config {
+	return &Config{
+		QuoteAssets: []string{"USDT", "BTC"},
+		Fees: Fees{
+			Default:   FeeConfig{Taker: 10, Maker: 5},
+			Exchanges: map[string]FeeQuotes{"BINANCE": {"USDT": {Taker: 7, Maker: 2}}},
+		},
+		Strategy: Strategy{
+			MinProfitEdge: 1.0001,
+			SlippageBp:    1,
+			TradeAmount:   100,
+			TradeAmounts:  map[string]float64{"USDT": 100},
+			Simulator:     "depth",
+			MinFillRatio:  0.5,
+			SizeBounds:    map[string]SizeBound{"USDT": {Min: 20, Max: 5000}},
+			Detector:      "bellman_ford",
+		},
+		Inventory: Inventory{"BINANCE": {"USDT": 1000}},
+		Log:       LogConfig{Level: "debug"},
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := validTestConfig().Validate(); err != nil {
+		t.Fatalf("Expected a valid config, got %v", err)
+	}
+	for _, n := range []int{2, 6} {
+		c := validTestConfig()
+		c.Strategy.MaxCycleLength = n
+		if err := c.Validate(); err != nil {
+			t.Errorf("Expected max_cycle_length %d to be valid, got %v", n, err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		change func(c *Config)
+	}{
+		{"no quote assets", func(c *Config) { c.QuoteAssets = nil }},
+		{"empty quote asset", func(c *Config) { c.QuoteAssets = append(c.QuoteAssets, "") }},
+		{"negative default fee", func(c *Config) { c.Fees.Default.Taker = -1 }},
+		{"exchange fee too high", func(c *Config) { c.Fees.Exchanges["BINANCE"]["USDT"] = FeeConfig{Taker: 10000} }},
+		{"edge below 1", func(c *Config) { c.Strategy.MinProfitEdge = 0.001 }},
+		{"negative slippage", func(c *Config) { c.Strategy.SlippageBp = -1 }},
+		{"zero trade amount", func(c *Config) { c.Strategy.TradeAmount = 0 }},
+		{"negative quote trade amount", func(c *Config) { c.Strategy.TradeAmounts["USDT"] = -5 }},
+		{"negative depth", func(c *Config) { c.Strategy.OrderbookDepth = -1 }},
+		{"fill ratio above 1", func(c *Config) { c.Strategy.MinFillRatio = 1.5 }},
+		{"inverted size bounds", func(c *Config) { c.Strategy.SizeBounds["USDT"] = SizeBound{Min: 10, Max: 5} }},
+		{"one-leg cycles", func(c *Config) { c.Strategy.MaxCycleLength = 1 }},
+		{"cycles too long", func(c *Config) { c.Strategy.MaxCycleLength = 50 }},
+		{"unknown simulator", func(c *Config) { c.Strategy.Simulator = "magic" }},
+		{"unknown detector", func(c *Config) { c.Strategy.Detector = "dfs" }},
+		{"negative balance", func(c *Config) { c.Inventory["BINANCE"]["USDT"] = -1 }},
+		{"unknown log level", func(c *Config) { c.Log.Level = "loud" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validTestConfig()
+			tt.change(c)
+			if err := c.Validate(); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
+
+func TestConfigRestartNeeded(t *testing.T) {
+	old := validTestConfig()
+	next := validTestConfig()
+	next.Fees.Default.Taker = 12
+	next.Strategy.MinProfitEdge = 1.001
+	next.QuoteAssets = append(next.QuoteAssets, "IRT")
+	if sections := old.RestartNeeded(next); len(sections) != 0 {
+		t.Errorf("Expected live changes only, got %v", sections)
+	}
+
+	next.Strategy.MaxCycleLength = 4
+	next.Sinks = []Sink{{Name: "live", Type: "log"}}
+	expected := []string{"strategy.max_cycle_length", "sinks"}
+	if sections := old.RestartNeeded(next); !reflect.DeepEqual(sections, expected) {
+		t.Errorf("Expected %v, got %v", expected, sections)
+	}
+}
+
+func TestFeedMarketKeys(t *testing.T) {
+	keys, err := Feed{Addr: "gw:1", Markets: []string{"binance:BTCUSDT", "KUCOIN:ETH-USDT"}}.MarketKeys()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []types.MarketKey{types.NewMarketKey("BINANCE", "BTCUSDT"), types.NewMarketKey("KUCOIN", "ETH-USDT")}
+	if !reflect.DeepEqual(keys, expected) {
+		t.Errorf("Expected %v, got %v", expected, keys)
+	}
+
+	if keys, err := (Feed{Addr: "gw:1"}).MarketKeys(); err != nil || keys != nil {
+		t.Errorf("Expected no markets to mean the whole index, got %v %v", keys, err)
+	}
+	for _, bad := range []string{"BTCUSDT", ":BTCUSDT", "BINANCE:"} {
+		if _, err := (Feed{Addr: "gw:1", Markets: []string{bad}}).MarketKeys(); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
 func TestQuoteAssetSorting(t *testing.T) {
 	// Test the sorting logic that's part of the Load function
 	quoteAssets := []string{"USD", "USDT", "BTC", "ETH"}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
+	"github.com/sirupsen/logrus"
+)
+
+// Watcher re-reads a config file and hands every valid new version to
+// Apply. A version that fails to load or validate is rejected, and the
+// running config stays in place.
+type Watcher struct {
+	Path     string
+	Interval time.Duration // how often to check the file for changes, 0 = never
+	Apply    func(*Config)
+
+	mu      sync.Mutex
+	current *Config
+	modTime time.Time
+	size    int64
+}
+
+// NewWatcher watches path, which current was loaded from.
+func NewWatcher(path string, current *Config, interval time.Duration, apply func(*Config)) *Watcher {
+	w := &Watcher{Path: path, Interval: interval, Apply: apply, current: current}
+	if info, err := os.Stat(path); err == nil {
+		w.modTime, w.size = info.ModTime(), info.Size()
+	}
+	return w
+}
+
+// Current returns the config last applied.
+func (w *Watcher) Current() *Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.current
+}
+
+// Reload loads and validates the file and applies it. The error says why a
+// new version was rejected.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if info, err := os.Stat(w.Path); err == nil {
+		w.modTime, w.size = info.ModTime(), info.Size()
+	}
+	next, err := Load(w.Path)
+	if err != nil {
+		return err
+	}
+	if err := next.Validate(); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if sections := w.current.RestartNeeded(next); len(sections) > 0 {
+		logger.Log.WithField("sections", sections).Warn("config: changes to these sections apply after a restart")
+	}
+	w.Apply(next)
+	w.current = next
+	logger.Log.WithField("path", w.Path).Info("config: reloaded")
+	return nil
+}
+
+// changed reports whether the file was modified since the last reload.
+func (w *Watcher) changed() bool {
+	info, err := os.Stat(w.Path)
+	if err != nil {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !info.ModTime().Equal(w.modTime) || info.Size() != w.size
+}
+
+// Run reloads on every value from signals and whenever the file changes,
+// until ctx ends.
+func (w *Watcher) Run(ctx context.Context, signals <-chan os.Signal) {
+	var tick <-chan time.Time
+	if w.Interval > 0 {
+		ticker := time.NewTicker(w.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		case <-tick:
+			if !w.changed() {
+				continue
+			}
+		}
+		if err := w.Reload(); err != nil {
+			logger.Log.WithFields(logrus.Fields{"path": w.Path, "error": err}).Error("config: rejected new config, keeping the running one")
+		}
+	}
+}
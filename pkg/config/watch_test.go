@@ -0,0 +1,104 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func writeTestConfig(t *testing.T, path string, edge string) {
+	t.Helper()
+	data := `
+quote_assets: [USDT, BTC]
+fees:
+  default:
+    taker: 10.0
+strategy:
+  min_profit_edge: ` + edge + `
+  trade_amount: 100.0
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestConfig(t, path, "1.001")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var applied []*Config
+	w := NewWatcher(path, cfg, 0, func(next *Config) { applied = append(applied, next) })
+
+	writeTestConfig(t, path, "0.5")
+	if err := w.Reload(); err == nil || !strings.Contains(err.Error(), "min_profit_edge") {
+		t.Errorf("Expected the invalid edge to be rejected, got %v", err)
+	}
+	os.WriteFile(path, []byte("quote_assets: [unclosed"), 0o644)
+	if err := w.Reload(); err == nil {
+		t.Error("Expected unparseable yaml to be rejected")
+	}
+	if len(applied) != 0 || w.Current() != cfg {
+		t.Fatal("Expected rejected configs to leave the running one in place")
+	}
+
+	writeTestConfig(t, path, "1.002")
+	if err := w.Reload(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(applied) != 1 || applied[0].Strategy.MinProfitEdge != 1.002 || w.Current() != applied[0] {
+		t.Errorf("Expected the new config to be applied, got %+v", applied)
+	}
+	if q := applied[0].QuoteAssets; q[0] != "USDT" {
+		t.Errorf("Expected quote assets sorted as by Load, got %v", q)
+	}
+}
+
+func TestWatcherRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeTestConfig(t, path, "1.001")
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := make(chan *Config, 4)
+	w := NewWatcher(path, cfg, time.Millisecond, func(next *Config) { applied <- next })
+	signals := make(chan os.Signal, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { w.Run(ctx, signals); close(done) }()
+	defer func() { cancel(); <-done }()
+
+	next := func() *Config {
+		t.Helper()
+		select {
+		case c := <-applied:
+			return c
+		case <-time.After(time.Second):
+			t.Fatal("Expected a reload")
+			return nil
+		}
+	}
+
+	// Different length, so the change shows even on a coarse mtime
+	writeTestConfig(t, path, "1.0025")
+	if c := next(); c.Strategy.MinProfitEdge != 1.0025 {
+		t.Errorf("Expected the changed file to be applied, got %v", c.Strategy.MinProfitEdge)
+	}
+
+	signals <- syscall.SIGHUP
+	if c := next(); c.Strategy.MinProfitEdge != 1.0025 {
+		t.Errorf("Expected SIGHUP to reload the file, got %v", c.Strategy.MinProfitEdge)
+	}
+	select {
+	case c := <-applied:
+		t.Errorf("Expected no reload while the file is unchanged, got %+v", c)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
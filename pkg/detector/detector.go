@@ -1,63 +1,138 @@
 package detector
 
 import (
-	"fmt"
-	"strings"
+	"errors"
+	"sync"
+	"time"
 
 	"github.com/armagg/circular-arbitrage-finder/pkg/apiout"
 	"github.com/armagg/circular-arbitrage-finder/pkg/bookstore"
+	"github.com/armagg/circular-arbitrage-finder/pkg/feedback"
 	"github.com/armagg/circular-arbitrage-finder/pkg/graph"
 	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
 	"github.com/armagg/circular-arbitrage-finder/pkg/profit"
 	"github.com/armagg/circular-arbitrage-finder/pkg/registry"
 	"github.com/armagg/circular-arbitrage-finder/pkg/types"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	evaluations = promauto.NewCounter(prometheus.CounterOpts{Name: "arb_detector_evaluations_total", Help: "Cycles priced by the simulator."})
+	hits        = promauto.NewCounter(prometheus.CounterOpts{Name: "arb_detector_hits_total", Help: "Cycles priced as profitable."})
+	rejects     = promauto.NewCounterVec(prometheus.CounterOpts{Name: "arb_detector_rejects_total", Help: "Cycles not published, per reason."}, []string{"reason"})
+)
+
 type Detector struct {
 	Index     *graph.Index
 	Books     *bookstore.TopOfBookStore
 	Registry  *registry.MarketRegistry
 	Sim       profit.Simulator
 	Publisher apiout.Publisher
+	Source    CycleSource
+	Feedback  *feedback.Tracker
+	Now       func() time.Time
+
+	// Evaluations hold mu for reading, so Reconfigure never lands halfway
+	// through one.
+	mu sync.RWMutex
+}
+
+// CycleSource picks the cycles worth evaluating after market mid changed.
+type CycleSource interface {
+	CyclesFor(mid int) []types.Cycle
+}
+
+// IndexCycles re-evaluates the cycles precomputed by the graph index.
+type IndexCycles struct {
+	Index *graph.Index
+}
+
+func (c IndexCycles) CyclesFor(mid int) []types.Cycle {
+	return c.Index.CyclesFor(mid)
 }
 
 func NewDetector(idx *graph.Index, books *bookstore.TopOfBookStore, reg *registry.MarketRegistry, sim profit.Simulator, pub apiout.Publisher) *Detector {
-	return &Detector{Index: idx, Books: books, Registry: reg, Sim: sim, Publisher: pub}
+	return &Detector{Index: idx, Books: books, Registry: reg, Sim: sim, Publisher: pub, Source: IndexCycles{Index: idx}, Now: time.Now}
 }
 
 func (d *Detector) OnMarketChange(exchange, symbol string, targetQuote float64) {
-	key := fmt.Sprintf("%s:%s", strings.ToUpper(exchange), strings.ToUpper(symbol))
-	mid, ok := d.Index.MarketIndexBySymbol[key]
+	key := types.NewMarketKey(exchange, symbol)
+	mid, ok := d.Index.Lookup(key)
 	if !ok {
 
-		logger.Log.WithField("market", key).Warn("detector: received update for unknown market")
+		logger.Log.WithField("market", key.String()).Warn("detector: received update for unknown market")
 		return
 	}
 
-	tris := d.Index.TrianglesByMarket[mid]
-	if len(tris) == 0 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	cycles := d.Source.CyclesFor(mid)
+	if len(cycles) == 0 {
 		return
 	}
-	tobFn := func(sym string) (types.TopOfBook, bool) { return d.Books.Get(sym) }
-	feeFn := func(sym string) (types.Fee, bool) { return d.Registry.GetFee(sym) }
-	for _, ti := range tris {
-		t := d.Index.Triangles[ti]
-		plan, ok := d.Sim.EvaluateTOB(t, d.Index.Markets, tobFn, feeFn, targetQuote)
+	markets := d.Index.MarketsSnapshot()
+	for _, t := range cycles {
+		plan, ok := d.Sim.EvaluateTOB(t, markets, d.Books.Get, d.Registry.GetFee, targetQuote)
+		evaluations.Inc()
 		if ok {
+			hits.Inc()
 			logger.Log.WithFields(logrus.Fields{
 				"symbol":         symbol,
-				"triangle":       t.MarketIds,
+				"cycle":          t.MarketIds,
 				"profit_quote":   plan.ExpectedProfitQuote,
 				"quote_currency": plan.QuoteCurrency,
 			}).Info("detector: found profitable arbitrage")
-			_ = d.Publisher.Publish(plan)
+			if d.Feedback.CoolingDown(plan) {
+				rejects.WithLabelValues("cooling_down").Inc()
+				logger.Log.WithField("cycle", t.MarketIds).Debug("detector: cycle cooling down after executor rejections")
+				continue
+			}
+			plan.PlanID = apiout.PlanID(plan)
+			if plan.Decision != nil {
+				plan.Decision.TsNs = d.Now().UnixNano()
+			}
+			err := d.Publisher.Publish(plan)
+			if errors.Is(err, apiout.ErrDuplicate) {
+				rejects.WithLabelValues("duplicate").Inc()
+				logger.Log.WithFields(logrus.Fields{"cycle": t.MarketIds, "plan_id": plan.PlanID}).Debug("detector: plan already published")
+				continue
+			}
+			if errors.Is(err, apiout.ErrQueueFull) {
+				rejects.WithLabelValues("queue_full").Inc()
+				logger.Log.WithFields(logrus.Fields{"cycle": t.MarketIds, "plan_id": plan.PlanID}).Debug("detector: publish queue full, plan dropped")
+				continue
+			}
+			// A queued plan's reply is recorded by the queue's OnResult.
+			if errors.Is(err, apiout.ErrQueued) {
+				continue
+			}
+			if err != nil {
+				rejects.WithLabelValues("publish_error").Inc()
+				logger.Log.WithFields(logrus.Fields{"cycle": t.MarketIds, "error": err}).Warn("detector: failed to publish plan")
+			}
+			d.Feedback.Record(plan, err)
 		} else {
+			rejects.WithLabelValues("unprofitable").Inc()
 			logger.Log.WithFields(logrus.Fields{
 				"symbol":   symbol,
-				"triangle": t.MarketIds,
+				"cycle":    t.MarketIds,
 			}).Debug("detector: arbitrage not profitable")
 		}
 	}
 }
+
+// Reconfigure swaps in a new simulator and cycle source and re-prices every
+// registered market with fee, in one step between evaluations.
+func (d *Detector) Reconfigure(sim profit.Simulator, source CycleSource, fee func(types.Market) types.Fee) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	markets, _ := d.Registry.Snapshot()
+	for key, m := range markets {
+		d.Registry.SetFee(key, fee(m))
+	}
+	d.Sim = sim
+	d.Source = source
+}
@@ -1,15 +1,20 @@
 package detector
 
 import (
+	"reflect"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/armagg/circular-arbitrage-finder/pkg/apiout"
 	"github.com/armagg/circular-arbitrage-finder/pkg/bookstore"
+	"github.com/armagg/circular-arbitrage-finder/pkg/feedback"
 	"github.com/armagg/circular-arbitrage-finder/pkg/graph"
 	"github.com/armagg/circular-arbitrage-finder/pkg/profit"
 	"github.com/armagg/circular-arbitrage-finder/pkg/registry"
 	"github.com/armagg/circular-arbitrage-finder/pkg/types"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/sirupsen/logrus"
 )
 
@@ -147,18 +152,18 @@ func TestDetectorOnMarketChangeWithTriangles(t *testing.T) {
 	for _, market := range markets {
 		idx.AddMarket(market)
 		reg.UpsertMarket(market)
-		reg.SetFee(market.Symbol, types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+		reg.SetFee(market.Key(), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
 	}
 
 	// Set up profitable arbitrage prices
-	tobData := map[string]types.TopOfBook{
-		"BTCUSDT": {BidPx: 49900.0, AskPx: 50000.0, BidSz: 2.1, AskSz: 1.8},
-		"ETHUSDT": {BidPx: 2990.0, AskPx: 3000.0, BidSz: 10.0, AskSz: 8.0},
-		"ETHBTC":  {BidPx: 0.0598, AskPx: 0.0600, BidSz: 65.0, AskSz: 62.0},
+	tobData := map[types.MarketKey]types.TopOfBook{
+		types.NewMarketKey("binance", "BTCUSDT"): {BidPx: 49900.0, AskPx: 50000.0, BidSz: 2.1, AskSz: 1.8},
+		types.NewMarketKey("binance", "ETHUSDT"): {BidPx: 2990.0, AskPx: 3000.0, BidSz: 10.0, AskSz: 8.0},
+		types.NewMarketKey("binance", "ETHBTC"):  {BidPx: 0.0598, AskPx: 0.0600, BidSz: 65.0, AskSz: 62.0},
 	}
 
-	for symbol, tob := range tobData {
-		books.Set(symbol, tob)
+	for key, tob := range tobData {
+		books.Set(key, tob)
 	}
 
 	// This should find profitable arbitrage
@@ -228,12 +233,12 @@ func TestDetectorOnMarketChangeMissingData(t *testing.T) {
 	for _, market := range markets {
 		idx.AddMarket(market)
 		reg.UpsertMarket(market)
-		reg.SetFee(market.Symbol, types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+		reg.SetFee(market.Key(), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
 	}
 
 	// Only set up partial data (missing ETHBTC)
-	books.Set("BTCUSDT", types.TopOfBook{BidPx: 50000.0, AskPx: 50010.0})
-	books.Set("ETHUSDT", types.TopOfBook{BidPx: 3000.0, AskPx: 3010.0})
+	books.Set(types.NewMarketKey("binance", "BTCUSDT"), types.TopOfBook{BidPx: 50000.0, AskPx: 50010.0})
+	books.Set(types.NewMarketKey("binance", "ETHUSDT"), types.TopOfBook{BidPx: 3000.0, AskPx: 3010.0})
 	// Missing ETHBTC data
 
 	detector.OnMarketChange("binance", "BTCUSDT", 1000.0)
@@ -262,18 +267,18 @@ func TestDetectorOnMarketChangeInvalidPrices(t *testing.T) {
 	for _, market := range markets {
 		idx.AddMarket(market)
 		reg.UpsertMarket(market)
-		reg.SetFee(market.Symbol, types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+		reg.SetFee(market.Key(), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
 	}
 
 	// Set up invalid prices (zero or negative)
-	tobData := map[string]types.TopOfBook{
-		"BTCUSDT": {BidPx: 0, AskPx: 0, BidSz: 2.1, AskSz: 1.8}, // Invalid prices
-		"ETHUSDT": {BidPx: 3000.0, AskPx: 3010.0, BidSz: 10.0, AskSz: 8.0},
-		"ETHBTC":  {BidPx: 0.06, AskPx: 0.0602, BidSz: 65.0, AskSz: 62.0},
+	tobData := map[types.MarketKey]types.TopOfBook{
+		types.NewMarketKey("binance", "BTCUSDT"): {BidPx: 0, AskPx: 0, BidSz: 2.1, AskSz: 1.8}, // Invalid prices
+		types.NewMarketKey("binance", "ETHUSDT"): {BidPx: 3000.0, AskPx: 3010.0, BidSz: 10.0, AskSz: 8.0},
+		types.NewMarketKey("binance", "ETHBTC"):  {BidPx: 0.06, AskPx: 0.0602, BidSz: 65.0, AskSz: 62.0},
 	}
 
-	for symbol, tob := range tobData {
-		books.Set(symbol, tob)
+	for key, tob := range tobData {
+		books.Set(key, tob)
 	}
 
 	detector.OnMarketChange("binance", "BTCUSDT", 1000.0)
@@ -302,8 +307,8 @@ func TestDetectorConcurrency(t *testing.T) {
 
 	idx.AddMarket(market)
 	reg.UpsertMarket(market)
-	reg.SetFee(market.Symbol, types.Fee{TakerBp: 0.1, MakerBp: 0.05})
-	books.Set("BTCUSDT", types.TopOfBook{BidPx: 50000.0, AskPx: 50010.0})
+	reg.SetFee(market.Key(), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+	books.Set(types.NewMarketKey("binance", "BTCUSDT"), types.TopOfBook{BidPx: 50000.0, AskPx: 50010.0})
 
 	// Test concurrent calls
 	var wg sync.WaitGroup
@@ -348,21 +353,21 @@ func TestDetectorMultipleTriangles(t *testing.T) {
 	for _, market := range markets {
 		idx.AddMarket(market)
 		reg.UpsertMarket(market)
-		reg.SetFee(market.Symbol, types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+		reg.SetFee(market.Key(), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
 	}
 
 	// Set up prices for all markets
-	tobData := map[string]types.TopOfBook{
-		"BTCUSDT": {BidPx: 50000.0, AskPx: 50010.0, BidSz: 2.1, AskSz: 1.8},
-		"ETHUSDT": {BidPx: 3000.0, AskPx: 3010.0, BidSz: 10.0, AskSz: 8.0},
-		"ADAUSDT": {BidPx: 1.5, AskPx: 1.52, BidSz: 1000.0, AskSz: 800.0},
-		"ETHBTC":  {BidPx: 0.06, AskPx: 0.0602, BidSz: 65.0, AskSz: 62.0},
-		"ADABTC":  {BidPx: 0.000030, AskPx: 0.000031, BidSz: 50000.0, AskSz: 40000.0},
-		"ADAETH":  {BidPx: 0.0005, AskPx: 0.00052, BidSz: 10000.0, AskSz: 8000.0},
+	tobData := map[types.MarketKey]types.TopOfBook{
+		types.NewMarketKey("binance", "BTCUSDT"): {BidPx: 50000.0, AskPx: 50010.0, BidSz: 2.1, AskSz: 1.8},
+		types.NewMarketKey("binance", "ETHUSDT"): {BidPx: 3000.0, AskPx: 3010.0, BidSz: 10.0, AskSz: 8.0},
+		types.NewMarketKey("binance", "ADAUSDT"): {BidPx: 1.5, AskPx: 1.52, BidSz: 1000.0, AskSz: 800.0},
+		types.NewMarketKey("binance", "ETHBTC"):  {BidPx: 0.06, AskPx: 0.0602, BidSz: 65.0, AskSz: 62.0},
+		types.NewMarketKey("binance", "ADABTC"):  {BidPx: 0.000030, AskPx: 0.000031, BidSz: 50000.0, AskSz: 40000.0},
+		types.NewMarketKey("binance", "ADAETH"):  {BidPx: 0.0005, AskPx: 0.00052, BidSz: 10000.0, AskSz: 8000.0},
 	}
 
-	for symbol, tob := range tobData {
-		books.Set(symbol, tob)
+	for key, tob := range tobData {
+		books.Set(key, tob)
 	}
 
 	// Trigger detection on one market
@@ -381,6 +386,299 @@ func TestDetectorMultipleTriangles(t *testing.T) {
 	}
 }
 
+func TestDetectorExchangeIsolation(t *testing.T) {
+	idx := graph.NewIndex()
+	books := bookstore.NewTopOfBookStore()
+	reg := registry.NewMarketRegistry()
+	sim := profit.NewTOBSimulator(1.0, 0)
+	pub := NewMockPublisher()
+
+	detector := NewDetector(idx, books, reg, sim, pub)
+
+	// The same three symbols listed on two exchanges
+	for _, exchange := range []string{"BINANCE", "KUCOIN"} {
+		for _, market := range []types.Market{
+			{Exchange: exchange, Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+			{Exchange: exchange, Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+			{Exchange: exchange, Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+		} {
+			idx.AddMarket(market)
+			reg.UpsertMarket(market)
+			reg.SetFee(market.Key(), types.Fee{})
+		}
+	}
+
+	// Only BINANCE is dislocated; KUCOIN prices are fair in either direction
+	books.Set(types.NewMarketKey("BINANCE", "ETHUSDT"), types.TopOfBook{BidPx: 2999.0, AskPx: 3000.0})
+	books.Set(types.NewMarketKey("BINANCE", "ETHBTC"), types.TopOfBook{BidPx: 0.0605, AskPx: 0.0606})
+	books.Set(types.NewMarketKey("BINANCE", "BTCUSDT"), types.TopOfBook{BidPx: 50100.0, AskPx: 50110.0})
+	books.Set(types.NewMarketKey("KUCOIN", "ETHUSDT"), types.TopOfBook{BidPx: 2999.0, AskPx: 3000.0})
+	books.Set(types.NewMarketKey("KUCOIN", "ETHBTC"), types.TopOfBook{BidPx: 0.0599, AskPx: 0.06})
+	books.Set(types.NewMarketKey("KUCOIN", "BTCUSDT"), types.TopOfBook{BidPx: 49990.0, AskPx: 50000.0})
+
+	detector.OnMarketChange("kucoin", "BTCUSDT", 1000.0)
+	if published := pub.GetPublishedPlans(); len(published) != 0 {
+		t.Fatalf("Expected KUCOIN triangle to ignore BINANCE prices, got %d plans", len(published))
+	}
+
+	detector.OnMarketChange("binance", "BTCUSDT", 1000.0)
+	published := pub.GetPublishedPlans()
+	if len(published) == 0 {
+		t.Fatal("Expected a BINANCE plan")
+	}
+	for _, plan := range published {
+		if plan.Exchange != "BINANCE" {
+			t.Errorf("Expected plan on BINANCE, got %s", plan.Exchange)
+		}
+	}
+}
+
+func TestDetectorBothDirections(t *testing.T) {
+	idx := graph.NewIndex()
+	books := bookstore.NewTopOfBookStore()
+	reg := registry.NewMarketRegistry()
+	pub := NewMockPublisher()
+
+	detector := NewDetector(idx, books, reg, profit.NewTOBSimulator(1.0, 0), pub)
+
+	for _, market := range []types.Market{
+		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+	} {
+		idx.AddMarket(market)
+		reg.UpsertMarket(market)
+		reg.SetFee(market.Key(), types.Fee{})
+	}
+
+	tests := []struct {
+		name    string
+		ethusdt types.TopOfBook
+		ethbtc  types.TopOfBook
+		btcusdt types.TopOfBook
+		symbols []string
+		sides   []types.Side
+	}{
+		{
+			"ETH cheap in USDT",
+			types.TopOfBook{BidPx: 2999.0, AskPx: 3000.0},
+			types.TopOfBook{BidPx: 0.0605, AskPx: 0.0606},
+			types.TopOfBook{BidPx: 50100.0, AskPx: 50110.0},
+			[]string{"ETHUSDT", "ETHBTC", "BTCUSDT"},
+			[]types.Side{types.SideBuy, types.SideSell, types.SideSell},
+		},
+		{
+			"BTC cheap in USDT",
+			types.TopOfBook{BidPx: 3000.0, AskPx: 3001.0},
+			types.TopOfBook{BidPx: 0.0599, AskPx: 0.06},
+			types.TopOfBook{BidPx: 48990.0, AskPx: 49000.0},
+			[]string{"BTCUSDT", "ETHBTC", "ETHUSDT"},
+			[]types.Side{types.SideBuy, types.SideBuy, types.SideSell},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(pub.GetPublishedPlans())
+			books.Set(types.NewMarketKey("binance", "ETHUSDT"), tt.ethusdt)
+			books.Set(types.NewMarketKey("binance", "ETHBTC"), tt.ethbtc)
+			books.Set(types.NewMarketKey("binance", "BTCUSDT"), tt.btcusdt)
+
+			detector.OnMarketChange("binance", "ETHBTC", 1000.0)
+
+			published := pub.GetPublishedPlans()[before:]
+			if len(published) != 1 {
+				t.Fatalf("Expected 1 plan, got %d", len(published))
+			}
+			plan := published[0]
+			if plan.QuoteCurrency != "USDT" {
+				t.Errorf("Expected profit in USDT, got %s", plan.QuoteCurrency)
+			}
+			for i, leg := range plan.Legs {
+				if leg.Market != tt.symbols[i] || leg.Side != tt.sides[i] {
+					t.Errorf("Leg %d: expected %s %v, got %s %v", i, tt.symbols[i], tt.sides[i], leg.Market, leg.Side)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectorCoolsDownRejectedCycles(t *testing.T) {
+	idx := graph.NewIndex()
+	books := bookstore.NewTopOfBookStore()
+	reg := registry.NewMarketRegistry()
+	pub := NewMockPublisher()
+	pub.SetPublishFunc(func(plan types.Plan) error {
+		return &apiout.RejectedError{Reason: "insufficient balance"}
+	})
+
+	detector := NewDetector(idx, books, reg, profit.NewTOBSimulator(1.0, 0), pub)
+	detector.Feedback = feedback.NewTracker(2, time.Minute)
+	coolingDown := testutil.ToFloat64(rejects.WithLabelValues("cooling_down"))
+	evaluated := testutil.ToFloat64(evaluations)
+
+	for _, market := range []types.Market{
+		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+	} {
+		idx.AddMarket(market)
+		reg.UpsertMarket(market)
+		reg.SetFee(market.Key(), types.Fee{})
+	}
+	books.Set(types.NewMarketKey("binance", "ETHUSDT"), types.TopOfBook{BidPx: 2999.0, AskPx: 3000.0})
+	books.Set(types.NewMarketKey("binance", "ETHBTC"), types.TopOfBook{BidPx: 0.0605, AskPx: 0.0606})
+	books.Set(types.NewMarketKey("binance", "BTCUSDT"), types.TopOfBook{BidPx: 50100.0, AskPx: 50110.0})
+
+	for i := 0; i < 4; i++ {
+		detector.OnMarketChange("binance", "ETHBTC", 1000.0)
+	}
+	if published := pub.GetPublishedPlans(); len(published) != 2 {
+		t.Errorf("Expected publishing to stop after 2 rejections, got %d plans", len(published))
+	}
+	if n := testutil.ToFloat64(rejects.WithLabelValues("cooling_down")) - coolingDown; n != 2 {
+		t.Errorf("Expected 2 cool-down rejects, got %f", n)
+	}
+	// Both directions of the loop are priced on every change
+	if n := testutil.ToFloat64(evaluations) - evaluated; n != 8 {
+		t.Errorf("Expected 8 evaluations, got %f", n)
+	}
+	stats := detector.Feedback.Stats()
+	if stats.Reasons["insufficient balance"] != 2 || stats.Exchanges["BINANCE"].Rejected != 2 {
+		t.Errorf("Unexpected feedback stats %+v", stats)
+	}
+}
+
+func TestDetectorAssignsStablePlanIDs(t *testing.T) {
+	idx := graph.NewIndex()
+	books := bookstore.NewTopOfBookStore()
+	reg := registry.NewMarketRegistry()
+	pub := NewMockPublisher()
+	detector := NewDetector(idx, books, reg, profit.NewTOBSimulator(1.0, 0), pub)
+
+	for _, market := range []types.Market{
+		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+	} {
+		idx.AddMarket(market)
+		reg.UpsertMarket(market)
+		reg.SetFee(market.Key(), types.Fee{})
+	}
+	books.Set(types.NewMarketKey("binance", "ETHUSDT"), types.TopOfBook{BidPx: 2999.0, AskPx: 3000.0})
+	books.Set(types.NewMarketKey("binance", "ETHBTC"), types.TopOfBook{BidPx: 0.0605, AskPx: 0.0606})
+	books.Set(types.NewMarketKey("binance", "BTCUSDT"), types.TopOfBook{BidPx: 50100.0, AskPx: 50110.0})
+
+	detector.OnMarketChange("binance", "ETHBTC", 1000.0)
+	detector.OnMarketChange("binance", "BTCUSDT", 1000.0)
+
+	published := pub.GetPublishedPlans()
+	if len(published) != 2 {
+		t.Fatalf("Expected 2 plans, got %d", len(published))
+	}
+	if published[0].PlanID == "" || published[0].PlanID != published[1].PlanID {
+		t.Errorf("Expected the same opportunity to keep its ID, got %q and %q", published[0].PlanID, published[1].PlanID)
+	}
+	dec := published[0].Decision
+	if dec == nil || len(dec.Quotes) != 3 {
+		t.Fatalf("Expected a decision per leg, got %+v", dec)
+	}
+	for i, l := range published[0].Legs {
+		key := types.NewMarketKey(l.Exchange, l.Market)
+		if tob, _ := books.Get(key); dec.Quotes[i] != tob || dec.MarketIds[i] != idx.MarketIndexByKey[key] {
+			t.Errorf("Leg %d: expected the quote of %s, got %+v", i, key, dec.Quotes[i])
+		}
+	}
+
+	// Behind a dedup publisher the repeat never reaches the executor
+	pub = NewMockPublisher()
+	detector.Publisher = apiout.NewDedupPublisher(pub, time.Minute, 0.05)
+	detector.OnMarketChange("binance", "ETHBTC", 1000.0)
+	detector.OnMarketChange("binance", "BTCUSDT", 1000.0)
+	if n := len(pub.GetPublishedPlans()); n != 1 {
+		t.Errorf("Expected the repeat to be held back, got %d plans", n)
+	}
+}
+
+func TestDetectorQueuedPublisherReportsReplies(t *testing.T) {
+	idx := graph.NewIndex()
+	books := bookstore.NewTopOfBookStore()
+	reg := registry.NewMarketRegistry()
+	pub := NewMockPublisher()
+	pub.SetPublishFunc(func(plan types.Plan) error {
+		return &apiout.RejectedError{Reason: "insufficient balance"}
+	})
+	tracker := feedback.NewTracker(2, time.Minute)
+	queue := apiout.NewQueuedPublisher(pub, 16, 2, apiout.DropOldest)
+	queue.OnResult = tracker.Record
+
+	detector := NewDetector(idx, books, reg, profit.NewTOBSimulator(1.0, 0), queue)
+	detector.Feedback = tracker
+
+	for _, market := range []types.Market{
+		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+	} {
+		idx.AddMarket(market)
+		reg.UpsertMarket(market)
+		reg.SetFee(market.Key(), types.Fee{})
+	}
+	books.Set(types.NewMarketKey("binance", "ETHUSDT"), types.TopOfBook{BidPx: 2999.0, AskPx: 3000.0})
+	books.Set(types.NewMarketKey("binance", "ETHBTC"), types.TopOfBook{BidPx: 0.0605, AskPx: 0.0606})
+	books.Set(types.NewMarketKey("binance", "BTCUSDT"), types.TopOfBook{BidPx: 50100.0, AskPx: 50110.0})
+
+	detector.OnMarketChange("binance", "ETHBTC", 1000.0)
+	queue.Close()
+
+	// Only the executor's reply counts, not the hand-off to the queue
+	stats := tracker.Stats()
+	if c := stats.Exchanges["BINANCE"]; c.Accepted != 0 || c.Rejected != 1 {
+		t.Errorf("Unexpected feedback counts %+v", c)
+	}
+}
+
+func TestDetectorReconfigure(t *testing.T) {
+	idx := graph.NewIndex()
+	books := bookstore.NewTopOfBookStore()
+	reg := registry.NewMarketRegistry()
+	pub := NewMockPublisher()
+	detector := NewDetector(idx, books, reg, profit.NewTOBSimulator(1.05, 0), pub)
+
+	for _, market := range []types.Market{
+		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+	} {
+		idx.AddMarket(market)
+		reg.UpsertMarket(market)
+		reg.SetFee(market.Key(), types.Fee{TakerBp: 100})
+	}
+	books.Set(types.NewMarketKey("binance", "ETHUSDT"), types.TopOfBook{BidPx: 2999.0, AskPx: 3000.0})
+	books.Set(types.NewMarketKey("binance", "ETHBTC"), types.TopOfBook{BidPx: 0.0605, AskPx: 0.0606})
+	books.Set(types.NewMarketKey("binance", "BTCUSDT"), types.TopOfBook{BidPx: 50100.0, AskPx: 50110.0})
+
+	detector.OnMarketChange("binance", "ETHBTC", 1000.0)
+	if n := len(pub.GetPublishedPlans()); n != 0 {
+		t.Fatalf("Expected no plans before the reload, got %d", n)
+	}
+
+	// About 1% gross: only profitable with no fees and a lower edge
+	detector.Reconfigure(profit.NewTOBSimulator(1.0, 0), IndexCycles{Index: idx}, func(m types.Market) types.Fee {
+		if m.Symbol == "BTCUSDT" {
+			return types.Fee{MakerBp: 1}
+		}
+		return types.Fee{}
+	})
+	if fee, _ := reg.GetFee(types.NewMarketKey("binance", "BTCUSDT")); fee != (types.Fee{MakerBp: 1}) {
+		t.Errorf("Expected the new fee in the registry, got %+v", fee)
+	}
+	detector.OnMarketChange("binance", "ETHBTC", 1000.0)
+	if n := len(pub.GetPublishedPlans()); n != 1 {
+		t.Errorf("Expected one plan after the reload, got %d", n)
+	}
+}
+
 func TestMockPublisher(t *testing.T) {
 	pub := NewMockPublisher()
 
@@ -401,7 +699,7 @@ func TestMockPublisher(t *testing.T) {
 		t.Errorf("Expected 1 published plan, got %d", len(plans))
 	}
 
-	if plans[0] != plan {
+	if !reflect.DeepEqual(plans[0], plan) {
 		t.Error("Published plan should match original")
 	}
 }
@@ -458,8 +756,8 @@ func BenchmarkDetectorOnMarketChange(b *testing.B) {
 
 	idx.AddMarket(market)
 	reg.UpsertMarket(market)
-	reg.SetFee(market.Symbol, types.Fee{TakerBp: 0.1, MakerBp: 0.05})
-	books.Set("BTCUSDT", types.TopOfBook{BidPx: 50000.0, AskPx: 50010.0})
+	reg.SetFee(market.Key(), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+	books.Set(types.NewMarketKey("binance", "BTCUSDT"), types.TopOfBook{BidPx: 50000.0, AskPx: 50010.0})
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -486,17 +784,17 @@ func BenchmarkDetectorOnMarketChangeWithTriangles(b *testing.B) {
 	for _, market := range markets {
 		idx.AddMarket(market)
 		reg.UpsertMarket(market)
-		reg.SetFee(market.Symbol, types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+		reg.SetFee(market.Key(), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
 	}
 
-	tobData := map[string]types.TopOfBook{
-		"BTCUSDT": {BidPx: 50000.0, AskPx: 50010.0, BidSz: 2.1, AskSz: 1.8},
-		"ETHUSDT": {BidPx: 3000.0, AskPx: 3010.0, BidSz: 10.0, AskSz: 8.0},
-		"ETHBTC":  {BidPx: 0.06, AskPx: 0.0602, BidSz: 65.0, AskSz: 62.0},
+	tobData := map[types.MarketKey]types.TopOfBook{
+		types.NewMarketKey("binance", "BTCUSDT"): {BidPx: 50000.0, AskPx: 50010.0, BidSz: 2.1, AskSz: 1.8},
+		types.NewMarketKey("binance", "ETHUSDT"): {BidPx: 3000.0, AskPx: 3010.0, BidSz: 10.0, AskSz: 8.0},
+		types.NewMarketKey("binance", "ETHBTC"):  {BidPx: 0.06, AskPx: 0.0602, BidSz: 65.0, AskSz: 62.0},
 	}
 
-	for symbol, tob := range tobData {
-		books.Set(symbol, tob)
+	for key, tob := range tobData {
+		books.Set(key, tob)
 	}
 
 	b.ResetTimer()
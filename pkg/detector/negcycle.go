@@ -0,0 +1,281 @@
+package detector
+
+import (
+	"math"
+	"sync"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/bookstore"
+	"github.com/armagg/circular-arbitrage-finder/pkg/graph"
+	"github.com/armagg/circular-arbitrage-finder/pkg/profit"
+	"github.com/armagg/circular-arbitrage-finder/pkg/registry"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// negEpsilon keeps float noise on flat loops from reading as an edge.
+const negEpsilon = 1e-12
+
+// maxExcludedLoops bounds how many negative loops that miss the changed
+// market one search steps around before giving up.
+const maxExcludedLoops = 16
+
+// logEdge converts one asset into another through a market, or moves it to
+// another venue when market is -1. Its weight is -ln(rate), so a loop whose
+// weights sum below zero multiplies out above one.
+type logEdge struct {
+	from, to int
+	weight   float64
+	market   int
+	dir      int8
+	live     bool
+}
+
+// NegativeCycleSearch keeps a log-rate graph of every market and, when one
+// changes, runs SPFA from the far end of each of its two edges looking for a
+// way back. Any path that closes into a negative loop is a profitable cycle
+// of whatever length. Nodes are assets per exchange; in cross-exchange mode
+// an asset's nodes on different venues are linked by transfer edges priced
+// by Transfers, so a loop across venues pays for its moves.
+type NegativeCycleSearch struct {
+	Index      *graph.Index
+	Books      *bookstore.TopOfBookStore
+	Registry   *registry.MarketRegistry
+	SlippageBp float64
+	Transfers  *profit.Transfers // nil moves nothing between venues
+
+	mu       sync.Mutex
+	nodes    map[string]int
+	assets   []string
+	venues   []string
+	byAsset  map[string][]int
+	edges    []logEdge
+	byMarket [][2]int // buy and sell edge of each market
+	out      [][]int
+}
+
+func NewNegativeCycleSearch(idx *graph.Index, books *bookstore.TopOfBookStore, reg *registry.MarketRegistry, slippageBp float64) *NegativeCycleSearch {
+	return &NegativeCycleSearch{Index: idx, Books: books, Registry: reg, SlippageBp: slippageBp, nodes: make(map[string]int), byAsset: make(map[string][]int)}
+}
+
+// CyclesFor refreshes the edges of market mid from its current top of book
+// and returns the profitable cycles that run through it.
+func (s *NegativeCycleSearch) CyclesFor(mid int) []types.Cycle {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sync()
+	if mid < 0 || mid >= len(s.byMarket) {
+		return nil
+	}
+	s.refresh(mid)
+
+	var cycles []types.Cycle
+	for _, e := range s.byMarket[mid] {
+		if !s.edges[e].live {
+			continue
+		}
+		if path := s.search(e); path != nil {
+			cycles = append(cycles, s.toCycle(path))
+		}
+	}
+	return cycles
+}
+
+// sync adds nodes and edges for markets indexed since the last call. Later
+// book changes reach an edge through CyclesFor on its own market.
+func (s *NegativeCycleSearch) sync() {
+	markets := s.Index.MarketsSnapshot()
+	for mid := len(s.byMarket); mid < len(markets); mid++ {
+		m := markets[mid]
+		base, quote := s.node(m.Exchange, m.Base), s.node(m.Exchange, m.Quote)
+		buy := s.addEdge(logEdge{from: quote, to: base, market: mid, dir: +1})
+		sell := s.addEdge(logEdge{from: base, to: quote, market: mid, dir: -1})
+		s.byMarket = append(s.byMarket, [2]int{buy, sell})
+		s.refresh(mid)
+	}
+}
+
+func (s *NegativeCycleSearch) addEdge(e logEdge) int {
+	s.edges = append(s.edges, e)
+	s.out[e.from] = append(s.out[e.from], len(s.edges)-1)
+	return len(s.edges) - 1
+}
+
+// node returns the node of asset on exchange, linking a new one to the
+// asset's nodes on every other venue it can be moved to.
+func (s *NegativeCycleSearch) node(exchange, asset string) int {
+	key := exchange + "|" + asset
+	if id, ok := s.nodes[key]; ok {
+		return id
+	}
+	id := len(s.assets)
+	s.nodes[key] = id
+	s.assets = append(s.assets, asset)
+	s.venues = append(s.venues, exchange)
+	s.out = append(s.out, nil)
+	if s.Index.CrossExchange {
+		for _, other := range s.byAsset[asset] {
+			s.link(other, id)
+			s.link(id, other)
+		}
+	}
+	s.byAsset[asset] = append(s.byAsset[asset], id)
+	return id
+}
+
+// link adds the transfer edge from one venue's node to another's.
+func (s *NegativeCycleSearch) link(from, to int) {
+	rate, ok := s.Transfers.Rate(s.assets[from], s.venues[from], s.venues[to])
+	if !ok || rate <= 0 {
+		return
+	}
+	w := -math.Log(rate)
+	s.addEdge(logEdge{from: from, to: to, weight: w, market: -1, live: isFiniteWeight(w)})
+}
+
+func (s *NegativeCycleSearch) refresh(mid int) {
+	buy, sell := &s.edges[s.byMarket[mid][0]], &s.edges[s.byMarket[mid][1]]
+	m, _ := s.Index.Market(mid)
+	key := m.Key()
+	tob, ok := s.Books.Get(key)
+	if !ok || tob.BidPx <= 0 || tob.AskPx <= 0 {
+		buy.live, sell.live = false, false
+		return
+	}
+	fee, _ := s.Registry.GetFee(key)
+	feeMul := 1.0 - fee.TakerBp/10000.0
+	buy.weight = -math.Log(feeMul / (tob.AskPx * (1.0 + s.SlippageBp/10000.0)))
+	sell.weight = -math.Log(feeMul * tob.BidPx * (1.0 - s.SlippageBp/10000.0))
+	buy.live = isFiniteWeight(buy.weight)
+	sell.live = isFiniteWeight(sell.weight)
+}
+
+// search returns the edges of a negative loop through e, or nil. A negative
+// loop that misses e is left out of the graph for the rest of the search,
+// so one standing loop elsewhere cannot hide this one.
+func (s *NegativeCycleSearch) search(e int) []int {
+	excluded := make(map[int]bool)
+	for i := 0; i <= maxExcludedLoops; i++ {
+		path, other := s.spfa(e, excluded)
+		if other == nil {
+			return path
+		}
+		if len(other) == 0 {
+			return nil
+		}
+		for _, ei := range other {
+			excluded[ei] = true
+		}
+	}
+	return nil
+}
+
+// spfa runs SPFA from the head of edge e, skipping excluded edges. It
+// returns the loop back through e, or the edges of a negative loop without
+// e when it runs into one first.
+func (s *NegativeCycleSearch) spfa(e int, excluded map[int]bool) (path, other []int) {
+	start := s.edges[e]
+	n := len(s.assets)
+	dist := make([]float64, n)
+	pred := make([]int, n)
+	relaxed := make([]int, n)
+	queued := make([]bool, n)
+	for i := range dist {
+		dist[i] = math.Inf(1)
+		pred[i] = -1
+	}
+	dist[start.to] = 0
+	queue := []int{start.to}
+	queued[start.to] = true
+
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		queued[u] = false
+		for _, ei := range s.out[u] {
+			edge := s.edges[ei]
+			if !edge.live || ei == e || excluded[ei] || dist[u]+edge.weight >= dist[edge.to]-negEpsilon {
+				continue
+			}
+			dist[edge.to] = dist[u] + edge.weight
+			pred[edge.to] = ei
+			relaxed[edge.to]++
+			// A cheaper way back to the start, or a node relaxed once per
+			// node, means a negative loop without e
+			if edge.to == start.to || relaxed[edge.to] >= n {
+				return nil, s.loopAt(edge.to, pred)
+			}
+			if !queued[edge.to] {
+				queued[edge.to] = true
+				queue = append(queue, edge.to)
+			}
+		}
+	}
+
+	if math.IsInf(dist[start.from], 1) || dist[start.from]+start.weight >= -negEpsilon {
+		return nil, nil
+	}
+	path = []int{e}
+	for v := start.from; v != start.to; v = s.edges[pred[v]].from {
+		if pred[v] < 0 || len(path) > n {
+			return nil, nil
+		}
+		path = append(path, pred[v])
+	}
+	// path holds e followed by the way back in reverse; flip the way back.
+	for i, j := 1, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// loopAt follows pred back from v until it comes round, and returns the
+// edges of the loop it lands on.
+func (s *NegativeCycleSearch) loopAt(v int, pred []int) []int {
+	n := len(pred)
+	// n steps back are sure to be inside the loop
+	for i := 0; i < n; i++ {
+		if pred[v] < 0 {
+			return []int{}
+		}
+		v = s.edges[pred[v]].from
+	}
+	var loop []int
+	for u := v; len(loop) <= n; {
+		if pred[u] < 0 {
+			break
+		}
+		loop = append(loop, pred[u])
+		if u = s.edges[pred[u]].from; u == v {
+			break
+		}
+	}
+	return loop
+}
+
+// toCycle turns a loop of edges into a Cycle that starts by spending the
+// changed market's quote, the currency its trade amount is given in.
+// Transfer edges are left out; the simulator prices the moves between legs.
+func (s *NegativeCycleSearch) toCycle(path []int) types.Cycle {
+	first := 0
+	if s.edges[path[0]].dir < 0 {
+		// The sell edge ends in the quote, so start right after it.
+		first = 1 % len(path)
+	}
+	var c types.Cycle
+	for i := range path {
+		edge := s.edges[path[(first+i)%len(path)]]
+		if edge.market < 0 {
+			continue
+		}
+		if len(c.MarketIds) == 0 {
+			c.QuoteCcy = s.assets[edge.from]
+		}
+		c.MarketIds = append(c.MarketIds, edge.market)
+		c.Dirs = append(c.Dirs, edge.dir)
+	}
+	return c
+}
+
+func isFiniteWeight(w float64) bool {
+	return !math.IsNaN(w) && !math.IsInf(w, 0)
+}
@@ -0,0 +1,213 @@
+package detector
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/bookstore"
+	"github.com/armagg/circular-arbitrage-finder/pkg/graph"
+	"github.com/armagg/circular-arbitrage-finder/pkg/profit"
+	"github.com/armagg/circular-arbitrage-finder/pkg/registry"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// USDT -> BTC -> ETH -> USDC -> USDT: ETH costs 3000 USDT through BTC and is
+// bid at 3030 USDC, a loop the triangle index never sees.
+func setupNegCycleTest(ethUsdcBid float64) (*graph.Index, *bookstore.TopOfBookStore, *registry.MarketRegistry) {
+	idx := graph.NewIndex()
+	books := bookstore.NewTopOfBookStore()
+	reg := registry.NewMarketRegistry()
+
+	markets := []types.Market{
+		{Exchange: "BINANCE", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+		{Exchange: "BINANCE", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "BINANCE", Symbol: "ETHUSDC", Base: "ETH", Quote: "USDC"},
+		{Exchange: "BINANCE", Symbol: "USDCUSDT", Base: "USDC", Quote: "USDT"},
+	}
+	prices := map[string]types.TopOfBook{
+		"BTCUSDT":  {BidPx: 49990, AskPx: 50000, BidSz: 10, AskSz: 10},
+		"ETHBTC":   {BidPx: 0.0599, AskPx: 0.06, BidSz: 100, AskSz: 100},
+		"ETHUSDC":  {BidPx: ethUsdcBid, AskPx: ethUsdcBid + 1, BidSz: 10, AskSz: 10},
+		"USDCUSDT": {BidPx: 1.0, AskPx: 1.0001, BidSz: 1e6, AskSz: 1e6},
+	}
+	for _, m := range markets {
+		idx.AddMarket(m)
+		reg.UpsertMarket(m)
+		reg.SetFee(m.Key(), types.Fee{TakerBp: 1})
+		books.Set(m.Key(), prices[m.Symbol])
+	}
+	return idx, books, reg
+}
+
+func TestNegativeCycleSearchFindsLongCycle(t *testing.T) {
+	idx, books, reg := setupNegCycleTest(3030)
+	if len(idx.Cycles) != 0 {
+		t.Fatalf("Expected the triangle index to find nothing, got %d cycles", len(idx.Cycles))
+	}
+
+	search := NewNegativeCycleSearch(idx, books, reg, 0)
+	cycles := search.CyclesFor(idx.MarketIndexByKey[types.NewMarketKey("binance", "BTCUSDT")])
+	if len(cycles) != 1 {
+		t.Fatalf("Expected 1 cycle, got %d", len(cycles))
+	}
+
+	c := cycles[0]
+	expectedIds := []int{0, 1, 2, 3}
+	expectedDirs := []int8{1, 1, -1, -1}
+	for i := range expectedIds {
+		if c.MarketIds[i] != expectedIds[i] || c.Dirs[i] != expectedDirs[i] {
+			t.Fatalf("Expected markets %v dirs %v, got %v %v", expectedIds, expectedDirs, c.MarketIds, c.Dirs)
+		}
+	}
+	if c.QuoteCcy != "USDT" {
+		t.Errorf("Expected the cycle to start in USDT, got %s", c.QuoteCcy)
+	}
+}
+
+func TestNegativeCycleSearchStartsInChangedQuote(t *testing.T) {
+	idx, books, reg := setupNegCycleTest(3030)
+	search := NewNegativeCycleSearch(idx, books, reg, 0)
+
+	// ETHUSDC is sold in the loop, so the cycle starts with the USDC it paid out
+	cycles := search.CyclesFor(idx.MarketIndexByKey[types.NewMarketKey("binance", "ETHUSDC")])
+	if len(cycles) != 1 {
+		t.Fatalf("Expected 1 cycle, got %d", len(cycles))
+	}
+	c := cycles[0]
+	if c.QuoteCcy != "USDC" || idx.Markets[c.MarketIds[0]].Symbol != "USDCUSDT" || c.Dirs[0] != -1 {
+		t.Errorf("Expected the cycle to start by selling USDC, got %+v", c)
+	}
+	if idx.Markets[c.MarketIds[c.Len()-1]].Symbol != "ETHUSDC" {
+		t.Errorf("Expected the cycle to end on ETHUSDC, got %+v", c)
+	}
+}
+
+func TestNegativeCycleSearchNoArbitrage(t *testing.T) {
+	idx, books, reg := setupNegCycleTest(2995)
+	search := NewNegativeCycleSearch(idx, books, reg, 0)
+
+	for mid := range idx.Markets {
+		if cycles := search.CyclesFor(mid); len(cycles) != 0 {
+			t.Errorf("Market %d: expected no cycles, got %v", mid, cycles)
+		}
+	}
+	// A later quote on the changed market alone opens the loop
+	key := types.NewMarketKey("binance", "ETHUSDC")
+	books.Set(key, types.TopOfBook{BidPx: 3030, AskPx: 3031, BidSz: 10, AskSz: 10})
+	if cycles := search.CyclesFor(idx.MarketIndexByKey[key]); len(cycles) != 1 {
+		t.Errorf("Expected the updated book to open 1 cycle, got %d", len(cycles))
+	}
+}
+
+func TestNegativeCycleSearchMissingBook(t *testing.T) {
+	idx, _, reg := setupNegCycleTest(3030)
+	books := bookstore.NewTopOfBookStore()
+	search := NewNegativeCycleSearch(idx, books, reg, 0)
+
+	if cycles := search.CyclesFor(0); len(cycles) != 0 {
+		t.Errorf("Expected no cycles without books, got %v", cycles)
+	}
+	if cycles := search.CyclesFor(99); cycles != nil {
+		t.Errorf("Expected nil for an unknown market, got %v", cycles)
+	}
+}
+
+func TestNegativeCycleSearchSlippageClosesEdge(t *testing.T) {
+	idx, books, reg := setupNegCycleTest(3030)
+
+	// The loop is worth ~1%; 30bp per leg on four legs more than eats it
+	search := NewNegativeCycleSearch(idx, books, reg, 30)
+	if cycles := search.CyclesFor(0); len(cycles) != 0 {
+		t.Errorf("Expected slippage to remove the cycle, got %v", cycles)
+	}
+}
+
+func TestDetectorWithNegativeCycleSearch(t *testing.T) {
+	idx, books, reg := setupNegCycleTest(3030)
+	pub := NewMockPublisher()
+	det := NewDetector(idx, books, reg, profit.NewTOBSimulator(1.0, 0), pub)
+	det.Source = NewNegativeCycleSearch(idx, books, reg, 0)
+
+	det.OnMarketChange("binance", "BTCUSDT", 1000)
+
+	plans := pub.GetPublishedPlans()
+	if len(plans) != 1 {
+		t.Fatalf("Expected 1 published plan, got %d", len(plans))
+	}
+	if len(plans[0].Legs) != 4 {
+		t.Errorf("Expected a four-leg plan, got %d legs", len(plans[0].Legs))
+	}
+	// 1000 USDT -> 0.02 BTC -> 0.3333 ETH -> 1010 USDC -> 1010 USDT, less 1bp per leg
+	expected := 1000*math.Pow(1-1.0/10000, 4)*1.01 - 1000
+	if math.Abs(plans[0].ExpectedProfitQuote-expected) > 1e-6 {
+		t.Errorf("Expected profit %f, got %f", expected, plans[0].ExpectedProfitQuote)
+	}
+}
+
+func TestNegativeCycleSearchStepsAroundStandingLoop(t *testing.T) {
+	idx, books, reg := setupNegCycleTest(3030)
+	// EUR -> DOGE -> XRP -> EUR pays 10% on paper but lies off every loop
+	// through BTCUSDT, as a stuck or unfillable set of books would
+	for _, m := range []struct {
+		market types.Market
+		tob    types.TopOfBook
+	}{
+		{types.Market{Exchange: "BINANCE", Symbol: "EURUSDT", Base: "EUR", Quote: "USDT"}, types.TopOfBook{BidPx: 1.08, AskPx: 1.0801}},
+		{types.Market{Exchange: "BINANCE", Symbol: "DOGEEUR", Base: "DOGE", Quote: "EUR"}, types.TopOfBook{BidPx: 0.999, AskPx: 1.0}},
+		{types.Market{Exchange: "BINANCE", Symbol: "XRPDOGE", Base: "XRP", Quote: "DOGE"}, types.TopOfBook{BidPx: 0.999, AskPx: 1.0}},
+		{types.Market{Exchange: "BINANCE", Symbol: "XRPEUR", Base: "XRP", Quote: "EUR"}, types.TopOfBook{BidPx: 1.1, AskPx: 1.11}},
+	} {
+		idx.AddMarket(m.market)
+		reg.UpsertMarket(m.market)
+		books.Set(m.market.Key(), m.tob)
+	}
+
+	search := NewNegativeCycleSearch(idx, books, reg, 0)
+	cycles := search.CyclesFor(idx.MarketIndexByKey[types.NewMarketKey("binance", "BTCUSDT")])
+	if len(cycles) != 1 || cycles[0].Len() != 4 || cycles[0].MarketIds[0] != 0 {
+		t.Errorf("Expected the four-leg loop through BTCUSDT despite the standing one, got %v", cycles)
+	}
+}
+
+func TestNegativeCycleSearchPricesTransfers(t *testing.T) {
+	// Buying BTC on BINANCE and selling it on KUCOIN is worth 18bp before
+	// moving the BTC over and the USDT back
+	setup := func(transfers *profit.Transfers) []types.Cycle {
+		idx := graph.NewIndex()
+		idx.CrossExchange = true
+		books := bookstore.NewTopOfBookStore()
+		reg := registry.NewMarketRegistry()
+		for _, m := range []struct {
+			market types.Market
+			tob    types.TopOfBook
+		}{
+			{types.Market{Exchange: "BINANCE", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"}, types.TopOfBook{BidPx: 50000, AskPx: 50010}},
+			{types.Market{Exchange: "KUCOIN", Symbol: "BTC-USDT", Base: "BTC", Quote: "USDT"}, types.TopOfBook{BidPx: 50100, AskPx: 50110}},
+		} {
+			idx.AddMarket(m.market)
+			reg.UpsertMarket(m.market)
+			books.Set(m.market.Key(), m.tob)
+		}
+		search := NewNegativeCycleSearch(idx, books, reg, 0)
+		search.Transfers = transfers
+		return search.CyclesFor(0)
+	}
+
+	if cycles := setup(nil); len(cycles) != 0 {
+		t.Errorf("Expected nothing to move between venues without transfers, got %v", cycles)
+	}
+	costly := profit.NewTransfers()
+	costly.SetAsset("BTC", profit.TransferCost{Bp: 10})
+	costly.SetAsset("USDT", profit.TransferCost{Bp: 10})
+	if cycles := setup(costly); len(cycles) != 0 {
+		t.Errorf("Expected 20bp of transfers to eat the spread, got %v", cycles)
+	}
+	cheap := profit.NewTransfers()
+	cheap.SetAsset("BTC", profit.TransferCost{Bp: 1})
+	cheap.SetAsset("USDT", profit.TransferCost{Bp: 1})
+	cycles := setup(cheap)
+	if len(cycles) != 1 || !reflect.DeepEqual(cycles[0], types.Cycle{MarketIds: []int{0, 1}, Dirs: []int8{1, -1}, QuoteCcy: "USDT"}) {
+		t.Errorf("Expected to buy on BINANCE and sell on KUCOIN, got %+v", cycles)
+	}
+}
@@ -0,0 +1,196 @@
+package feedback
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/apiout"
+	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultThreshold = 3
+	defaultCoolDown  = 30 * time.Second
+	// logCycles is how many of the busiest cycles LogStats reports.
+	logCycles = 10
+)
+
+// Counts is how often the executor accepted and rejected plans.
+type Counts struct {
+	Accepted uint64
+	Rejected uint64
+}
+
+// AcceptanceRate is the share of replies that accepted the plan, 0 without replies.
+func (c Counts) AcceptanceRate() float64 {
+	if c.Accepted+c.Rejected == 0 {
+		return 0
+	}
+	return float64(c.Accepted) / float64(c.Accepted+c.Rejected)
+}
+
+// Stats is a copy of everything the tracker has counted.
+type Stats struct {
+	Cycles    map[string]Counts
+	Exchanges map[string]Counts
+	Reasons   map[string]uint64
+}
+
+// TopCycles returns the n cycles with the most replies, busiest first.
+func (s Stats) TopCycles(n int) []string {
+	keys := make([]string, 0, len(s.Cycles))
+	for k := range s.Cycles {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := s.Cycles[keys[i]], s.Cycles[keys[j]]
+		if a.Accepted+a.Rejected != b.Accepted+b.Rejected {
+			return a.Accepted+a.Rejected > b.Accepted+b.Rejected
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
+
+type cycleState struct {
+	Counts
+	streak    int // rejections since the last acceptance or cool-down
+	coolUntil time.Time
+}
+
+// Tracker records the executor's replies per cycle and exchange. A cycle the
+// executor rejects Threshold times in a row is not proposed again until
+// CoolDown has passed. A nil *Tracker records nothing and never cools down.
+type Tracker struct {
+	Threshold int
+	CoolDown  time.Duration
+	Now       func() time.Time
+
+	mu        sync.Mutex
+	cycles    map[string]*cycleState
+	exchanges map[string]*Counts
+	reasons   map[string]uint64
+}
+
+func NewTracker(threshold int, coolDown time.Duration) *Tracker {
+	if threshold <= 0 {
+		threshold = defaultThreshold
+	}
+	if coolDown <= 0 {
+		coolDown = defaultCoolDown
+	}
+	return &Tracker{
+		Threshold: threshold,
+		CoolDown:  coolDown,
+		Now:       time.Now,
+		cycles:    make(map[string]*cycleState),
+		exchanges: make(map[string]*Counts),
+		reasons:   make(map[string]uint64),
+	}
+}
+
+// Record counts the outcome of publishing plan. Only an *apiout.RejectedError
+// counts as a rejection; other errors never reached the executor's decision
+// and are ignored.
+func (t *Tracker) Record(plan types.Plan, err error) {
+	if t == nil {
+		return
+	}
+	var rejected *apiout.RejectedError
+	if err != nil && !errors.As(err, &rejected) {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := plan.Route()
+	c, ok := t.cycles[key]
+	if !ok {
+		c = &cycleState{}
+		t.cycles[key] = c
+	}
+	ex, ok := t.exchanges[strings.ToUpper(plan.Exchange)]
+	if !ok {
+		ex = &Counts{}
+		t.exchanges[strings.ToUpper(plan.Exchange)] = ex
+	}
+
+	if rejected == nil {
+		c.Accepted++
+		ex.Accepted++
+		c.streak = 0
+		return
+	}
+	c.Rejected++
+	ex.Rejected++
+	reason := rejected.Reason
+	if reason == "" {
+		reason = "unspecified"
+	}
+	t.reasons[reason]++
+	if c.streak++; c.streak >= t.Threshold {
+		c.coolUntil = t.Now().Add(t.CoolDown)
+		c.streak = 0
+	}
+}
+
+// CoolingDown reports whether plan's cycle is resting after repeated rejections.
+func (t *Tracker) CoolingDown(plan types.Plan) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.cycles[plan.Route()]
+	return ok && t.Now().Before(c.coolUntil)
+}
+
+func (t *Tracker) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := Stats{
+		Cycles:    make(map[string]Counts, len(t.cycles)),
+		Exchanges: make(map[string]Counts, len(t.exchanges)),
+		Reasons:   make(map[string]uint64, len(t.reasons)),
+	}
+	for k, v := range t.cycles {
+		s.Cycles[k] = v.Counts
+	}
+	for k, v := range t.exchanges {
+		s.Exchanges[k] = *v
+	}
+	for k, v := range t.reasons {
+		s.Reasons[k] = v
+	}
+	return s
+}
+
+// LogStats logs the acceptance rate per exchange and of the busiest cycles,
+// and the rejection reasons.
+func (t *Tracker) LogStats() {
+	s := t.Stats()
+	for ex, c := range s.Exchanges {
+		logger.Log.WithFields(logrus.Fields{"exchange": ex, "accepted": c.Accepted, "rejected": c.Rejected, "acceptance_rate": c.AcceptanceRate()}).Info("feedback: executor replies")
+	}
+	for _, cycle := range s.TopCycles(logCycles) {
+		c := s.Cycles[cycle]
+		logger.Log.WithFields(logrus.Fields{"cycle": cycle, "accepted": c.Accepted, "rejected": c.Rejected, "acceptance_rate": c.AcceptanceRate()}).Info("feedback: executor replies per cycle")
+	}
+	if len(s.Reasons) > 0 {
+		fields := make(logrus.Fields, len(s.Reasons))
+		for reason, n := range s.Reasons {
+			fields[reason] = n
+		}
+		logger.Log.WithFields(fields).Info("feedback: rejection reasons")
+	}
+}
@@ -0,0 +1,103 @@
+package feedback
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/apiout"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+func testPlan(exchange string, sides ...types.Side) types.Plan {
+	markets := []string{"ETHUSDT", "ETHBTC", "BTCUSDT"}
+	plan := types.Plan{Exchange: exchange}
+	for i, side := range sides {
+		plan.Legs = append(plan.Legs, types.Leg{Exchange: exchange, Market: markets[i], Side: side})
+	}
+	return plan
+}
+
+func TestTrackerCoolDown(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tracker := NewTracker(2, time.Minute)
+	tracker.Now = func() time.Time { return now }
+	plan := testPlan("binance", types.SideBuy, types.SideSell, types.SideSell)
+	other := testPlan("binance", types.SideSell, types.SideBuy, types.SideBuy)
+	rejected := &apiout.RejectedError{Reason: "insufficient balance"}
+
+	// An acceptance in between resets the streak
+	tracker.Record(plan, rejected)
+	tracker.Record(plan, nil)
+	tracker.Record(plan, rejected)
+	if tracker.CoolingDown(plan) {
+		t.Fatal("Expected no cool-down before two rejections in a row")
+	}
+
+	tracker.Record(plan, fmt.Errorf("publish: %w", rejected))
+	if !tracker.CoolingDown(plan) {
+		t.Fatal("Expected a cool-down after two rejections in a row")
+	}
+	if tracker.CoolingDown(other) {
+		t.Error("Expected other cycles to be unaffected")
+	}
+
+	now = now.Add(time.Minute + time.Second)
+	if tracker.CoolingDown(plan) {
+		t.Error("Expected the cool-down to expire")
+	}
+	tracker.Record(plan, rejected)
+	if tracker.CoolingDown(plan) {
+		t.Error("Expected the streak to restart after a cool-down")
+	}
+}
+
+func TestTrackerStats(t *testing.T) {
+	tracker := NewTracker(0, 0)
+	if tracker.Threshold != defaultThreshold || tracker.CoolDown != defaultCoolDown {
+		t.Errorf("Expected defaults, got %d %v", tracker.Threshold, tracker.CoolDown)
+	}
+
+	binance := testPlan("binance", types.SideBuy, types.SideSell, types.SideSell)
+	kucoin := testPlan("KUCOIN", types.SideBuy, types.SideSell, types.SideSell)
+	tracker.Record(binance, nil)
+	tracker.Record(binance, nil)
+	tracker.Record(binance, &apiout.RejectedError{Reason: "stale"})
+	tracker.Record(kucoin, &apiout.RejectedError{Reason: "stale"})
+	tracker.Record(kucoin, &apiout.RejectedError{})
+	// Transport failures never reached the executor
+	tracker.Record(kucoin, errors.New("connection refused"))
+
+	s := tracker.Stats()
+	if c := s.Exchanges["BINANCE"]; c.Accepted != 2 || c.Rejected != 1 {
+		t.Errorf("Unexpected BINANCE counts %+v", c)
+	}
+	if rate := s.Exchanges["BINANCE"].AcceptanceRate(); rate < 0.66 || rate > 0.67 {
+		t.Errorf("Expected a 2/3 acceptance rate, got %f", rate)
+	}
+	if c := s.Exchanges["KUCOIN"]; c.Accepted != 0 || c.Rejected != 2 {
+		t.Errorf("Unexpected KUCOIN counts %+v", c)
+	}
+	if c := s.Cycles[kucoin.Route()]; c.Rejected != 2 {
+		t.Errorf("Unexpected cycle counts %+v", c)
+	}
+	if top := s.TopCycles(1); len(top) != 1 || top[0] != binance.Route() {
+		t.Errorf("Expected the BINANCE cycle to be the busiest, got %v", top)
+	}
+	if top := s.TopCycles(5); len(top) != 2 || top[1] != kucoin.Route() {
+		t.Errorf("Expected both cycles, busiest first, got %v", top)
+	}
+	if s.Reasons["stale"] != 2 || s.Reasons["unspecified"] != 1 {
+		t.Errorf("Unexpected reasons %v", s.Reasons)
+	}
+	if (Counts{}).AcceptanceRate() != 0 {
+		t.Error("Expected a zero rate without replies")
+	}
+
+	var none *Tracker
+	none.Record(binance, nil)
+	if none.CoolingDown(binance) {
+		t.Error("Expected a nil tracker never to cool down")
+	}
+}
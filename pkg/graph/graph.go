@@ -1,8 +1,7 @@
 package graph
 
 import (
-	"fmt"
-	"strings"
+	"sort"
 	"sync"
 
 	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
@@ -12,123 +11,290 @@ import (
 )
 
 
+// Index holds every market and the cycles through them. AddMarket may run
+// while deltas are priced, so readers other than the goroutine adding
+// markets go through the locked accessors below rather than the fields.
 type Index struct {
 	Markets             []types.Market
-	MarketIndexBySymbol map[string]int
+	MarketIndexByKey    map[types.MarketKey]int
 	marketsByExchange   map[string]map[string]int
-	Triangles           []types.Triangle
-	TrianglesByMarket   map[int][]int
-	mu                  sync.Mutex
+	marketsByPair       map[string][]int
+	marketsByAsset      map[string][]int
+	Cycles              []types.Cycle
+	CyclesByMarket      map[int][]int
+	// CrossExchange also links markets on different venues that share an
+	// asset. Set it before the first AddMarket.
+	CrossExchange       bool
+	// MaxCycleLen is the longest cycle searched for, 3 when unset. Every
+	// extra leg multiplies the search by the degree of the assets involved.
+	MaxCycleLen         int
+	mu                  sync.RWMutex
 }
 
 func NewIndex() *Index {
 	return &Index{
 		Markets:             make([]types.Market, 0),
-		MarketIndexBySymbol: make(map[string]int),
+		MarketIndexByKey:    make(map[types.MarketKey]int),
 		marketsByExchange:   make(map[string]map[string]int),
-		Triangles:           make([]types.Triangle, 0),
-		TrianglesByMarket:   make(map[int][]int),
+		marketsByPair:       make(map[string][]int),
+		marketsByAsset:      make(map[string][]int),
+		Cycles:              make([]types.Cycle, 0),
+		CyclesByMarket:      make(map[int][]int),
 	}
 }
 
 
 
-func (idx *Index) AddMarket(m types.Market) (newTriangles []types.Triangle, isNew bool) {
+// Size counts the markets and cycles indexed so far.
+func (idx *Index) Size() (markets, cycles int) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.Markets), len(idx.Cycles)
+}
+
+// Lookup returns the id of the market with key.
+func (idx *Index) Lookup(key types.MarketKey) (int, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	mid, ok := idx.MarketIndexByKey[key]
+	return mid, ok
+}
+
+// Market returns the market with id mid.
+func (idx *Index) Market(mid int) (types.Market, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if mid < 0 || mid >= len(idx.Markets) {
+		return types.Market{}, false
+	}
+	return idx.Markets[mid], true
+}
+
+// MarketsSnapshot returns the markets indexed so far. Markets are only ever
+// appended, so the slice stays valid while more are added.
+func (idx *Index) MarketsSnapshot() []types.Market {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.Markets[:len(idx.Markets):len(idx.Markets)]
+}
+
+// CyclesFor returns the cycles that run through market mid.
+func (idx *Index) CyclesFor(mid int) []types.Cycle {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	ids := idx.CyclesByMarket[mid]
+	cycles := make([]types.Cycle, 0, len(ids))
+	for _, ci := range ids {
+		cycles = append(cycles, idx.Cycles[ci])
+	}
+	return cycles
+}
+
+// MarketKeys lists the keys of the markets indexed at position from onwards.
+// Markets are only ever appended, so a caller that remembers how many it has
+// seen gets just the new ones.
+func (idx *Index) MarketKeys(from int) []types.MarketKey {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	if from >= len(idx.Markets) {
+		return nil
+	}
+	res := make([]types.MarketKey, 0, len(idx.Markets)-from)
+	for _, m := range idx.Markets[from:] {
+		res = append(res, m.Key())
+	}
+	return res
+}
+
+// Exchanges lists the venues with at least one indexed market.
+func (idx *Index) Exchanges() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	res := make([]string, 0, len(idx.marketsByExchange))
+	for ex := range idx.marketsByExchange {
+		res = append(res, ex)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (idx *Index) AddMarket(m types.Market) (newCycles []types.Cycle, isNew bool) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
 
-	key := fmt.Sprintf("%s:%s", strings.ToUpper(m.Exchange), strings.ToUpper(m.Symbol))
-	if _, ok := idx.MarketIndexBySymbol[key]; ok {
+	key := m.Key()
+	if _, ok := idx.MarketIndexByKey[key]; ok {
 		return nil, false
 	}
 
 	marketID := len(idx.Markets)
 	idx.Markets = append(idx.Markets, m)
-	idx.MarketIndexBySymbol[key] = marketID
+	idx.MarketIndexByKey[key] = marketID
 
 	if _, ok := idx.marketsByExchange[m.Exchange]; !ok {
 		idx.marketsByExchange[m.Exchange] = make(map[string]int)
 	}
 	pairKey := m.Base + "/" + m.Quote
 	idx.marketsByExchange[m.Exchange][pairKey] = marketID
+	idx.marketsByPair[pairKey] = append(idx.marketsByPair[pairKey], marketID)
+	idx.marketsByAsset[m.Base] = append(idx.marketsByAsset[m.Base], marketID)
+	idx.marketsByAsset[m.Quote] = append(idx.marketsByAsset[m.Quote], marketID)
 
-	newTriangles = idx.findNewTriangles(m, marketID)
-	if len(newTriangles) > 0 {
-		for _, t := range newTriangles {
-			logger.Log.WithFields(logrus.Fields{
-				"market_ids": t.MarketIds,
-				"markets": []string{
-					idx.Markets[t.MarketIds[0]].Symbol,
-					idx.Markets[t.MarketIds[1]].Symbol,
-					idx.Markets[t.MarketIds[2]].Symbol,
-				},
-			}).Info("graph: found triangle")
-			idx.Triangles = append(idx.Triangles, t)
-			ti := len(idx.Triangles) - 1
-			for _, mid := range t.MarketIds {
-				idx.TrianglesByMarket[mid] = append(idx.TrianglesByMarket[mid], ti)
-			}
+	newCycles = idx.findNewCycles(m, marketID)
+	for _, c := range newCycles {
+		names := make([]string, 0, c.Len())
+		for _, mid := range c.MarketIds {
+			names = append(names, idx.Markets[mid].Key().String())
+		}
+		logger.Log.WithFields(logrus.Fields{
+			"market_ids": c.MarketIds,
+			"markets":    names,
+		}).Info("graph: found cycle")
+		idx.Cycles = append(idx.Cycles, c)
+		ci := len(idx.Cycles) - 1
+		for _, mid := range c.MarketIds {
+			idx.CyclesByMarket[mid] = append(idx.CyclesByMarket[mid], ci)
 		}
 	}
-	return newTriangles, true
+	return newCycles, true
 }
 
 
 
-func (idx *Index) findNewTriangles(m types.Market, mID int) []types.Triangle {
-	var triangles []types.Triangle
+// defaultMaxCycleLen keeps the search to triangles unless configured otherwise.
+const defaultMaxCycleLen = 3
 
-	byPair := idx.marketsByExchange[m.Exchange]
-	if byPair == nil {
-		return nil
-	}
-	marketsOnExchange := make([]types.Market, 0)
-	for _, id := range byPair {
-		marketsOnExchange = append(marketsOnExchange, idx.Markets[id])
+// cycleLeg is one hop of a cycle being built: trading market id turns from
+// into to.
+type cycleLeg struct {
+	id       int
+	from, to string
+}
+
+// findNewCycles returns every cycle of 2 to MaxCycleLen legs that goes
+// through the newly added market m, in both directions. It walks outward
+// from m's quote and closes the loop with a direct lookup back to m's base,
+// so each loop is found exactly once. Assets are never revisited within a
+// cycle. Two legs only close over another market for the same pair, such as
+// BTC/USDT on a second venue in cross-exchange mode.
+func (idx *Index) findNewCycles(m types.Market, mID int) []types.Cycle {
+	maxLen := idx.MaxCycleLen
+	if maxLen <= 0 {
+		maxLen = defaultMaxCycleLen
 	}
 
+	var cycles []types.Cycle
+	path := []cycleLeg{{id: mID, from: m.Base, to: m.Quote}}
+	visited := map[string]bool{m.Base: true, m.Quote: true}
 
-	a1, c1 := m.Base, m.Quote
-	for _, m2 := range marketsOnExchange {
-		if m2.Base == a1 && m2.Quote != c1 {
-			b2 := m2.Quote
-			if m3ID, ok := byPair[b2+"/"+c1]; ok {
-				m2ID := byPair[m2.Base+"/"+m2.Quote]
-				triangles = append(triangles, makeTriangle(mID, m2ID, m3ID, c1))
+	var extend func(asset string)
+	extend = func(asset string) {
+		for _, id := range idx.linking(m.Exchange, asset, m.Base) {
+			if id == mID {
+				continue
 			}
+			legs := append(path[:len(path):len(path)], cycleLeg{id: id, from: asset, to: m.Base})
+			both := orientCycle(idx.Markets, legs)
+			cycles = append(cycles, both[0], both[1])
 		}
-	}
-
-
-	a2, b2 := m.Base, m.Quote
-	for _, m1 := range marketsOnExchange {
-		if m1.Base == a2 && m1.Quote != b2 {
-			c1 := m1.Quote
-			if m3ID, ok := byPair[b2+"/"+c1]; ok {
-				m1ID := byPair[m1.Base+"/"+m1.Quote]
-				triangles = append(triangles, makeTriangle(m1ID, mID, m3ID, c1))
+		if len(path) >= maxLen-1 {
+			return
+		}
+		for _, id := range idx.marketsByAsset[asset] {
+			other := idx.Markets[id]
+			if !idx.CrossExchange && other.Exchange != m.Exchange {
+				continue
+			}
+			next := other.Base
+			if next == asset {
+				next = other.Quote
+			}
+			if visited[next] {
+				continue
 			}
+			visited[next] = true
+			path = append(path, cycleLeg{id: id, from: asset, to: next})
+			extend(next)
+			path = path[:len(path)-1]
+			visited[next] = false
 		}
 	}
+	extend(m.Quote)
+	return cycles
+}
+
+// linking returns the markets that trade a against b in either orientation,
+// on exchange only unless cross-exchange mode is on.
+func (idx *Index) linking(exchange, a, b string) []int {
+	if idx.CrossExchange {
+		return append(append([]int(nil), idx.marketsByPair[a+"/"+b]...), idx.marketsByPair[b+"/"+a]...)
+	}
+	var ids []int
+	byPair := idx.marketsByExchange[exchange]
+	if id, ok := byPair[a+"/"+b]; ok {
+		ids = append(ids, id)
+	}
+	if id, ok := byPair[b+"/"+a]; ok {
+		ids = append(ids, id)
+	}
+	return ids
+}
 
+// orientCycle returns both directions of a loop so each is evaluated with
+// its own start asset and plan. Each direction starts from the asset that is
+// the quote of the most markets in the loop (USDT in ETH/USDT, ETH/BTC,
+// BTC/USDT), with ties broken on the market ids, so the same markets always
+// produce the same cycles. The direction with more sells comes first.
+func orientCycle(markets []types.Market, legs []cycleLeg) [2]types.Cycle {
+	n := len(legs)
+	quoteDegree := make(map[string]int, n)
+	for _, l := range legs {
+		quoteDegree[markets[l.id].Quote]++
+	}
 
-	b3, c3 := m.Base, m.Quote
-	for _, m1 := range marketsOnExchange {
-		if m1.Quote == c3 && m1.Base != b3 {
-			a1 := m1.Base
-			if m2ID, ok := byPair[a1+"/"+b3]; ok {
-				m1ID := byPair[m1.Base+"/"+m1.Quote]
-				triangles = append(triangles, makeTriangle(m1ID, m2ID, mID, c3))
+	var res [2]types.Cycle
+	var sells [2]int
+	for d, reverse := range []bool{false, true} {
+		bestDegree := -1
+		for r := 0; r < n; r++ {
+			c := types.Cycle{MarketIds: make([]int, n), Dirs: make([]int8, n)}
+			s := 0
+			for i := 0; i < n; i++ {
+				var l cycleLeg
+				if reverse {
+					l = legs[((r-i)%n+n)%n]
+					l.from, l.to = l.to, l.from
+				} else {
+					l = legs[(r+i)%n]
+				}
+				c.MarketIds[i] = l.id
+				if markets[l.id].Base == l.to {
+					c.Dirs[i] = +1
+				} else {
+					c.Dirs[i] = -1
+					s++
+				}
+				if i == 0 {
+					c.QuoteCcy = l.from
+				}
+			}
+			degree := quoteDegree[c.QuoteCcy]
+			if degree > bestDegree || (degree == bestDegree && lessIds(c.MarketIds, res[d].MarketIds)) {
+				res[d], sells[d], bestDegree = c, s, degree
 			}
 		}
 	}
-	return triangles
+	if sells[1] > sells[0] || (sells[1] == sells[0] && lessIds(res[1].MarketIds, res[0].MarketIds)) {
+		res[0], res[1] = res[1], res[0]
+	}
+	return res
 }
 
-func makeTriangle(id1, id2, id3 int, quoteCcy string) types.Triangle {
-	return types.Triangle{
-		MarketIds: [3]int{id1, id2, id3},
-		Dirs:      [3]int8{+1, -1, -1},
-		QuoteCcy:  quoteCcy,
+func lessIds(a, b []int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
 	}
+	return false
 }
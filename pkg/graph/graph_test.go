@@ -1,7 +1,8 @@
 package graph
 
 import (
-	"strings"
+	"fmt"
+	"reflect"
 	"sync"
 	"testing"
 
@@ -23,30 +24,42 @@ func TestNewIndex(t *testing.T) {
 		t.Errorf("New index should have empty markets slice, got %d", len(idx.Markets))
 	}
 
-	if idx.MarketIndexBySymbol == nil {
-		t.Error("MarketIndexBySymbol map should be initialized")
+	if idx.MarketIndexByKey == nil {
+		t.Error("MarketIndexByKey map should be initialized")
 	}
 
-	if idx.Triangles == nil {
+	if idx.Cycles == nil {
 		t.Error("Triangles slice should be initialized")
 	}
 
-	if idx.TrianglesByMarket == nil {
-		t.Error("TrianglesByMarket map should be initialized")
+	if idx.CyclesByMarket == nil {
+		t.Error("CyclesByMarket map should be initialized")
 	}
 }
 
-func TestMakeTriangle(t *testing.T) {
-	triangle := makeTriangle(0, 1, 2, "USDT")
-
-	expected := types.Triangle{
-		MarketIds: [3]int{0, 1, 2},
-		Dirs:      [3]int8{1, -1, -1},
-		QuoteCcy:  "USDT",
+func TestOrientCycle(t *testing.T) {
+	markets := []types.Market{
+		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+	}
+	expected := [2]types.Cycle{
+		// USDT -> ETH -> BTC -> USDT
+		{MarketIds: []int{0, 1, 2}, Dirs: []int8{1, -1, -1}, QuoteCcy: "USDT"},
+		// USDT -> BTC -> ETH -> USDT
+		{MarketIds: []int{2, 1, 0}, Dirs: []int8{1, 1, -1}, QuoteCcy: "USDT"},
 	}
 
-	if triangle != expected {
-		t.Errorf("makeTriangle returned %+v, expected %+v", triangle, expected)
+	// Any rotation or direction of the loop gives the same pair of cycles
+	inputs := [][]cycleLeg{
+		{{0, "USDT", "ETH"}, {1, "ETH", "BTC"}, {2, "BTC", "USDT"}},
+		{{1, "ETH", "BTC"}, {2, "BTC", "USDT"}, {0, "USDT", "ETH"}},
+		{{2, "USDT", "BTC"}, {1, "BTC", "ETH"}, {0, "ETH", "USDT"}},
+	}
+	for i, legs := range inputs {
+		if c := orientCycle(markets, legs); !reflect.DeepEqual(c, expected) {
+			t.Errorf("Input %d: orientCycle returned %+v, expected %+v", i, c, expected)
+		}
 	}
 }
 
@@ -123,7 +136,7 @@ func TestIndexAddMarket(t *testing.T) {
 		t.Errorf("Expected 3 markets, got %d", len(idx.Markets))
 	}
 
-	if len(idx.Triangles) == 0 {
+	if len(idx.Cycles) == 0 {
 		t.Error("Triangles should be created")
 	}
 }
@@ -197,18 +210,18 @@ func TestIndexTriangleCreation(t *testing.T) {
 		}
 	}
 
-	if len(idx.Triangles) == 0 {
+	if len(idx.Cycles) == 0 {
 		t.Error("Triangles should be created")
 	}
 
 	// Verify triangles are indexed by market
-	for marketID, triangles := range idx.TrianglesByMarket {
+	for marketID, triangles := range idx.CyclesByMarket {
 		if len(triangles) == 0 {
 			t.Errorf("Market %d should have triangles", marketID)
 		}
 
 		for _, triangleID := range triangles {
-			if triangleID < 0 || triangleID >= len(idx.Triangles) {
+			if triangleID < 0 || triangleID >= len(idx.Cycles) {
 				t.Errorf("Invalid triangle ID %d for market %d", triangleID, marketID)
 			}
 		}
@@ -233,12 +246,12 @@ func TestIndexComplexTriangleFinding(t *testing.T) {
 	}
 
 	// Should find multiple triangles
-	if len(idx.Triangles) == 0 {
+	if len(idx.Cycles) == 0 {
 		t.Error("Should find triangles in complex graph")
 	}
 
-	t.Logf("Found %d triangles", len(idx.Triangles))
-	for i, triangle := range idx.Triangles {
+	t.Logf("Found %d triangles", len(idx.Cycles))
+	for i, triangle := range idx.Cycles {
 		t.Logf("Triangle %d: Markets [%d,%d,%d], Quote: %s",
 			i, triangle.MarketIds[0], triangle.MarketIds[1], triangle.MarketIds[2], triangle.QuoteCcy)
 	}
@@ -271,7 +284,7 @@ func TestIndexConcurrency(t *testing.T) {
 			for j := 0; j < numOperations; j++ {
 				// Test concurrent reads
 				_ = len(idx.Markets)
-				_ = len(idx.Triangles)
+				_ = len(idx.Cycles)
 			}
 		}()
 	}
@@ -297,9 +310,9 @@ func TestIndexMarketIndexing(t *testing.T) {
 	idx.AddMarket(market)
 
 	// Test case-insensitive lookup (but index stores uppercase)
-	key1 := "BINANCE:BTCUSDT"
+	key1 := types.NewMarketKey("binance", "btcusdt")
 
-	if _, exists := idx.MarketIndexBySymbol[key1]; !exists {
+	if _, exists := idx.MarketIndexByKey[key1]; !exists {
 		t.Errorf("Should find market with key %s", key1)
 	}
 }
@@ -315,7 +328,7 @@ func TestIndexFindNewTrianglesEdgeCases(t *testing.T) {
 		Quote:    "USDT",
 	}
 
-	triangles := idx.findNewTriangles(market, 0)
+	triangles := idx.findNewCycles(market, 0)
 
 	if len(triangles) != 0 {
 		t.Errorf("Should find no triangles with single market, got %d", len(triangles))
@@ -323,7 +336,7 @@ func TestIndexFindNewTrianglesEdgeCases(t *testing.T) {
 
 	// Add the market first
 	idx.Markets = append(idx.Markets, market)
-	idx.MarketIndexBySymbol["binance:btcusdt"] = 0
+	idx.MarketIndexByKey[market.Key()] = 0
 	idx.marketsByExchange["binance"] = make(map[string]int)
 	idx.marketsByExchange["binance"]["btc/usdt"] = 0
 
@@ -335,7 +348,7 @@ func TestIndexFindNewTrianglesEdgeCases(t *testing.T) {
 		Quote:    "USDT",
 	}
 
-	triangles2 := idx.findNewTriangles(newMarket, 1)
+	triangles2 := idx.findNewCycles(newMarket, 1)
 
 	if len(triangles2) != 0 {
 		t.Errorf("Should find no triangles with two markets, got %d", len(triangles2))
@@ -371,6 +384,180 @@ func TestIndexMultipleExchanges(t *testing.T) {
 	}
 }
 
+func TestIndexCrossExchangeTriangles(t *testing.T) {
+	markets := []types.Market{
+		{Exchange: "BINANCE", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+		{Exchange: "BINANCE", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+		{Exchange: "KUCOIN", Symbol: "ETH-BTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "KUCOIN", Symbol: "ETH-USDT", Base: "ETH", Quote: "USDT"},
+	}
+
+	// Single-venue mode never joins the KUCOIN ETH-BTC leg to BINANCE
+	idx := NewIndex()
+	for _, m := range markets {
+		idx.AddMarket(m)
+	}
+	if len(idx.Cycles) != 0 {
+		t.Errorf("Expected no triangles without cross-exchange mode, got %d", len(idx.Cycles))
+	}
+
+	idx = NewIndex()
+	idx.CrossExchange = true
+	for _, m := range markets {
+		idx.AddMarket(m)
+	}
+
+	// ETH/USDT on either venue, ETH/BTC on KUCOIN, BTC/USDT on BINANCE, each
+	// in both directions, plus ETH/USDT bought on one venue and sold on the
+	// other
+	if len(idx.Cycles) != 6 {
+		t.Fatalf("Expected 4 cross-exchange triangles and 2 two-leg loops, got %d", len(idx.Cycles))
+	}
+	seen := make(map[string]bool)
+	for _, tri := range idx.Cycles {
+		if tri.Len() == 2 {
+			continue
+		}
+		key := fmt.Sprint(tri.MarketIds, tri.Dirs)
+		if seen[key] {
+			t.Errorf("Triangle %+v found twice", tri)
+		}
+		seen[key] = true
+		if idx.Markets[tri.MarketIds[1]].Exchange != "KUCOIN" {
+			t.Errorf("Expected ETH-BTC as the middle leg of %+v", tri)
+		}
+	}
+	if len(idx.CyclesByMarket[2]) != 4 {
+		t.Errorf("Expected ETH-BTC in every triangle, got %d", len(idx.CyclesByMarket[2]))
+	}
+}
+
+func TestIndexCrossExchangePairs(t *testing.T) {
+	markets := []types.Market{
+		{Exchange: "BINANCE", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+		{Exchange: "KUCOIN", Symbol: "BTC-USDT", Base: "BTC", Quote: "USDT"},
+	}
+
+	idx := NewIndex()
+	for _, m := range markets {
+		idx.AddMarket(m)
+	}
+	if len(idx.Cycles) != 0 {
+		t.Errorf("Expected no loops without cross-exchange mode, got %d", len(idx.Cycles))
+	}
+
+	idx = NewIndex()
+	idx.CrossExchange = true
+	for _, m := range markets {
+		idx.AddMarket(m)
+	}
+	// Buy BTC on one venue and sell it on the other, either way round
+	expected := []types.Cycle{
+		{MarketIds: []int{0, 1}, Dirs: []int8{1, -1}, QuoteCcy: "USDT"},
+		{MarketIds: []int{1, 0}, Dirs: []int8{1, -1}, QuoteCcy: "USDT"},
+	}
+	if !reflect.DeepEqual(idx.Cycles, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, idx.Cycles)
+	}
+	if len(idx.CyclesByMarket[0]) != 2 || len(idx.CyclesByMarket[1]) != 2 {
+		t.Errorf("Expected both loops indexed under both markets, got %v", idx.CyclesByMarket)
+	}
+}
+
+func TestIndexLongerCycles(t *testing.T) {
+	// USDT -> BTC -> ETH -> USDC -> USDT needs four hops; the ETH/BTC and
+	// BTC/USDT pairs with ETH/USDT also close one triangle.
+	markets := []types.Market{
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "binance", Symbol: "ETHUSDC", Base: "ETH", Quote: "USDC"},
+		{Exchange: "binance", Symbol: "USDCUSDT", Base: "USDC", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+	}
+
+	tests := []struct {
+		name     string
+		maxLen   int
+		expected map[int]int // cycle length -> count
+	}{
+		{"Default is triangles only", 0, map[int]int{3: 4}},
+		{"Two legs only pairs the same market twice", 2, map[int]int{}},
+		{"Four legs", 4, map[int]int{3: 4, 4: 2}},
+		{"Five legs finds nothing longer here", 5, map[int]int{3: 4, 4: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := NewIndex()
+			idx.MaxCycleLen = tt.maxLen
+			for _, m := range markets {
+				idx.AddMarket(m)
+			}
+
+			got := make(map[int]int)
+			seen := make(map[string]bool)
+			for _, c := range idx.Cycles {
+				got[c.Len()]++
+				key := fmt.Sprint(c.MarketIds, c.Dirs)
+				if seen[key] {
+					t.Errorf("Cycle %v %v found twice", c.MarketIds, c.Dirs)
+				}
+				seen[key] = true
+				if len(c.Dirs) != c.Len() {
+					t.Errorf("Cycle %v has %d directions", c.MarketIds, len(c.Dirs))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Expected cycles by length %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestIndexFourLegCycleShape(t *testing.T) {
+	idx := NewIndex()
+	idx.MaxCycleLen = 4
+	for _, m := range []types.Market{
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "binance", Symbol: "ETHUSDC", Base: "ETH", Quote: "USDC"},
+		{Exchange: "binance", Symbol: "USDCUSDT", Base: "USDC", Quote: "USDT"},
+	} {
+		idx.AddMarket(m)
+	}
+
+	if len(idx.Cycles) != 2 {
+		t.Fatalf("Expected the loop in both directions, got %d cycles", len(idx.Cycles))
+	}
+	for _, c := range idx.Cycles {
+		if c.QuoteCcy != "USDT" {
+			t.Errorf("Expected the cycle to start in USDT, got %s", c.QuoteCcy)
+		}
+
+		// Walking the legs must hand each asset to the next market and end in USDT
+		asset := c.QuoteCcy
+		for i, mid := range c.MarketIds {
+			m := idx.Markets[mid]
+			switch {
+			case c.Dirs[i] > 0 && m.Quote == asset:
+				asset = m.Base
+			case c.Dirs[i] < 0 && m.Base == asset:
+				asset = m.Quote
+			default:
+				t.Fatalf("Leg %d on %s cannot spend %s", i, m.Symbol, asset)
+			}
+		}
+		if asset != "USDT" {
+			t.Errorf("Expected the cycle to end in USDT, got %s", asset)
+		}
+	}
+	for mid := range idx.Markets {
+		if len(idx.CyclesByMarket[mid]) != 2 {
+			t.Errorf("Market %d should be indexed to both directions", mid)
+		}
+	}
+}
+
 func TestIndexSnapshotConsistency(t *testing.T) {
 	idx := NewIndex()
 
@@ -391,9 +578,9 @@ func TestIndexSnapshotConsistency(t *testing.T) {
 			t.Errorf("Market %d has empty symbol", i)
 		}
 
-		// Verify reverse indexing (index uses uppercase)
-		key := strings.ToUpper(market.Exchange) + ":" + strings.ToUpper(market.Symbol)
-		if marketID, exists := idx.MarketIndexBySymbol[key]; !exists {
+		// Verify reverse indexing (index uses normalized keys)
+		key := market.Key()
+		if marketID, exists := idx.MarketIndexByKey[key]; !exists {
 			t.Errorf("Market %s not found in index", key)
 		} else if marketID != i {
 			t.Errorf("Market %s has wrong ID: expected %d, got %d", key, i, marketID)
@@ -401,7 +588,7 @@ func TestIndexSnapshotConsistency(t *testing.T) {
 	}
 
 	// Verify triangle consistency
-	for triangleID, triangle := range idx.Triangles {
+	for triangleID, triangle := range idx.Cycles {
 		for _, marketID := range triangle.MarketIds {
 			if marketID < 0 || marketID >= len(idx.Markets) {
 				t.Errorf("Triangle %d has invalid market ID %d", triangleID, marketID)
@@ -411,7 +598,7 @@ func TestIndexSnapshotConsistency(t *testing.T) {
 		// Verify reverse indexing
 		for _, marketID := range triangle.MarketIds {
 			found := false
-			for _, triID := range idx.TrianglesByMarket[marketID] {
+			for _, triID := range idx.CyclesByMarket[marketID] {
 				if triID == triangleID {
 					found = true
 					break
@@ -441,7 +628,7 @@ func BenchmarkIndexAddMarket(b *testing.B) {
 	}
 }
 
-func BenchmarkIndexFindNewTriangles(b *testing.B) {
+func BenchmarkIndexFindNewCycles(b *testing.B) {
 	idx := NewIndex()
 
 	// Set up some existing markets
@@ -453,11 +640,13 @@ func BenchmarkIndexFindNewTriangles(b *testing.B) {
 			Quote:    "USDT",
 		}
 		idx.Markets = append(idx.Markets, market)
-		idx.MarketIndexBySymbol[market.Exchange+":"+market.Symbol] = i
+		idx.MarketIndexByKey[market.Key()] = i
 		if idx.marketsByExchange[market.Exchange] == nil {
 			idx.marketsByExchange[market.Exchange] = make(map[string]int)
 		}
 		idx.marketsByExchange[market.Exchange][market.Base+"/"+market.Quote] = i
+		idx.marketsByAsset[market.Base] = append(idx.marketsByAsset[market.Base], i)
+		idx.marketsByAsset[market.Quote] = append(idx.marketsByAsset[market.Quote], i)
 	}
 
 	newMarket := types.Market{
@@ -469,6 +658,6 @@ func BenchmarkIndexFindNewTriangles(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		idx.findNewTriangles(newMarket, len(idx.Markets))
+		idx.findNewCycles(newMarket, len(idx.Markets))
 	}
 }
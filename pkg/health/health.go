@@ -0,0 +1,108 @@
+// Package health decides whether the finder is ready to trade and reports
+// it over HTTP and grpc.health.v1.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/graph"
+
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+var errShuttingDown = errors.New("shutting down")
+
+// Readiness is ready once markets are indexed and every expected exchange
+// sent a delta within MaxFeedAge. Exchanges lists the expected ones; when it
+// is empty, every exchange that has sent a delta is, so markets listed only
+// in an instrument file do not hold readiness back. A zero MaxFeedAge only
+// waits for the first delta of each exchange.
+type Readiness struct {
+	Index      *graph.Index
+	LastDelta  func() map[string]time.Time
+	MaxFeedAge time.Duration
+	Exchanges  []string
+	Now        func() time.Time
+
+	shuttingDown atomic.Bool
+}
+
+func NewReadiness(idx *graph.Index, lastDelta func() map[string]time.Time, maxFeedAge time.Duration) *Readiness {
+	return &Readiness{Index: idx, LastDelta: lastDelta, MaxFeedAge: maxFeedAge, Now: time.Now}
+}
+
+// Check returns nil when ready, or why not.
+func (r *Readiness) Check() error {
+	if r.shuttingDown.Load() {
+		return errShuttingDown
+	}
+	if len(r.Index.Exchanges()) == 0 {
+		return errors.New("no markets loaded")
+	}
+	last := r.LastDelta()
+	exchanges := r.Exchanges
+	if len(exchanges) == 0 {
+		for ex := range last {
+			exchanges = append(exchanges, ex)
+		}
+		if len(exchanges) == 0 {
+			return errors.New("no deltas received")
+		}
+		sort.Strings(exchanges)
+	}
+	now := r.Now()
+	var stale []string
+	for _, ex := range exchanges {
+		at, ok := last[strings.ToUpper(ex)]
+		if !ok || (r.MaxFeedAge > 0 && now.Sub(at) > r.MaxFeedAge) {
+			stale = append(stale, ex)
+		}
+	}
+	if len(stale) > 0 {
+		return fmt.Errorf("no recent deltas from %s", strings.Join(stale, ", "))
+	}
+	return nil
+}
+
+// ShutDown makes every later Check fail, so load balancers drain the
+// finder before it stops.
+func (r *Readiness) ShutDown() {
+	r.shuttingDown.Store(true)
+}
+
+// Handler answers 200 when ready and 503 with the reason otherwise.
+func (r *Readiness) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if err := r.Check(); err != nil {
+			http.Error(w, "not ready: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ready\n"))
+	})
+}
+
+// Watch keeps the overall status of hs in step with Check until ctx ends.
+func (r *Readiness) Watch(ctx context.Context, hs *grpchealth.Server, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status := healthpb.HealthCheckResponse_SERVING
+		if r.Check() != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		hs.SetServingStatus("", status)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/graph"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestReadinessCheck(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	idx := graph.NewIndex()
+	last := map[string]time.Time{}
+	r := NewReadiness(idx, func() map[string]time.Time { return last }, 10*time.Second)
+	r.Now = func() time.Time { return now }
+
+	if err := r.Check(); err == nil || !strings.Contains(err.Error(), "no markets") {
+		t.Errorf("Expected not ready without markets, got %v", err)
+	}
+
+	idx.AddMarket(types.Market{Exchange: "BINANCE", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"})
+	idx.AddMarket(types.Market{Exchange: "KUCOIN", Symbol: "ETH-USDT", Base: "ETH", Quote: "USDT"})
+	if err := r.Check(); err == nil || !strings.Contains(err.Error(), "no deltas") {
+		t.Errorf("Expected not ready before any delta, got %v", err)
+	}
+	last["BINANCE"] = now.Add(-time.Second)
+	if err := r.Check(); err != nil {
+		t.Errorf("Expected an exchange never fed not to block readiness, got %v", err)
+	}
+
+	r.Exchanges = []string{"BINANCE", "KUCOIN"}
+	if err := r.Check(); err == nil || !strings.Contains(err.Error(), "KUCOIN") {
+		t.Errorf("Expected a silent expected exchange to block readiness, got %v", err)
+	}
+	last["KUCOIN"] = now
+	if err := r.Check(); err != nil {
+		t.Errorf("Expected ready, got %v", err)
+	}
+
+	now = now.Add(10 * time.Second)
+	if err := r.Check(); err == nil || !strings.Contains(err.Error(), "BINANCE") || strings.Contains(err.Error(), "KUCOIN") {
+		t.Errorf("Expected only BINANCE to be stale, got %v", err)
+	}
+
+	r.MaxFeedAge = 0
+	if err := r.Check(); err != nil {
+		t.Errorf("Expected no age limit, got %v", err)
+	}
+	r.ShutDown()
+	if err := r.Check(); err != errShuttingDown {
+		t.Errorf("Expected not ready while shutting down, got %v", err)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	idx := graph.NewIndex()
+	last := map[string]time.Time{}
+	r := NewReadiness(idx, func() map[string]time.Time { return last }, 0)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "no markets") {
+		t.Errorf("Expected 503, got %d %q", rec.Code, rec.Body.String())
+	}
+
+	idx.AddMarket(types.Market{Exchange: "BINANCE", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"})
+	last["BINANCE"] = time.Now()
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestReadinessWatch(t *testing.T) {
+	idx := graph.NewIndex()
+	r := NewReadiness(idx, func() map[string]time.Time { return map[string]time.Time{"BINANCE": time.Now()} }, 0)
+	hs := grpchealth.NewServer()
+	status := func() healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := hs.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.Status
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { r.Watch(ctx, hs, time.Millisecond); close(done) }()
+	waitStatus := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		deadline := time.Now().Add(time.Second)
+		for status() != want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := status(); got != want {
+			t.Errorf("Expected %v, got %v", want, got)
+		}
+	}
+
+	waitStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	idx.AddMarket(types.Market{Exchange: "BINANCE", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"})
+	waitStatus(healthpb.HealthCheckResponse_SERVING)
+	cancel()
+	<-done
+}
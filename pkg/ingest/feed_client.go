@@ -0,0 +1,153 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+	mdpb "github.com/armagg/circular-arbitrage-finder/proto/md"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	defaultMinBackoff          = 500 * time.Millisecond
+	defaultMaxBackoff          = 30 * time.Second
+	defaultResubscribeInterval = time.Second
+)
+
+// FeedClient subscribes to an OrderBookFeed server and runs what it streams
+// through the same books and detector as the push ingress. Every
+// (re)connect subscribes again, which makes the server start each market
+// with a snapshot; a sequence gap re-subscribes just that market. Without
+// Markets, the index is checked every ResubscribeInterval and markets added
+// to it since are subscribed on the open stream.
+type FeedClient struct {
+	Addr                string
+	Markets             []types.MarketKey // nil subscribes to every indexed market
+	Ingress             *GRPCServer
+	MinBackoff          time.Duration
+	MaxBackoff          time.Duration
+	ResubscribeInterval time.Duration
+}
+
+func NewFeedClient(addr string, markets []types.MarketKey, ingress *GRPCServer) *FeedClient {
+	return &FeedClient{Addr: addr, Markets: markets, Ingress: ingress, MinBackoff: defaultMinBackoff, MaxBackoff: defaultMaxBackoff, ResubscribeInterval: defaultResubscribeInterval}
+}
+
+// Run streams books until ctx is done, reconnecting with exponential backoff.
+// The backoff resets once a stream has delivered a message.
+func (c *FeedClient) Run(ctx context.Context) error {
+	conn, err := grpc.NewClient(c.Addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return fmt.Errorf("failed to dial feed %s: %w", c.Addr, err)
+	}
+	defer conn.Close()
+	client := mdpb.NewOrderBookFeedClient(conn)
+
+	backoff := c.MinBackoff
+	for {
+		received, err := c.stream(ctx, client)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if received {
+			backoff = c.MinBackoff
+		}
+		logger.Log.WithFields(logrus.Fields{"feed": c.Addr, "error": err, "retry_in": backoff}).Warn("ingest: feed stream ended, reconnecting")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > c.MaxBackoff {
+			backoff = c.MaxBackoff
+		}
+	}
+}
+
+func (c *FeedClient) stream(ctx context.Context, client mdpb.OrderBookFeedClient) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.StreamBooks(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to open book stream: %w", err)
+	}
+	markets, seen := c.subscription(0)
+	if err := stream.Send(&mdpb.StreamRequest{Markets: markets}); err != nil {
+		return false, fmt.Errorf("failed to subscribe: %w", err)
+	}
+	logger.Log.WithFields(logrus.Fields{"feed": c.Addr, "markets": len(markets)}).Info("ingest: subscribed to feed")
+
+	// Sends come from both loops below, and a grpc stream takes one at a time
+	var sendMu sync.Mutex
+	send := func(req *mdpb.StreamRequest) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(req)
+	}
+	if c.Markets == nil && c.ResubscribeInterval > 0 {
+		go c.watchIndex(ctx, cancel, seen, send)
+	}
+
+	received := false
+	for {
+		d, err := stream.Recv()
+		if err != nil {
+			return received, fmt.Errorf("failed to receive book: %w", err)
+		}
+		received = true
+		if key, gap := c.Ingress.handleDelta(d); gap {
+			resync := &mdpb.MarketId{Exchange: key.Exchange, Symbol: key.Symbol}
+			if err := send(&mdpb.StreamRequest{Markets: []*mdpb.MarketId{resync}}); err != nil {
+				return received, fmt.Errorf("failed to request resync: %w", err)
+			}
+		}
+	}
+}
+
+// watchIndex subscribes to markets indexed after the first seen ones until
+// ctx ends. A failed send cancels the stream, so it reconnects.
+func (c *FeedClient) watchIndex(ctx context.Context, cancel context.CancelFunc, seen int, send func(*mdpb.StreamRequest) error) {
+	ticker := time.NewTicker(c.ResubscribeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		markets, next := c.subscription(seen)
+		if len(markets) == 0 {
+			continue
+		}
+		if err := send(&mdpb.StreamRequest{Markets: markets}); err != nil {
+			logger.Log.WithFields(logrus.Fields{"feed": c.Addr, "error": err}).Warn("ingest: failed to subscribe to new markets")
+			cancel()
+			return
+		}
+		seen = next
+		logger.Log.WithFields(logrus.Fields{"feed": c.Addr, "markets": len(markets)}).Info("ingest: subscribed to new markets")
+	}
+}
+
+// subscription lists the markets to subscribe to: Markets, or the indexed
+// markets from position from onwards. It also returns how many indexed
+// markets that covers.
+func (c *FeedClient) subscription(from int) ([]*mdpb.MarketId, int) {
+	keys := c.Markets
+	if keys == nil {
+		keys = c.Ingress.Detector.Index.MarketKeys(from)
+		from += len(keys)
+	}
+	res := make([]*mdpb.MarketId, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, &mdpb.MarketId{Exchange: key.Exchange, Symbol: key.Symbol})
+	}
+	return res, from
+}
@@ -0,0 +1,196 @@
+package ingest
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+	mdpb "github.com/armagg/circular-arbitrage-finder/proto/md"
+	"google.golang.org/grpc"
+)
+
+// fakeFeed serves one scripted session per connection. Each session gets the
+// subscription requests the client sends on it.
+type fakeFeed struct {
+	mdpb.UnimplementedOrderBookFeedServer
+	sessions chan func(mdpb.OrderBookFeed_StreamBooksServer) error
+}
+
+func (f *fakeFeed) StreamBooks(stream mdpb.OrderBookFeed_StreamBooksServer) error {
+	session := <-f.sessions
+	return session(stream)
+}
+
+func startFakeFeed(t *testing.T) (*fakeFeed, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	feed := &fakeFeed{sessions: make(chan func(mdpb.OrderBookFeed_StreamBooksServer) error, 4)}
+	srv := grpc.NewServer()
+	mdpb.RegisterOrderBookFeedServer(srv, feed)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return feed, lis.Addr().String()
+}
+
+func requestedMarkets(req *mdpb.StreamRequest) []string {
+	var res []string
+	for _, m := range req.GetMarkets() {
+		res = append(res, m.GetExchange()+":"+m.GetSymbol())
+	}
+	return res
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFeedClientResyncAndReconnect(t *testing.T) {
+	feed, addr := startFakeFeed(t)
+	srv := newTestServer()
+	key := types.NewMarketKey("BINANCE", "BTCUSDT")
+	requests := make(chan []string, 8)
+
+	// First session: a gap that is resynced on the same stream, then a drop
+	feed.sessions <- func(stream mdpb.OrderBookFeed_StreamBooksServer) error {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		requests <- requestedMarkets(req)
+		for _, d := range []*mdpb.OrderBookDelta{
+			testDelta(1, true, 49990, 50010),
+			testDelta(2, false, 49995, 50005),
+			testDelta(4, false, 49000, 51000),
+		} {
+			if err := stream.Send(d); err != nil {
+				return err
+			}
+		}
+		req, err = stream.Recv()
+		if err != nil {
+			return err
+		}
+		requests <- requestedMarkets(req)
+		return stream.Send(testDelta(10, true, 49980, 50020))
+	}
+	// Second session: subscribed again from scratch
+	done := make(chan struct{})
+	feed.sessions <- func(stream mdpb.OrderBookFeed_StreamBooksServer) error {
+		req, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		requests <- requestedMarkets(req)
+		if err := stream.Send(testDelta(20, true, 49970, 50030)); err != nil {
+			return err
+		}
+		<-done
+		return nil
+	}
+	defer close(done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := NewFeedClient(addr, []types.MarketKey{key}, srv)
+	client.MinBackoff, client.MaxBackoff = time.Millisecond, 10*time.Millisecond
+	go client.Run(ctx)
+
+	expected := [][]string{{"BINANCE:BTCUSDT"}, {"BINANCE:BTCUSDT"}, {"BINANCE:BTCUSDT"}}
+	for i, want := range expected {
+		select {
+		case got := <-requests:
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("Request %d: expected %v, got %v", i, want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for request %d", i)
+		}
+	}
+
+	waitFor(t, "the second session's snapshot", func() bool {
+		book, ok := srv.OBStore.Get(key)
+		return ok && book.Seq == 20
+	})
+	if tob, _ := srv.TOBStore.Get(key); tob.BidPx != 49970 || tob.AskPx != 50030 {
+		t.Errorf("Expected the latest snapshot in the top of book, got %+v", tob)
+	}
+
+	cancel()
+}
+
+func TestFeedClientSubscribesToIndex(t *testing.T) {
+	srv := newTestServer()
+	for _, m := range []types.Market{
+		{Exchange: "BINANCE", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+		{Exchange: "KUCOIN", Symbol: "ETH-USDT", Base: "ETH", Quote: "USDT"},
+	} {
+		srv.Detector.Index.AddMarket(m)
+	}
+
+	client := NewFeedClient("unused:0", nil, srv)
+	markets, seen := client.subscription(0)
+	got := requestedMarkets(&mdpb.StreamRequest{Markets: markets})
+	expected := []string{"BINANCE:ETHUSDT", "KUCOIN:ETH-USDT"}
+	if !reflect.DeepEqual(got, expected) || seen != 2 {
+		t.Errorf("Expected subscription %v covering 2 markets, got %v and %d", expected, got, seen)
+	}
+	if markets, seen := client.subscription(seen); len(markets) != 0 || seen != 2 {
+		t.Errorf("Expected nothing new, got %v and %d", markets, seen)
+	}
+}
+
+func TestFeedClientSubscribesToNewMarkets(t *testing.T) {
+	feed, addr := startFakeFeed(t)
+	srv := newTestServer()
+	srv.Detector.Index.AddMarket(types.Market{Exchange: "BINANCE", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"})
+	requests := make(chan []string, 8)
+	feed.sessions <- func(stream mdpb.OrderBookFeed_StreamBooksServer) error {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				return err
+			}
+			requests <- requestedMarkets(req)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := NewFeedClient(addr, nil, srv)
+	client.ResubscribeInterval = time.Millisecond
+	go client.Run(ctx)
+
+	next := func() []string {
+		t.Helper()
+		select {
+		case got := <-requests:
+			return got
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a request")
+			return nil
+		}
+	}
+	if got := next(); !reflect.DeepEqual(got, []string{"BINANCE:ETHUSDT"}) {
+		t.Errorf("Expected the indexed market, got %v", got)
+	}
+	srv.Detector.Index.AddMarket(types.Market{Exchange: "BINANCE", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"})
+	if got := next(); !reflect.DeepEqual(got, []string{"BINANCE:BTCUSDT"}) {
+		t.Errorf("Expected only the new market, got %v", got)
+	}
+	select {
+	case got := <-requests:
+		t.Errorf("Expected no request while the index is unchanged, got %v", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
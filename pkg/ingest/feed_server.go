@@ -0,0 +1,240 @@
+package ingest
+
+import (
+	"io"
+	"sync"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/bookstore"
+	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+	mdpb "github.com/armagg/circular-arbitrage-finder/proto/md"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// feedBuffer is how many updates a subscriber may fall behind before it is
+// dropped and has to resubscribe.
+const feedBuffer = 1024
+
+// FeedServer re-publishes the books the ingress applies over OrderBookFeed.
+// A client sends StreamRequest markets and gets a snapshot of each, then a
+// delta for every change. Sending a market again asks for a new snapshot.
+// A book that goes stale upstream is sent as a stale message, and a snapshot
+// follows once the book is back. Sequence numbers are the server's own and have no gaps per market, however
+// the upstream feeds number theirs.
+type FeedServer struct {
+	mdpb.UnimplementedOrderBookFeedServer
+	Books *bookstore.OrderBookStore
+
+	mu   sync.Mutex
+	last map[types.MarketKey]types.OrderBook
+	seq  map[types.MarketKey]uint64
+	subs map[*feedSub]struct{}
+}
+
+type feedSub struct {
+	out     chan *mdpb.OrderBookDelta
+	synced  map[types.MarketKey]bool // false until the market's snapshot was sent
+	dropped chan struct{}
+}
+
+func NewFeedServer(books *bookstore.OrderBookStore) *FeedServer {
+	return &FeedServer{
+		Books: books,
+		last:  make(map[types.MarketKey]types.OrderBook),
+		seq:   make(map[types.MarketKey]uint64),
+		subs:  make(map[*feedSub]struct{}),
+	}
+}
+
+func (f *FeedServer) StreamBooks(stream mdpb.OrderBookFeed_StreamBooksServer) error {
+	sub := &feedSub{out: make(chan *mdpb.OrderBookDelta, feedBuffer), synced: make(map[types.MarketKey]bool), dropped: make(chan struct{})}
+	f.mu.Lock()
+	f.subs[sub] = struct{}{}
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.subs, sub)
+		f.mu.Unlock()
+	}()
+
+	// A client that half-closes after subscribing keeps its feed; only a
+	// broken stream or its context ends it
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			f.subscribe(sub, req)
+		}
+	}()
+
+	for {
+		select {
+		case d := <-sub.out:
+			if err := stream.Send(d); err != nil {
+				return err
+			}
+		case <-sub.dropped:
+			return status.Error(codes.ResourceExhausted, "subscriber fell behind, resubscribe")
+		case err := <-errc:
+			return err
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+	}
+}
+
+// Publish sends subscribers of key what changed since the last book
+// published for it. A subscriber that has not had a snapshot of the market
+// yet gets the whole book instead.
+func (f *FeedServer) Publish(key types.MarketKey, book types.OrderBook) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.subscribed(key) {
+		// A later subscriber is served from Books
+		delete(f.last, key)
+		return
+	}
+	prev := f.last[key]
+	bids, asks := diffLevels(prev.Bids, book.Bids), diffLevels(prev.Asks, book.Asks)
+	if len(bids) == 0 && len(asks) == 0 {
+		return
+	}
+	f.last[key] = book
+	f.seq[key]++
+	delta := &mdpb.OrderBookDelta{
+		Market:   &mdpb.MarketId{Exchange: key.Exchange, Symbol: key.Symbol},
+		Sequence: f.seq[key],
+		TsNs:     uint64(book.TsNs),
+		Bids:     bids,
+		Asks:     asks,
+	}
+	for sub := range f.subs {
+		synced, ok := sub.synced[key]
+		switch {
+		case !ok:
+			continue
+		case synced:
+			f.send(sub, delta)
+		default:
+			f.send(sub, f.snapshot(key))
+			sub.synced[key] = true
+		}
+	}
+}
+
+// MarkStale tells subscribers of key that its book is gone until the next
+// snapshot, which they then get as soon as the market is published again.
+func (f *FeedServer) MarkStale(key types.MarketKey) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.last, key)
+	if !f.subscribed(key) {
+		return
+	}
+	f.seq[key]++
+	stale := &mdpb.OrderBookDelta{
+		Market:   &mdpb.MarketId{Exchange: key.Exchange, Symbol: key.Symbol},
+		Sequence: f.seq[key],
+		Stale:    true,
+	}
+	for sub := range f.subs {
+		if synced := sub.synced[key]; synced {
+			f.send(sub, stale)
+			sub.synced[key] = false
+		}
+	}
+}
+
+// subscribed reports whether any subscriber asked for key. f.mu must be held.
+func (f *FeedServer) subscribed(key types.MarketKey) bool {
+	for sub := range f.subs {
+		if _, ok := sub.synced[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *FeedServer) subscribe(sub *feedSub, req *mdpb.StreamRequest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, m := range req.GetMarkets() {
+		key := types.NewMarketKey(m.GetExchange(), m.GetSymbol())
+		if _, ok := f.last[key]; !ok {
+			// Books applied before anything was published are still served.
+			if book, ok := f.Books.Get(key); ok {
+				f.last[key] = book
+			}
+		}
+		if _, ok := f.last[key]; !ok {
+			sub.synced[key] = false
+			continue
+		}
+		f.send(sub, f.snapshot(key))
+		sub.synced[key] = true
+	}
+}
+
+func (f *FeedServer) snapshot(key types.MarketKey) *mdpb.OrderBookDelta {
+	book := f.last[key]
+	return &mdpb.OrderBookDelta{
+		Market:     &mdpb.MarketId{Exchange: key.Exchange, Symbol: key.Symbol},
+		Sequence:   f.seq[key],
+		TsNs:       uint64(book.TsNs),
+		Bids:       diffLevels(nil, book.Bids),
+		Asks:       diffLevels(nil, book.Asks),
+		IsSnapshot: true,
+	}
+}
+
+// send never blocks the ingress. A subscriber whose buffer is full is
+// dropped rather than handed a book with updates missing.
+func (f *FeedServer) send(sub *feedSub, d *mdpb.OrderBookDelta) {
+	select {
+	case <-sub.dropped:
+	case sub.out <- d:
+	default:
+		close(sub.dropped)
+		logger.Log.WithFields(logrus.Fields{"market": d.GetMarket().GetExchange() + ":" + d.GetMarket().GetSymbol()}).Warn("ingest: dropped slow feed subscriber")
+	}
+}
+
+// diffLevels returns the levels that turn side a into side b: every level of
+// b that is new or changed, and a zero quantity for each price b no longer has.
+func diffLevels(a, b []types.Level) []*mdpb.Level {
+	old := make(map[float64]float64, len(a))
+	for _, l := range a {
+		old[l.Price] = l.Qty
+	}
+	var res []*mdpb.Level
+	for _, l := range b {
+		if qty, ok := old[l.Price]; !ok || qty != l.Qty {
+			res = append(res, &mdpb.Level{Price: l.Price, Qty: l.Qty})
+		}
+		delete(old, l.Price)
+	}
+	for _, l := range a {
+		if _, ok := old[l.Price]; ok {
+			res = append(res, &mdpb.Level{Price: l.Price, Qty: 0})
+		}
+	}
+	return res
+}
@@ -0,0 +1,215 @@
+package ingest
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+	mdpb "github.com/armagg/circular-arbitrage-finder/proto/md"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestDiffLevels(t *testing.T) {
+	a := []types.Level{{Price: 100, Qty: 1}, {Price: 99, Qty: 2}, {Price: 98, Qty: 3}}
+	b := []types.Level{{Price: 101, Qty: 0.5}, {Price: 100, Qty: 1}, {Price: 99, Qty: 2.5}}
+
+	expected := []*mdpb.Level{{Price: 101, Qty: 0.5}, {Price: 99, Qty: 2.5}, {Price: 98, Qty: 0}}
+	got := diffLevels(a, b)
+	if len(got) != len(expected) {
+		t.Fatalf("Expected %d levels, got %v", len(expected), got)
+	}
+	for i := range expected {
+		if got[i].Price != expected[i].Price || got[i].Qty != expected[i].Qty {
+			t.Errorf("Level %d: expected %v, got %v", i, expected[i], got[i])
+		}
+	}
+	if got := diffLevels(a, a); len(got) != 0 {
+		t.Errorf("Expected no levels for an unchanged side, got %v", got)
+	}
+}
+
+// The finder re-publishes its books to a second finder, whose books must end
+// up identical after snapshots, deltas, depth trimming and a gap.
+func TestFeedServerMirrorsBooks(t *testing.T) {
+	upstream := newTestServer()
+	upstream.Config().Strategy.OrderbookDepth = 2
+	upstream.Feed = NewFeedServer(upstream.OBStore)
+	key := types.NewMarketKey("BINANCE", "BTCUSDT")
+
+	// A book that exists before anyone subscribes is served from the store
+	upstream.handleDelta(testDelta(1, true, 49990, 50010))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	mdpb.RegisterOrderBookFeedServer(grpcServer, upstream.Feed)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	mirror := newTestServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := NewFeedClient(lis.Addr().String(), []types.MarketKey{key}, mirror)
+	client.MinBackoff, client.MaxBackoff = time.Millisecond, 10*time.Millisecond
+	go client.Run(ctx)
+
+	synced := func() bool {
+		want, _ := upstream.OBStore.Get(key)
+		got, ok := mirror.OBStore.Get(key)
+		return ok && reflect.DeepEqual(got.Bids, want.Bids) && reflect.DeepEqual(got.Asks, want.Asks)
+	}
+	waitFor(t, "the initial snapshot", synced)
+
+	for _, d := range []*mdpb.OrderBookDelta{
+		{Market: &mdpb.MarketId{Exchange: "binance", Symbol: "BTCUSDT"}, Sequence: 2, Bids: []*mdpb.Level{{Price: 49995, Qty: 2}, {Price: 49980, Qty: 1}}},
+		{Market: &mdpb.MarketId{Exchange: "binance", Symbol: "BTCUSDT"}, Sequence: 3, Asks: []*mdpb.Level{{Price: 50010, Qty: 0}, {Price: 50020, Qty: 3}, {Price: 50030, Qty: 1}}},
+		{Market: &mdpb.MarketId{Exchange: "binance", Symbol: "BTCUSDT"}, Sequence: 4, Bids: []*mdpb.Level{{Price: 49995, Qty: 0.5}}},
+	} {
+		upstream.handleDelta(d)
+	}
+	waitFor(t, "the deltas", func() bool {
+		book, _ := mirror.OBStore.Get(key)
+		return synced() && len(book.Asks) == 2
+	})
+	book, _ := mirror.OBStore.Get(key)
+	if !reflect.DeepEqual(book.Bids, []types.Level{{Price: 49995, Qty: 0.5}, {Price: 49990, Qty: 1}}) {
+		t.Errorf("Expected the mirror to hold the trimmed bids, got %v", book.Bids)
+	}
+
+	// An upstream gap drops the mirror's book until the next snapshot
+	upstream.handleDelta(testDelta(9, false, 1, 2))
+	waitFor(t, "the stale book to clear", func() bool {
+		_, ok := mirror.OBStore.Get(key)
+		return !ok
+	})
+	if _, ok := mirror.TOBStore.Get(key); ok {
+		t.Error("Expected a stale mirrored book to leave no top of book")
+	}
+	if stale := mirror.Seq.Stale(); len(stale) != 1 || stale[0] != key {
+		t.Errorf("Expected the mirror to wait for a snapshot of %v, got %v", key, stale)
+	}
+	upstream.handleDelta(testDelta(10, true, 49000, 51000))
+	waitFor(t, "the resync snapshot", synced)
+
+	if stale := mirror.Seq.Stale(); len(stale) != 0 {
+		t.Errorf("Expected the snapshot to end the wait, got %v", stale)
+	}
+}
+
+func TestFeedServerSnapshotAfterSubscribe(t *testing.T) {
+	feed := NewFeedServer(newTestServer().OBStore)
+	key := types.NewMarketKey("BINANCE", "BTCUSDT")
+	sub := &feedSub{out: make(chan *mdpb.OrderBookDelta, 4), synced: make(map[types.MarketKey]bool), dropped: make(chan struct{})}
+	feed.subs[sub] = struct{}{}
+
+	// Subscribed before the market has a book: the first update is a snapshot
+	feed.subscribe(sub, &mdpb.StreamRequest{Markets: []*mdpb.MarketId{{Exchange: "binance", Symbol: "btcusdt"}}})
+	feed.Publish(key, types.OrderBook{Bids: []types.Level{{Price: 100, Qty: 1}}, Asks: []types.Level{{Price: 101, Qty: 1}}})
+	feed.Publish(key, types.OrderBook{Bids: []types.Level{{Price: 100, Qty: 2}}, Asks: []types.Level{{Price: 101, Qty: 1}}})
+	feed.Publish(types.NewMarketKey("BINANCE", "ETHUSDT"), types.OrderBook{Bids: []types.Level{{Price: 1, Qty: 1}}})
+
+	first, second := <-sub.out, <-sub.out
+	if !first.IsSnapshot || first.Sequence != 1 || len(first.Bids) != 1 || len(first.Asks) != 1 {
+		t.Errorf("Expected a full snapshot at sequence 1, got %v", first)
+	}
+	if second.IsSnapshot || second.Sequence != 2 || len(second.Bids) != 1 || second.Bids[0].Qty != 2 || len(second.Asks) != 0 {
+		t.Errorf("Expected a one-level delta at sequence 2, got %v", second)
+	}
+	if len(sub.out) != 0 {
+		t.Errorf("Expected nothing for unsubscribed markets, got %d updates", len(sub.out))
+	}
+}
+
+func TestFeedServerStaleThenSnapshot(t *testing.T) {
+	feed := NewFeedServer(newTestServer().OBStore)
+	key := types.NewMarketKey("BINANCE", "BTCUSDT")
+	book := types.OrderBook{Bids: []types.Level{{Price: 100, Qty: 1}}, Asks: []types.Level{{Price: 101, Qty: 1}}}
+
+	// Nobody subscribed: nothing is diffed or kept
+	feed.Publish(key, book)
+	feed.MarkStale(key)
+	if len(feed.last) != 0 || feed.seq[key] != 0 {
+		t.Errorf("Expected no state without subscribers, got %v %v", feed.last, feed.seq)
+	}
+
+	sub := &feedSub{out: make(chan *mdpb.OrderBookDelta, 4), synced: map[types.MarketKey]bool{key: true}, dropped: make(chan struct{})}
+	feed.subs[sub] = struct{}{}
+	feed.Publish(key, book)
+	feed.MarkStale(key)
+	feed.Publish(key, book)
+
+	_, stale, snapshot := <-sub.out, <-sub.out, <-sub.out
+	if !stale.Stale || stale.IsSnapshot || len(stale.Bids) != 0 || len(stale.Asks) != 0 || stale.Sequence != 2 {
+		t.Errorf("Expected a stale message without levels at sequence 2, got %v", stale)
+	}
+	if !snapshot.IsSnapshot || snapshot.Stale || len(snapshot.Bids) != 1 || snapshot.Sequence != 3 {
+		t.Errorf("Expected a snapshot at sequence 3 once the book is back, got %v", snapshot)
+	}
+}
+
+func TestFeedServerKeepsStreamingAfterCloseSend(t *testing.T) {
+	srv := newTestServer()
+	srv.Feed = NewFeedServer(srv.OBStore)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	grpcServer := grpc.NewServer()
+	mdpb.RegisterOrderBookFeedServer(grpcServer, srv.Feed)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := mdpb.NewOrderBookFeedClient(conn).StreamBooks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&mdpb.StreamRequest{Markets: []*mdpb.MarketId{{Exchange: "BINANCE", Symbol: "BTCUSDT"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Published after the half-close, so the subscription must outlive it
+	time.Sleep(20 * time.Millisecond)
+	srv.handleDelta(testDelta(1, true, 49990, 50010))
+	srv.handleDelta(testDelta(2, false, 49995, 50005))
+	for _, snapshot := range []bool{true, false} {
+		d, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Expected the feed to continue after CloseSend, got %v", err)
+		}
+		if d.IsSnapshot != snapshot {
+			t.Errorf("Expected snapshot=%v, got %v", snapshot, d)
+		}
+	}
+}
+
+func TestFeedServerDropsSlowSubscriber(t *testing.T) {
+	feed := NewFeedServer(newTestServer().OBStore)
+	key := types.NewMarketKey("BINANCE", "BTCUSDT")
+	sub := &feedSub{out: make(chan *mdpb.OrderBookDelta, 1), synced: map[types.MarketKey]bool{key: true}, dropped: make(chan struct{})}
+	feed.subs[sub] = struct{}{}
+
+	for i := 1; i <= 3; i++ {
+		feed.Publish(key, types.OrderBook{Bids: []types.Level{{Price: 100, Qty: float64(i)}}})
+	}
+	select {
+	case <-sub.dropped:
+	default:
+		t.Error("Expected a subscriber with a full buffer to be dropped")
+	}
+}
@@ -5,72 +5,174 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/armagg/circular-arbitrage-finder/pkg/bookstore"
+	"github.com/armagg/circular-arbitrage-finder/pkg/capture"
 	"github.com/armagg/circular-arbitrage-finder/pkg/config"
 	"github.com/armagg/circular-arbitrage-finder/pkg/detector"
 	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
 	"github.com/armagg/circular-arbitrage-finder/pkg/types"
 	mdpb "github.com/armagg/circular-arbitrage-finder/proto/md"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
+// shutdownGrace is how long Serve lets open streams finish before cutting
+// them off. Ingress streams never end on their own.
+const shutdownGrace = 5 * time.Second
+
+var deltasReceived = promauto.NewCounterVec(prometheus.CounterOpts{Name: "arb_ingest_deltas_total", Help: "Order book deltas received, per exchange."}, []string{"exchange"})
+
 type GRPCServer struct {
 	mdpb.UnimplementedOrderBookIngressServer
 	TOBStore   *bookstore.TopOfBookStore
 	OBStore    *bookstore.OrderBookStore
 	Detector   *detector.Detector
-	Config     *config.Config
+	Seq        *SequenceTracker
+	Feed       *FeedServer // re-publishes applied books when set
+	Recorder   *capture.Recorder // writes every received delta when set
+	Health     *grpchealth.Server // served as grpc.health.v1 when set
+
+	cfg      atomic.Pointer[config.Config]
+	lastRecv sync.Map // exchange -> *atomic.Int64, Unix ns of the last delta
 }
 
 func NewGRPCServer(tobs *bookstore.TopOfBookStore, det *detector.Detector, cfg *config.Config, obs *bookstore.OrderBookStore) *GRPCServer {
-	return &GRPCServer{TOBStore: tobs, OBStore: obs, Detector: det, Config: cfg}
+	s := &GRPCServer{TOBStore: tobs, OBStore: obs, Detector: det, Seq: NewSequenceTracker()}
+	s.cfg.Store(cfg)
+	return s
+}
+
+// Config returns the config deltas are handled with.
+func (s *GRPCServer) Config() *config.Config {
+	return s.cfg.Load()
 }
 
+// SetConfig swaps the config for every later delta, such as after a reload.
+func (s *GRPCServer) SetConfig(cfg *config.Config) {
+	s.cfg.Store(cfg)
+}
+
+// PushDeltas applies deltas until the pusher closes the stream. A gap only
+// stops the market it hit, and the Ack lists the markets of this stream still
+// waiting for a snapshot. The Ack is the only way to tell the pusher, so a
+// pusher that keeps one stream open should close and reopen it on a timer.
 func (s *GRPCServer) PushDeltas(stream mdpb.OrderBookIngress_PushDeltasServer) error {
+	pushed := make(map[types.MarketKey]struct{})
 	for {
 		d, err := stream.Recv()
 		if err != nil {
-			return stream.SendAndClose(&mdpb.Ack{Ok: false})
-		}
-		exchange := strings.ToUpper(d.GetMarket().GetExchange())
-		symbol := strings.ToUpper(d.GetMarket().GetSymbol())
-
-		key := fmt.Sprintf("%s:%s", exchange, symbol)
-		if _, ok := s.Detector.Index.MarketIndexBySymbol[key]; !ok {
-			market, err := s.Config.ParseMarket(exchange, symbol)
-			if err != nil {
-				logger.Log.WithFields(logrus.Fields{"exchange": exchange, "symbol": symbol, "error": err}).Warn("ingest: failed to parse new market")
-				continue
-			}
-			if _, isNew := s.Detector.Index.AddMarket(market); isNew {
-				s.Detector.Registry.UpsertMarket(market)
-				s.Detector.Registry.SetFee(symbol, s.Config.GetFee(exchange, market.Quote))
-				logger.Log.WithFields(logrus.Fields{"exchange": exchange, "symbol": symbol}).Info("ingest: discovered and added new market")
-			}
+			resync := s.resyncMarkets(pushed)
+			return stream.SendAndClose(&mdpb.Ack{Ok: len(resync) == 0, Resync: resync})
 		}
+		key, _ := s.handleDelta(d)
+		pushed[key] = struct{}{}
+	}
+}
 
-		var bids []types.Level
-		var asks []types.Level
-		if len(d.Bids) > 0 {
-			b := d.Bids[0]
-			bids = append(bids, types.Level{Price: b.Price, Qty: b.Qty})
-		}
-		if len(d.Asks) > 0 {
-			a := d.Asks[0]
-			asks = append(asks, types.Level{Price: a.Price, Qty: a.Qty})
+// Apply handles a delta that did not arrive on a stream, such as one
+// replayed from a capture file.
+func (s *GRPCServer) Apply(d *mdpb.OrderBookDelta) {
+	s.handleDelta(d)
+}
+
+// handleDelta applies one feed message to the books and runs the detector
+// on the market. It returns the market the message was for and whether it
+// opened a sequence gap, in which case the feed should send a fresh snapshot
+// of the market.
+func (s *GRPCServer) handleDelta(d *mdpb.OrderBookDelta) (types.MarketKey, bool) {
+	if err := s.Recorder.Record(d); err != nil {
+		logger.Log.WithField("error", err).Warn("ingest: failed to record delta")
+	}
+	exchange := strings.ToUpper(d.GetMarket().GetExchange())
+	symbol := strings.ToUpper(d.GetMarket().GetSymbol())
+	deltasReceived.WithLabelValues(exchange).Inc()
+	s.markReceived(exchange)
+
+	cfg := s.Config()
+	key := types.NewMarketKey(exchange, symbol)
+	if _, ok := s.Detector.Index.Lookup(key); !ok {
+		market, err := cfg.ParseMarket(exchange, symbol)
+		if err != nil {
+			logger.Log.WithFields(logrus.Fields{"exchange": exchange, "symbol": symbol, "error": err}).Warn("ingest: failed to parse new market")
+			return key, false
 		}
-		// Depth-aware store
-		s.OBStore.Upsert(symbol, toLevels(d.Bids), toLevels(d.Asks), d.Sequence, int64(d.TsNs), s.Config.Strategy.OrderbookDepth)
-		// Maintain legacy TOB for detector/simulator compatibility
-		if len(bids) > 0 && len(asks) > 0 {
-			s.TOBStore.Set(symbol, types.TopOfBook{BidPx: bids[0].Price, BidSz: bids[0].Qty, AskPx: asks[0].Price, AskSz: asks[0].Qty, Seq: d.Sequence, TsNs: int64(d.TsNs)})
-			if s.Detector != nil {
-				s.Detector.OnMarketChange(exchange, symbol, s.pickTradeAmount(symbol))
+		if _, isNew := s.Detector.Index.AddMarket(market); isNew {
+			s.Detector.Registry.UpsertMarket(market)
+			s.Detector.Registry.SetFee(key, cfg.GetFee(exchange, market.Quote))
+			// A reload since cfg was read may have re-priced every market
+			// before this one was registered
+			if next := s.Config(); next != cfg {
+				s.Detector.Registry.SetFee(key, next.GetFee(exchange, market.Quote))
 			}
+			logger.Log.WithFields(logrus.Fields{"exchange": exchange, "symbol": symbol}).Info("ingest: discovered and added new market")
 		}
 	}
+
+	if d.GetStale() {
+		// The feed lost the book itself and sends a snapshot once it has one
+		s.Seq.MarkStale(key)
+		s.dropBook(key)
+		logger.Log.WithFields(logrus.Fields{"market": key.String()}).Warn("ingest: feed marked book stale, waiting for snapshot")
+		return key, false
+	}
+	switch status := s.Seq.Check(key, d.Sequence, d.GetIsSnapshot()); status {
+	case SeqDuplicate, SeqStale:
+		logger.Log.WithFields(logrus.Fields{"market": key.String(), "sequence": d.Sequence, "status": status}).Debug("ingest: dropped delta")
+		return key, false
+	case SeqGap:
+		s.dropBook(key)
+		logger.Log.WithFields(logrus.Fields{"market": key.String(), "sequence": d.Sequence}).Warn("ingest: sequence gap, book stale until next snapshot")
+		return key, true
+	}
+
+	// Snapshots replace the book, deltas patch it level by level
+	book := s.OBStore.ApplyDelta(key, toLevels(d.Bids), toLevels(d.Asks), d.GetIsSnapshot(), d.Sequence, int64(d.TsNs), cfg.Strategy.OrderbookDepth)
+	s.Feed.Publish(key, book)
+	// Maintain legacy TOB for detector/simulator compatibility
+	if len(book.Bids) == 0 || len(book.Asks) == 0 {
+		// A delta can empty a side; the old top of book no longer exists
+		s.TOBStore.Delete(key)
+	} else {
+		s.TOBStore.Set(key, types.TopOfBook{BidPx: book.Bids[0].Price, BidSz: book.Bids[0].Qty, AskPx: book.Asks[0].Price, AskSz: book.Asks[0].Qty, Seq: d.Sequence, TsNs: int64(d.TsNs)})
+		if s.Detector != nil {
+			s.Detector.OnMarketChange(exchange, symbol, pickTradeAmount(cfg, symbol))
+		}
+	}
+	return key, false
+}
+
+// dropBook forgets key's book until its next snapshot. Trading on a book
+// with a hole in it is worse than not trading.
+func (s *GRPCServer) dropBook(key types.MarketKey) {
+	s.TOBStore.Delete(key)
+	s.OBStore.Delete(key)
+	s.Feed.MarkStale(key)
+}
+
+func (s *GRPCServer) markReceived(exchange string) {
+	v, ok := s.lastRecv.Load(exchange)
+	if !ok {
+		v, _ = s.lastRecv.LoadOrStore(exchange, new(atomic.Int64))
+	}
+	v.(*atomic.Int64).Store(time.Now().UnixNano())
+}
+
+// LastDelta reports when the last delta of each exchange arrived.
+func (s *GRPCServer) LastDelta() map[string]time.Time {
+	res := make(map[string]time.Time)
+	s.lastRecv.Range(func(k, v any) bool {
+		res[k.(string)] = time.Unix(0, v.(*atomic.Int64).Load())
+		return true
+	})
+	return res
 }
 
 func toLevels(src []*mdpb.Level) []types.Level {
@@ -81,11 +183,26 @@ func toLevels(src []*mdpb.Level) []types.Level {
 	return res
 }
 
-func (s *GRPCServer) pickTradeAmount(symbol string) float64 {
-	for q, amt := range s.Config.Strategy.TradeAmounts {
+// resyncMarkets lists the pushed markets waiting for a snapshot, so the feed
+// can send them all when it reconnects. Markets other streams push are theirs
+// to resync.
+func (s *GRPCServer) resyncMarkets(pushed map[types.MarketKey]struct{}) []*mdpb.MarketId {
+	stale := s.Seq.Stale()
+	res := make([]*mdpb.MarketId, 0, len(stale))
+	for _, key := range stale {
+		if _, ok := pushed[key]; !ok {
+			continue
+		}
+		res = append(res, &mdpb.MarketId{Exchange: key.Exchange, Symbol: key.Symbol})
+	}
+	return res
+}
+
+func pickTradeAmount(cfg *config.Config, symbol string) float64 {
+	for q, amt := range cfg.Strategy.TradeAmounts {
 		if strings.HasSuffix(symbol, q) { return amt }
 	}
-	return s.Config.Strategy.TradeAmount
+	return cfg.Strategy.TradeAmount
 }
 
 func Serve(ctx context.Context, listenAddr string, srv *GRPCServer) error {
@@ -93,7 +210,18 @@ func Serve(ctx context.Context, listenAddr string, srv *GRPCServer) error {
 	if err != nil { return err }
 	grpcServer := grpc.NewServer()
 	mdpb.RegisterOrderBookIngressServer(grpcServer, srv)
-	go func() { <-ctx.Done(); grpcServer.GracefulStop() }()
+	if srv.Feed != nil { mdpb.RegisterOrderBookFeedServer(grpcServer, srv.Feed) }
+	if srv.Health != nil { healthpb.RegisterHealthServer(grpcServer, srv.Health) }
+	stopped := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		timer := time.AfterFunc(shutdownGrace, grpcServer.Stop)
+		grpcServer.GracefulStop()
+		timer.Stop()
+		close(stopped)
+	}()
 	logger.Log.Infof("ingress gRPC listening on %s", listenAddr)
-	return grpcServer.Serve(lis)
+	if err := grpcServer.Serve(lis); err != nil { return fmt.Errorf("failed to serve ingress: %w", err) }
+	<-stopped
+	return nil
 }
@@ -0,0 +1,350 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/bookstore"
+	"github.com/armagg/circular-arbitrage-finder/pkg/capture"
+	"github.com/armagg/circular-arbitrage-finder/pkg/config"
+	"github.com/armagg/circular-arbitrage-finder/pkg/detector"
+	"github.com/armagg/circular-arbitrage-finder/pkg/graph"
+	"github.com/armagg/circular-arbitrage-finder/pkg/profit"
+	"github.com/armagg/circular-arbitrage-finder/pkg/registry"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+	mdpb "github.com/armagg/circular-arbitrage-finder/proto/md"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// fakeIngressStream replays deltas and records the Ack the server closes with.
+type fakeIngressStream struct {
+	grpc.ServerStream
+	deltas []*mdpb.OrderBookDelta
+	ack    *mdpb.Ack
+}
+
+func (f *fakeIngressStream) Recv() (*mdpb.OrderBookDelta, error) {
+	if len(f.deltas) == 0 {
+		return nil, io.EOF
+	}
+	d := f.deltas[0]
+	f.deltas = f.deltas[1:]
+	return d, nil
+}
+
+func (f *fakeIngressStream) SendAndClose(ack *mdpb.Ack) error {
+	f.ack = ack
+	return nil
+}
+
+func newTestServer() *GRPCServer {
+	cfg := &config.Config{QuoteAssets: []string{"USDT"}}
+	tob := bookstore.NewTopOfBookStore()
+	idx := graph.NewIndex()
+	det := detector.NewDetector(idx, tob, registry.NewMarketRegistry(), profit.NewTOBSimulator(1.0, 0), nil)
+	return NewGRPCServer(tob, det, cfg, bookstore.NewOrderBookStore())
+}
+
+func testDelta(seq uint64, snapshot bool, bid, ask float64) *mdpb.OrderBookDelta {
+	return &mdpb.OrderBookDelta{
+		Market:     &mdpb.MarketId{Exchange: "binance", Symbol: "BTCUSDT"},
+		Sequence:   seq,
+		Bids:       []*mdpb.Level{{Price: bid, Qty: 1}},
+		Asks:       []*mdpb.Level{{Price: ask, Qty: 1}},
+		IsSnapshot: snapshot,
+	}
+}
+
+func TestPushDeltasGapRequestsResync(t *testing.T) {
+	srv := newTestServer()
+	key := types.NewMarketKey("BINANCE", "BTCUSDT")
+	ethKey := types.NewMarketKey("BINANCE", "ETHUSDT")
+	// ETHUSDT never sends a snapshot; its first delta is the baseline
+	ethDelta := func(seq uint64, bid, ask float64) *mdpb.OrderBookDelta {
+		d := testDelta(seq, false, bid, ask)
+		d.Market.Symbol = "ETHUSDT"
+		return d
+	}
+
+	stream := &fakeIngressStream{deltas: []*mdpb.OrderBookDelta{
+		testDelta(1, true, 49990, 50010),
+		ethDelta(7, 2999, 3001),
+		testDelta(2, false, 49995, 50005),
+		testDelta(4, false, 49000, 51000),
+		testDelta(5, false, 49001, 50999),
+		ethDelta(8, 2998, 3002),
+	}}
+	if err := srv.PushDeltas(stream); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(stream.deltas) != 0 {
+		t.Errorf("Expected the gap to leave the stream open, %d deltas left", len(stream.deltas))
+	}
+	if stream.ack == nil || stream.ack.Ok {
+		t.Fatalf("Expected ok=false while a market needs a resync, got %+v", stream.ack)
+	}
+	if len(stream.ack.Resync) != 1 || stream.ack.Resync[0].Exchange != "BINANCE" || stream.ack.Resync[0].Symbol != "BTCUSDT" {
+		t.Errorf("Expected a resync request for BINANCE:BTCUSDT only, got %v", stream.ack.Resync)
+	}
+	if _, ok := srv.TOBStore.Get(key); ok {
+		t.Error("Expected the stale top of book to be dropped")
+	}
+	if _, ok := srv.OBStore.Get(key); ok {
+		t.Error("Expected the stale order book to be dropped")
+	}
+	if tob, ok := srv.TOBStore.Get(ethKey); !ok || tob.Seq != 8 {
+		t.Errorf("Expected ETHUSDT deltas after the gap to be applied, got %+v", tob)
+	}
+
+	// Deltas keep being refused on the next stream until a snapshot arrives
+	stream = &fakeIngressStream{deltas: []*mdpb.OrderBookDelta{testDelta(5, false, 49001, 50999)}}
+	_ = srv.PushDeltas(stream)
+	if _, ok := srv.TOBStore.Get(key); ok {
+		t.Error("Expected deltas on a stale book to be dropped")
+	}
+	if len(stream.ack.Resync) != 1 {
+		t.Errorf("Expected the market to still need a resync, got %v", stream.ack.Resync)
+	}
+
+	stream = &fakeIngressStream{deltas: []*mdpb.OrderBookDelta{
+		testDelta(9, true, 49980, 50020),
+		testDelta(10, false, 49985, 50015),
+	}}
+	_ = srv.PushDeltas(stream)
+	tob, ok := srv.TOBStore.Get(key)
+	if !ok || tob.BidPx != 49985 || tob.AskPx != 50015 {
+		t.Errorf("Expected the snapshot to restore the book, got %+v", tob)
+	}
+	if !stream.ack.Ok || len(stream.ack.Resync) != 0 {
+		t.Errorf("Expected ok and no resync after the snapshot, got %+v", stream.ack)
+	}
+}
+
+func TestPushDeltasResyncsOnlyPushedMarkets(t *testing.T) {
+	srv := newTestServer()
+	kucoin := func(seq uint64) *mdpb.OrderBookDelta {
+		d := testDelta(seq, false, 2999, 3001)
+		d.Market = &mdpb.MarketId{Exchange: "kucoin", Symbol: "ETHUSDT"}
+		return d
+	}
+
+	// The pusher keeps sending after the gap, and only learns of it on close
+	gapped := &fakeIngressStream{deltas: []*mdpb.OrderBookDelta{
+		testDelta(1, true, 49990, 50010),
+		testDelta(3, false, 49995, 50005),
+		testDelta(4, false, 49996, 50004),
+		testDelta(5, false, 49997, 50003),
+	}}
+	clean := &fakeIngressStream{deltas: []*mdpb.OrderBookDelta{kucoin(1), kucoin(2), kucoin(3)}}
+	_ = srv.PushDeltas(gapped)
+	_ = srv.PushDeltas(clean)
+
+	if len(gapped.ack.Resync) != 1 || gapped.ack.Resync[0].Symbol != "BTCUSDT" {
+		t.Errorf("Expected the gapped stream to be asked for BTCUSDT, got %v", gapped.ack.Resync)
+	}
+	if !clean.ack.Ok || len(clean.ack.Resync) != 0 {
+		t.Errorf("Expected another stream's stale market to be left out, got %+v", clean.ack)
+	}
+	if _, ok := srv.TOBStore.Get(types.NewMarketKey("BINANCE", "BTCUSDT")); ok {
+		t.Error("Expected deltas after the gap to be dropped")
+	}
+}
+
+func TestPushDeltasDropsDuplicates(t *testing.T) {
+	srv := newTestServer()
+	received := testutil.ToFloat64(deltasReceived.WithLabelValues("BINANCE"))
+	stream := &fakeIngressStream{deltas: []*mdpb.OrderBookDelta{
+		testDelta(1, true, 49990, 50010),
+		testDelta(2, false, 49995, 50005),
+		testDelta(2, false, 1, 2),
+		testDelta(1, false, 1, 2),
+	}}
+	_ = srv.PushDeltas(stream)
+
+	book, _ := srv.OBStore.Get(types.NewMarketKey("BINANCE", "BTCUSDT"))
+	if len(book.Bids) != 2 || book.Bids[0].Price != 49995 || book.Seq != 2 {
+		t.Errorf("Expected replays to be ignored, got %+v", book)
+	}
+	if len(stream.ack.Resync) != 0 {
+		t.Errorf("Expected duplicates not to trigger a resync, got %v", stream.ack.Resync)
+	}
+	if n := testutil.ToFloat64(deltasReceived.WithLabelValues("BINANCE")) - received; n != 4 {
+		t.Errorf("Expected every delta received to be counted, got %f", n)
+	}
+	if at, ok := srv.LastDelta()["BINANCE"]; !ok || time.Since(at) > time.Minute {
+		t.Errorf("Expected the last BINANCE delta to be tracked, got %v", srv.LastDelta())
+	}
+}
+
+func TestPushDeltasConcurrentStreams(t *testing.T) {
+	sources := map[string]func(*GRPCServer) detector.CycleSource{
+		"index": func(srv *GRPCServer) detector.CycleSource { return detector.IndexCycles{Index: srv.Detector.Index} },
+		"negative loop": func(srv *GRPCServer) detector.CycleSource {
+			return detector.NewNegativeCycleSearch(srv.Detector.Index, srv.TOBStore, srv.Detector.Registry, 0)
+		},
+	}
+	for name, source := range sources {
+		t.Run(name, func(t *testing.T) {
+			srv := newTestServer()
+			srv.SetConfig(&config.Config{QuoteAssets: []string{"USDT", "BTC"}})
+			srv.Detector.Source = source(srv)
+
+			// Each stream prices a triangle on its own venue while adding
+			// markets the other one's pricing reads the index around
+			stream := func(exchange string) *fakeIngressStream {
+				var deltas []*mdpb.OrderBookDelta
+				for i := 0; i < 50; i++ {
+					for _, symbol := range []string{"ETHUSDT", "ETHBTC", "BTCUSDT", fmt.Sprintf("X%dUSDT", i)} {
+						d := testDelta(uint64(i+1), true, 1, 2)
+						d.Market = &mdpb.MarketId{Exchange: exchange, Symbol: symbol}
+						deltas = append(deltas, d)
+					}
+				}
+				return &fakeIngressStream{deltas: deltas}
+			}
+			var wg sync.WaitGroup
+			for _, exchange := range []string{"BINANCE", "KUCOIN"} {
+				wg.Add(1)
+				go func(s *fakeIngressStream) {
+					defer wg.Done()
+					srv.PushDeltas(s)
+				}(stream(exchange))
+			}
+			wg.Wait()
+
+			if markets, _ := srv.Detector.Index.Size(); markets != 2*53 {
+				t.Errorf("Expected every market of both streams to be indexed, got %d", markets)
+			}
+		})
+	}
+}
+
+func TestSetConfigAppliesToNewMarkets(t *testing.T) {
+	srv := newTestServer()
+	ethBTC := &mdpb.OrderBookDelta{
+		Market:     &mdpb.MarketId{Exchange: "binance", Symbol: "ETHBTC"},
+		Sequence:   1,
+		Bids:       []*mdpb.Level{{Price: 0.05, Qty: 1}},
+		Asks:       []*mdpb.Level{{Price: 0.051, Qty: 1}},
+		IsSnapshot: true,
+	}
+	srv.Apply(ethBTC)
+	if _, ok := srv.Detector.Index.MarketIndexByKey[types.NewMarketKey("BINANCE", "ETHBTC")]; ok {
+		t.Fatal("Expected ETHBTC to be unparseable without BTC as a quote asset")
+	}
+
+	srv.SetConfig(&config.Config{
+		QuoteAssets: []string{"USDT", "BTC"},
+		Fees:        config.Fees{Default: config.FeeConfig{Taker: 7, Maker: 3}},
+	})
+	srv.Apply(ethBTC)
+	key := types.NewMarketKey("BINANCE", "ETHBTC")
+	if m, ok := srv.Detector.Registry.GetMarket(key); !ok || m.Quote != "BTC" {
+		t.Errorf("Expected ETHBTC to be added with quote BTC, got %+v", m)
+	}
+	if fee, _ := srv.Detector.Registry.GetFee(key); fee != (types.Fee{TakerBp: 7, MakerBp: 3}) {
+		t.Errorf("Expected the new default fee, got %+v", fee)
+	}
+}
+
+func TestPushDeltasRecordsCapture(t *testing.T) {
+	dir := t.TempDir()
+	srv := newTestServer()
+	rec, err := capture.NewRecorder(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Recorder = rec
+	stream := &fakeIngressStream{deltas: []*mdpb.OrderBookDelta{
+		testDelta(1, true, 49990, 50010),
+		testDelta(2, false, 49995, 50005),
+		// Replays are recorded too, exactly as received
+		testDelta(2, false, 1, 2),
+	}}
+	_ = srv.PushDeltas(stream)
+	if err := rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, _ := filepath.Glob(filepath.Join(dir, "*.cap"))
+	if len(names) != 1 {
+		t.Fatalf("Expected one capture file, got %v", names)
+	}
+	f, err := os.Open(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := capture.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seqs []uint64
+	for {
+		record, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.RecvNs == 0 {
+			t.Error("Expected a receive timestamp")
+		}
+		seqs = append(seqs, record.Delta.GetSequence())
+	}
+	if len(seqs) != 3 || seqs[0] != 1 || seqs[2] != 2 {
+		t.Errorf("Expected every delta in order, got %v", seqs)
+	}
+}
+
+func TestServeHealthAndStop(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	srv := newTestServer()
+	srv.Health = grpchealth.NewServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- Serve(ctx, addr, srv) }()
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var resp *healthpb.HealthCheckResponse
+	waitFor(t, "the health service", func() bool {
+		resp, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{})
+		return err == nil
+	})
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected SERVING, got %v", resp.Status)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected a clean stop, got %v", err)
+		}
+	case <-time.After(shutdownGrace + time.Second):
+		t.Fatal("Expected Serve to return after cancel")
+	}
+}
@@ -0,0 +1,114 @@
+package ingest
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// SeqStatus is what the tracker decided about one feed message.
+type SeqStatus int
+
+const (
+	// SeqOK messages are applied to the book.
+	SeqOK SeqStatus = iota
+	// SeqDuplicate messages repeat or predate the last one applied and are dropped.
+	SeqDuplicate
+	// SeqGap messages skip a sequence number. The book is stale from here on.
+	SeqGap
+	// SeqStale messages arrive while the book waits for a snapshot and are dropped.
+	SeqStale
+)
+
+func (s SeqStatus) String() string {
+	switch s {
+	case SeqOK:
+		return "ok"
+	case SeqDuplicate:
+		return "duplicate"
+	case SeqGap:
+		return "gap"
+	case SeqStale:
+		return "stale"
+	}
+	return "unknown"
+}
+
+type seqState struct {
+	last  uint64
+	stale bool
+}
+
+// SequenceTracker checks that each market's deltas follow on from the last
+// message applied. Snapshots always reset the market, and the first numbered
+// delta of a market never seen is taken as its baseline, for feeds that send
+// no snapshots. Messages with sequence 0 come from feeds that do not number
+// their updates and are never checked.
+type SequenceTracker struct {
+	mu      sync.Mutex
+	markets map[types.MarketKey]*seqState
+}
+
+func NewSequenceTracker() *SequenceTracker {
+	return &SequenceTracker{markets: make(map[types.MarketKey]*seqState)}
+}
+
+func (t *SequenceTracker) Check(key types.MarketKey, seq uint64, snapshot bool) SeqStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	st, ok := t.markets[key]
+	if snapshot {
+		t.markets[key] = &seqState{last: seq}
+		return SeqOK
+	}
+	if seq == 0 {
+		if ok && st.stale {
+			return SeqStale
+		}
+		return SeqOK
+	}
+	switch {
+	case !ok:
+		t.markets[key] = &seqState{last: seq}
+		return SeqOK
+	case st.stale:
+		return SeqStale
+	case seq <= st.last:
+		return SeqDuplicate
+	case seq != st.last+1:
+		st.stale = true
+		return SeqGap
+	}
+	st.last = seq
+	return SeqOK
+}
+
+// MarkStale makes key wait for a snapshot, such as when the feed itself
+// reports the book stale.
+func (t *SequenceTracker) MarkStale(key types.MarketKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if st, ok := t.markets[key]; ok {
+		st.stale = true
+		return
+	}
+	t.markets[key] = &seqState{stale: true}
+}
+
+// Stale returns the markets waiting for a snapshot, sorted.
+func (t *SequenceTracker) Stale() []types.MarketKey {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var keys []types.MarketKey
+	for key, st := range t.markets {
+		if st.stale {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
@@ -0,0 +1,66 @@
+package ingest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+func TestSequenceTracker(t *testing.T) {
+	key := types.NewMarketKey("binance", "BTCUSDT")
+
+	steps := []struct {
+		name     string
+		seq      uint64
+		snapshot bool
+		expected SeqStatus
+	}{
+		{"First delta is the baseline", 5, false, SeqOK},
+		{"Next after the baseline", 6, false, SeqOK},
+		{"Gap after the baseline", 8, false, SeqGap},
+		{"Stale until a snapshot", 9, false, SeqStale},
+		{"Snapshot resets", 10, true, SeqOK},
+		{"Next in line", 11, false, SeqOK},
+		{"Duplicate", 11, false, SeqDuplicate},
+		{"Out of order", 9, false, SeqDuplicate},
+		{"Next after a dropped message", 12, false, SeqOK},
+		{"Gap", 14, false, SeqGap},
+		{"Next after the gap is still stale", 15, false, SeqStale},
+		{"Unnumbered delta while stale", 0, false, SeqStale},
+		{"Snapshot may go backwards", 3, true, SeqOK},
+		{"Unnumbered delta", 0, false, SeqOK},
+		{"Next after an unnumbered delta", 4, false, SeqOK},
+	}
+
+	tracker := NewSequenceTracker()
+	for _, step := range steps {
+		if got := tracker.Check(key, step.seq, step.snapshot); got != step.expected {
+			t.Fatalf("%s: expected %v, got %v", step.name, step.expected, got)
+		}
+	}
+}
+
+func TestSequenceTrackerStale(t *testing.T) {
+	tracker := NewSequenceTracker()
+	eth := types.NewMarketKey("kucoin", "ETH-USDT")
+	btc := types.NewMarketKey("binance", "BTCUSDT")
+	xrp := types.NewMarketKey("binance", "XRPUSDT")
+
+	tracker.Check(eth, 1, true)
+	tracker.Check(eth, 3, false)
+	tracker.Check(btc, 1, true)
+	tracker.Check(btc, 5, false)
+	tracker.Check(xrp, 1, true)
+	tracker.Check(xrp, 2, false)
+
+	expected := []types.MarketKey{btc, eth}
+	if got := tracker.Stale(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected stale markets %v, got %v", expected, got)
+	}
+
+	tracker.Check(btc, 7, true)
+	if got := tracker.Stale(); !reflect.DeepEqual(got, []types.MarketKey{eth}) {
+		t.Errorf("Expected the snapshot to clear BTCUSDT, got %v", got)
+	}
+}
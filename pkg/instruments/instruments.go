@@ -0,0 +1,152 @@
+package instruments
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/config"
+	"github.com/armagg/circular-arbitrage-finder/pkg/graph"
+	"github.com/armagg/circular-arbitrage-finder/pkg/logger"
+	"github.com/armagg/circular-arbitrage-finder/pkg/registry"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// File is the on-disk layout: instruments grouped by exchange.
+type File struct {
+	Exchanges map[string][]Spec `yaml:"exchanges" json:"exchanges"`
+}
+
+// Spec describes one market with an explicit base/quote and its trading rules.
+// Fees are optional; when omitted the configured fee for the quote applies.
+type Spec struct {
+	Symbol      string   `yaml:"symbol" json:"symbol"`
+	Base        string   `yaml:"base" json:"base"`
+	Quote       string   `yaml:"quote" json:"quote"`
+	Multiplier  int64    `yaml:"multiplier" json:"multiplier"`
+	MinQty      float64  `yaml:"min_qty" json:"min_qty"`
+	StepSize    float64  `yaml:"step_size" json:"step_size"`
+	MinNotional float64  `yaml:"min_notional" json:"min_notional"`
+	PriceTick   float64  `yaml:"price_tick" json:"price_tick"`
+	TakerBp     *float64 `yaml:"taker_bp" json:"taker_bp"`
+	MakerBp     *float64 `yaml:"maker_bp" json:"maker_bp"`
+}
+
+// Instrument is a validated market together with its fee override, if any.
+type Instrument struct {
+	Market types.Market
+	Fee    *types.Fee
+}
+
+// Load reads an instruments file. Files ending in .json are decoded as JSON,
+// anything else as YAML.
+func Load(path string) ([]Instrument, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instruments file: %w", err)
+	}
+	return Decode(data, strings.EqualFold(filepath.Ext(path), ".json"))
+}
+
+func Decode(data []byte, isJSON bool) ([]Instrument, error) {
+	var f File
+	if isJSON {
+		if err := json.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal instruments json: %w", err)
+		}
+	} else {
+		if err := yaml.Unmarshal(data, &f); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal instruments yaml: %w", err)
+		}
+	}
+
+	exchanges := make([]string, 0, len(f.Exchanges))
+	for ex := range f.Exchanges {
+		exchanges = append(exchanges, ex)
+	}
+	sort.Strings(exchanges)
+
+	var res []Instrument
+	seen := make(map[types.MarketKey]bool)
+	for _, exchange := range exchanges {
+		ex := strings.ToUpper(exchange)
+		for _, sp := range f.Exchanges[exchange] {
+			inst, err := sp.instrument(ex)
+			if err != nil {
+				return nil, err
+			}
+			key := inst.Market.Key()
+			if seen[key] {
+				return nil, fmt.Errorf("duplicate instrument %s", key)
+			}
+			seen[key] = true
+			res = append(res, inst)
+		}
+	}
+	return res, nil
+}
+
+func (sp Spec) instrument(exchange string) (Instrument, error) {
+	m := types.Market{
+		Exchange:    exchange,
+		Symbol:      strings.ToUpper(sp.Symbol),
+		Base:        strings.ToUpper(sp.Base),
+		Quote:       strings.ToUpper(sp.Quote),
+		Multiplier:  sp.Multiplier,
+		MinQty:      sp.MinQty,
+		StepSize:    sp.StepSize,
+		MinNotional: sp.MinNotional,
+		PriceTick:   sp.PriceTick,
+	}
+	if m.Symbol == "" || m.Base == "" || m.Quote == "" {
+		return Instrument{}, fmt.Errorf("instrument %s:%q needs symbol, base and quote", exchange, sp.Symbol)
+	}
+	if m.Base == m.Quote {
+		return Instrument{}, fmt.Errorf("instrument %s:%s has identical base and quote %s", exchange, m.Symbol, m.Base)
+	}
+	if m.MinQty < 0 || m.StepSize < 0 || m.MinNotional < 0 || m.PriceTick < 0 {
+		return Instrument{}, fmt.Errorf("instrument %s:%s has negative trading rules", exchange, m.Symbol)
+	}
+
+	inst := Instrument{Market: m}
+	if sp.TakerBp != nil || sp.MakerBp != nil {
+		fee := types.Fee{}
+		if sp.TakerBp != nil {
+			fee.TakerBp = *sp.TakerBp
+		}
+		if sp.MakerBp != nil {
+			fee.MakerBp = *sp.MakerBp
+		}
+		inst.Fee = &fee
+	}
+	return inst, nil
+}
+
+// Apply registers every instrument in the index and registry before any
+// market data arrives, so ingest never falls back to suffix parsing for them.
+// It returns the number of markets added.
+func Apply(list []Instrument, idx *graph.Index, reg *registry.MarketRegistry, cfg *config.Config) int {
+	added := 0
+	for _, inst := range list {
+		m := inst.Market
+		if _, isNew := idx.AddMarket(m); !isNew {
+			continue
+		}
+		fee := cfg.GetFee(m.Exchange, m.Quote)
+		if inst.Fee != nil {
+			fee = *inst.Fee
+		}
+		reg.UpsertMarket(m)
+		reg.SetFee(m.Key(), fee)
+		added++
+	}
+	_, cycles := idx.Size()
+	logger.Log.WithFields(logrus.Fields{"markets": added, "cycles": cycles}).Info("instruments: preloaded markets")
+	return added
+}
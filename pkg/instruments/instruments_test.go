@@ -0,0 +1,157 @@
+package instruments
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/config"
+	"github.com/armagg/circular-arbitrage-finder/pkg/graph"
+	"github.com/armagg/circular-arbitrage-finder/pkg/registry"
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+const testYAML = `
+exchanges:
+  kucoin:
+    - symbol: btc-usdt
+      base: btc
+      quote: usdt
+      min_qty: 0.00001
+      step_size: 0.00000001
+      min_notional: 0.1
+      price_tick: 0.1
+    - symbol: ETH-USDT
+      base: ETH
+      quote: USDT
+      step_size: 0.0001
+    - symbol: ETH-BTC
+      base: ETH
+      quote: BTC
+      taker_bp: 8.0
+`
+
+const testJSON = `{
+  "exchanges": {
+    "BINANCE": [
+      {"symbol": "BTCUSDT", "base": "BTC", "quote": "USDT", "min_qty": 0.00001, "step_size": 0.00001, "min_notional": 5, "price_tick": 0.01}
+    ]
+  }
+}`
+
+func TestDecodeYAML(t *testing.T) {
+	list, err := Decode([]byte(testYAML), false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("Expected 3 instruments, got %d", len(list))
+	}
+
+	expected := types.Market{
+		Exchange:    "KUCOIN",
+		Symbol:      "BTC-USDT",
+		Base:        "BTC",
+		Quote:       "USDT",
+		MinQty:      0.00001,
+		StepSize:    0.00000001,
+		MinNotional: 0.1,
+		PriceTick:   0.1,
+	}
+	if list[0].Market != expected {
+		t.Errorf("Expected %+v, got %+v", expected, list[0].Market)
+	}
+	if list[0].Fee != nil {
+		t.Error("Expected no fee override when the file omits fees")
+	}
+	if list[2].Fee == nil || list[2].Fee.TakerBp != 8.0 || list[2].Fee.MakerBp != 0 {
+		t.Errorf("Expected taker-only fee override, got %+v", list[2].Fee)
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	list, err := Decode([]byte(testJSON), true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(list) != 1 || list[0].Market.Symbol != "BTCUSDT" || list[0].Market.PriceTick != 0.01 {
+		t.Errorf("Unexpected instruments: %+v", list)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"Invalid YAML", "exchanges: [unclosed"},
+		{"Missing quote", "exchanges:\n  BINANCE:\n    - {symbol: BTCUSDT, base: BTC}\n"},
+		{"Same base and quote", "exchanges:\n  BINANCE:\n    - {symbol: BTCBTC, base: BTC, quote: BTC}\n"},
+		{"Negative step", "exchanges:\n  BINANCE:\n    - {symbol: BTCUSDT, base: BTC, quote: USDT, step_size: -1}\n"},
+		{"Duplicate", "exchanges:\n  BINANCE:\n    - {symbol: BTCUSDT, base: BTC, quote: USDT}\n    - {symbol: btcusdt, base: BTC, quote: USDT}\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Decode([]byte(tt.data), false); err == nil {
+				t.Errorf("Expected error for %s", tt.name)
+			}
+		})
+	}
+}
+
+func TestLoadByExtension(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "binance.json")
+	if err := os.WriteFile(path, []byte(testJSON), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := Load(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(list) != 1 {
+		t.Errorf("Expected 1 instrument, got %d", len(list))
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Error("Expected error for missing file")
+	}
+}
+
+func TestApply(t *testing.T) {
+	list, err := Decode([]byte(testYAML), false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	cfg := &config.Config{Fees: config.Fees{Default: config.FeeConfig{Taker: 10, Maker: 5}}}
+	idx := graph.NewIndex()
+	reg := registry.NewMarketRegistry()
+
+	if added := Apply(list, idx, reg, cfg); added != 3 {
+		t.Errorf("Expected 3 markets added, got %d", added)
+	}
+	if len(idx.Cycles) != 2 {
+		t.Errorf("Expected dash symbols to form 1 triangle in both directions, got %d cycles", len(idx.Cycles))
+	}
+	if _, ok := idx.MarketIndexByKey[types.NewMarketKey("KUCOIN", "ETH-BTC")]; !ok {
+		t.Error("Expected KUCOIN:ETH-BTC in the index")
+	}
+
+	m, ok := reg.GetMarket(types.NewMarketKey("KUCOIN", "BTC-USDT"))
+	if !ok || m.StepSize != 0.00000001 {
+		t.Errorf("Expected trading rules in the registry, got %+v", m)
+	}
+	if fee, _ := reg.GetFee(types.NewMarketKey("KUCOIN", "ETH-USDT")); fee.TakerBp != 10 {
+		t.Errorf("Expected configured default fee, got %+v", fee)
+	}
+	if fee, _ := reg.GetFee(types.NewMarketKey("KUCOIN", "ETH-BTC")); fee.TakerBp != 8 {
+		t.Errorf("Expected file fee override, got %+v", fee)
+	}
+
+	// Applying the same file again adds nothing
+	if added := Apply(list, idx, reg, cfg); added != 0 {
+		t.Errorf("Expected no markets on re-apply, got %d", added)
+	}
+}
@@ -0,0 +1,36 @@
+// Package metrics holds what the Prometheus client has no ready-made
+// collector for. Metrics are registered with prometheus.DefaultRegisterer
+// and served by promhttp.Handler.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultBuckets suit latencies in seconds, from 100µs to 10s.
+var DefaultBuckets = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// counterMapFunc reads its samples when scraped, one per key under its label.
+type counterMapFunc struct {
+	desc *prometheus.Desc
+	fn   func() map[string]float64
+}
+
+func (c *counterMapFunc) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *counterMapFunc) Collect(ch chan<- prometheus.Metric) {
+	for k, v := range c.fn() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, v, k)
+	}
+}
+
+// NewCounterMapFunc exposes a counter per key of what fn returns, under
+// label, and registers it with reg. It panics when the name is taken, like
+// promauto.
+func NewCounterMapFunc(reg prometheus.Registerer, name, help, label string, fn func() map[string]float64) prometheus.Collector {
+	c := &counterMapFunc{desc: prometheus.NewDesc(name, help, []string{label}, nil), fn: fn}
+	reg.MustRegister(c)
+	return c
+}
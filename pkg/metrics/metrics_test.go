@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestNewCounterMapFunc(t *testing.T) {
+	reg := prometheus.NewPedanticRegistry()
+	samples := map[string]float64{"stale": 2, "skew": 1}
+	NewCounterMapFunc(reg, "test_rejections_total", "Rejections.", "reason", func() map[string]float64 { return samples })
+
+	want := `# HELP test_rejections_total Rejections.
+# TYPE test_rejections_total counter
+test_rejections_total{reason="skew"} 1
+test_rejections_total{reason="stale"} 2
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+
+	// Keys are read on every scrape
+	samples = map[string]float64{"stale": 3}
+	if n := testutil.CollectAndCount(reg); n != 1 {
+		t.Errorf("Expected one series after the keys changed, got %d", n)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected a duplicate name to panic")
+		}
+	}()
+	NewCounterMapFunc(reg, "test_rejections_total", "Again.", "reason", func() map[string]float64 { return nil })
+}
@@ -0,0 +1,259 @@
+package profit
+
+import (
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// maxShrinkPasses bounds how many times a plan is scaled down to fit the books.
+const maxShrinkPasses = 8
+
+// DepthSimulator walks every leg's ladder level by level, so a plan is priced
+// at the VWAP it would actually fill at instead of the best bid/ask.
+type DepthSimulator struct {
+	MinEdge      float64
+	SlippageBp   float64
+	MinFillRatio float64
+	Books        func(key types.MarketKey) (types.OrderBook, bool)
+	Transfers    *Transfers
+	Inventory    *Inventory
+	Freshness    *Freshness
+}
+
+func NewDepthSimulator(minEdge, slippageBp, minFillRatio float64, books func(key types.MarketKey) (types.OrderBook, bool)) *DepthSimulator {
+	return &DepthSimulator{MinEdge: minEdge, SlippageBp: slippageBp, MinFillRatio: minFillRatio, Books: books}
+}
+
+// depthPath holds the per-leg inputs of a single cycle evaluation.
+type depthPath struct {
+	books []types.OrderBook
+	fees  []types.Fee
+}
+
+// depthFill is the outcome of pushing one amount through the cycle's ladders.
+type depthFill struct {
+	legs  []types.Leg
+	cost  float64 // starting asset consumed by the first leg after rounding
+	value float64 // final value after fees
+	fill  float64 // share of the input the books could absorb
+	valid bool    // false when a leg breaks its market's lot rules
+}
+
+// EvaluateTOB prices the cycle against the full depth of each book. When
+// the books cannot absorb targetQuote the plan is shrunk to the largest size
+// that fills completely, and dropped if that is below MinFillRatio of the target.
+func (s *DepthSimulator) EvaluateTOB(t types.Cycle, markets []types.Market, tobByMarket func(key types.MarketKey) (types.TopOfBook, bool), feesByMarket func(key types.MarketKey) (types.Fee, bool), targetQuote float64) (types.Plan, bool) {
+	if !validCycle(t, markets) {
+		return types.Plan{}, false
+	}
+	t, targetQuote, _, ok := s.Inventory.start(t, markets, targetQuote)
+	if !ok || targetQuote <= 0 {
+		return types.Plan{}, false
+	}
+	p, ok := s.load(t, markets, feesByMarket)
+	if !ok {
+		return types.Plan{}, false
+	}
+	// The top-of-book rate is an upper bound on any deeper fill, so a
+	// cycle that fails here cannot pass once the ladders are walked.
+	if s.bestRate(t, markets, p) <= s.MinEdge {
+		return types.Plan{}, false
+	}
+	amount, f, ok := s.fit(t, markets, p, targetQuote)
+	if !ok || amount < targetQuote*s.MinFillRatio {
+		return types.Plan{}, false
+	}
+	return s.makePlan(t, markets, p, f)
+}
+
+func (s *DepthSimulator) load(t types.Cycle, markets []types.Market, feesByMarket func(key types.MarketKey) (types.Fee, bool)) (*depthPath, bool) {
+	if s.Books == nil || !validCycle(t, markets) {
+		return nil, false
+	}
+	p := depthPath{books: make([]types.OrderBook, t.Len()), fees: make([]types.Fee, t.Len())}
+	ts := make([]int64, t.Len())
+	for i, mid := range t.MarketIds {
+		key := markets[mid].Key()
+		ob, ok := s.Books(key)
+		if !ok || !validLadder(ob.Bids) || !validLadder(ob.Asks) {
+			return nil, false
+		}
+		p.books[i] = ob
+		ts[i] = ob.TsNs
+		f, ok := feesByMarket(key)
+		if !ok {
+			f = types.Fee{}
+		}
+		p.fees[i] = f
+	}
+	if !s.Freshness.check(t, markets, ts) {
+		return nil, false
+	}
+	return &p, true
+}
+
+// fit shrinks amount until every leg fills completely and returns the size
+// that fits along with its fill.
+func (s *DepthSimulator) fit(t types.Cycle, markets []types.Market, p *depthPath, amount float64) (float64, depthFill, bool) {
+	for pass := 0; pass < maxShrinkPasses; pass++ {
+		f := s.walk(t, markets, p, amount)
+		if !f.valid {
+			break
+		}
+		if f.fill >= 1.0-1e-9 {
+			return amount, f, true
+		}
+		if f.fill <= 0 {
+			break
+		}
+		amount *= f.fill * (1.0 - 1e-9)
+	}
+	return 0, depthFill{}, false
+}
+
+func (s *DepthSimulator) makePlan(t types.Cycle, markets []types.Market, p *depthPath, f depthFill) (types.Plan, bool) {
+	if !f.valid || f.cost <= 0 {
+		return types.Plan{}, false
+	}
+	expectedProfit := f.value - f.cost
+	if !isFinite(expectedProfit) || expectedProfit <= 0 || f.value/f.cost <= s.MinEdge {
+		return types.Plan{}, false
+	}
+
+	plan := types.Plan{
+		Exchange:            markets[t.MarketIds[0]].Exchange,
+		Legs:                f.legs,
+		ExpectedProfitQuote: expectedProfit,
+		QuoteCurrency:       startAsset(t, markets),
+		ValidMs:             250,
+		MaxSlippageBp:       s.SlippageBp,
+		PlanID:              "",
+		Decision:            legDecision(t, p.tops(), p.fees),
+	}
+	return plan, true
+}
+
+// tops is the best level of each leg's book as a top of book.
+func (p *depthPath) tops() []types.TopOfBook {
+	tops := make([]types.TopOfBook, len(p.books))
+	for i, ob := range p.books {
+		tops[i] = types.TopOfBook{BidPx: ob.Bids[0].Price, BidSz: ob.Bids[0].Qty, AskPx: ob.Asks[0].Price, AskSz: ob.Asks[0].Qty, Seq: ob.Seq, TsNs: ob.TsNs}
+	}
+	return tops
+}
+
+func (s *DepthSimulator) bestRate(t types.Cycle, markets []types.Market, p *depthPath) float64 {
+	rate := 1.0
+	for i := range p.books {
+		feeMul := 1.0 - p.fees[i].TakerBp/10000.0
+		if t.Dirs[i] > 0 {
+			rate *= feeMul / (p.books[i].Asks[0].Price * (1.0 + s.SlippageBp/10000.0))
+		} else {
+			rate *= feeMul * p.books[i].Bids[0].Price * (1.0 - s.SlippageBp/10000.0)
+		}
+		rate *= s.Transfers.hopRate(t, markets, i)
+	}
+	return rate
+}
+
+// walk pushes amount through every leg's ladder. Each leg is floored to its
+// step size and its limit price moved onto the tick grid before the result
+// is handed to the next leg.
+func (s *DepthSimulator) walk(t types.Cycle, markets []types.Market, p *depthPath, amount float64) depthFill {
+	f := depthFill{legs: make([]types.Leg, t.Len()), fill: 1.0}
+	value := amount
+	for i := range p.books {
+		m := markets[t.MarketIds[i]]
+		feeMul := 1.0 - p.fees[i].TakerBp/10000.0
+		if t.Dirs[i] > 0 {
+			baseOut, quoteSpent, worstPx := walkAsks(p.books[i].Asks, value, s.SlippageBp)
+			f.fill *= quoteSpent / value
+			qty := floorToStep(baseOut, m.StepSize)
+			// Rounding only trims the last, worst-priced level touched.
+			quoteSpent -= (baseOut - qty) * worstPx
+			limit := roundToTick(worstPx, m.PriceTick, types.SideBuy)
+			if !meetsLotRules(m, qty, limit) {
+				return depthFill{}
+			}
+			f.legs[i] = types.Leg{Exchange: m.Exchange, Market: m.Symbol, Side: types.SideBuy, Qty: qty, LimitPrice: limit}
+			if i == 0 {
+				f.cost = quoteSpent
+			}
+			value = qty * feeMul
+		} else {
+			qty := floorToStep(value, m.StepSize)
+			if qty <= 0 {
+				return depthFill{}
+			}
+			quoteOut, baseSold, worstPx := walkBids(p.books[i].Bids, qty, s.SlippageBp)
+			f.fill *= baseSold / qty
+			limit := roundToTick(worstPx, m.PriceTick, types.SideSell)
+			if !meetsLotRules(m, baseSold, limit) {
+				return depthFill{}
+			}
+			f.legs[i] = types.Leg{Exchange: m.Exchange, Market: m.Symbol, Side: types.SideSell, Qty: baseSold, LimitPrice: limit}
+			if i == 0 {
+				f.cost = baseSold
+			}
+			value = quoteOut * feeMul
+		}
+		var ok bool
+		if value, ok = s.Transfers.hop(t, markets, i, value); !ok || value <= 0 {
+			return depthFill{}
+		}
+	}
+	f.value = value
+	f.valid = true
+	return f
+}
+
+// walkAsks spends up to quoteIn against an ascending ask ladder and returns the
+// base bought, the quote actually spent and the worst (slipped) price touched.
+func walkAsks(asks []types.Level, quoteIn, slippageBp float64) (baseOut, quoteSpent, worstPx float64) {
+	remaining := quoteIn
+	for _, lvl := range asks {
+		if remaining <= 0 {
+			break
+		}
+		if lvl.Price <= 0 || lvl.Qty <= 0 {
+			continue
+		}
+		px := lvl.Price * (1.0 + slippageBp/10000.0)
+		qty := remaining / px
+		if qty > lvl.Qty {
+			qty = lvl.Qty
+		}
+		baseOut += qty
+		quoteSpent += qty * px
+		remaining -= qty * px
+		worstPx = px
+	}
+	return baseOut, quoteSpent, worstPx
+}
+
+// walkBids sells up to baseIn into a descending bid ladder and returns the
+// quote received, the base actually sold and the worst (slipped) price touched.
+func walkBids(bids []types.Level, baseIn, slippageBp float64) (quoteOut, baseSold, worstPx float64) {
+	remaining := baseIn
+	for _, lvl := range bids {
+		if remaining <= 0 {
+			break
+		}
+		if lvl.Price <= 0 || lvl.Qty <= 0 {
+			continue
+		}
+		px := lvl.Price * (1.0 - slippageBp/10000.0)
+		qty := remaining
+		if qty > lvl.Qty {
+			qty = lvl.Qty
+		}
+		quoteOut += qty * px
+		baseSold += qty
+		remaining -= qty
+		worstPx = px
+	}
+	return quoteOut, baseSold, worstPx
+}
+
+func validLadder(levels []types.Level) bool {
+	return len(levels) > 0 && levels[0].Price > 0 && levels[0].Qty > 0
+}
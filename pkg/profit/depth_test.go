@@ -0,0 +1,187 @@
+package profit
+
+import (
+	"math"
+	"testing"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+func depthTestMarkets() []types.Market {
+	return []types.Market{
+		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+	}
+}
+
+func depthTestTriangle() types.Cycle {
+	return types.Cycle{
+		MarketIds: []int{0, 1, 2},
+		Dirs:      []int8{1, -1, -1},
+		QuoteCcy:  "USDT",
+	}
+}
+
+// Buy ETH for USDT, sell ETH for BTC, sell BTC for USDT: 3000 -> 0.0605 -> 50100
+// is roughly a 1% edge at the top of book.
+func depthTestBooks(ethAskQty float64) map[string]types.OrderBook {
+	return map[string]types.OrderBook{
+		"ETHUSDT": {
+			Bids: []types.Level{{Price: 2999.0, Qty: 10}},
+			Asks: []types.Level{{Price: 3000.0, Qty: ethAskQty}, {Price: 3100.0, Qty: 10}},
+		},
+		"ETHBTC": {
+			Bids: []types.Level{{Price: 0.0605, Qty: 100}},
+			Asks: []types.Level{{Price: 0.0606, Qty: 100}},
+		},
+		"BTCUSDT": {
+			Bids: []types.Level{{Price: 50100.0, Qty: 10}},
+			Asks: []types.Level{{Price: 50110.0, Qty: 10}},
+		},
+	}
+}
+
+func noFees(key types.MarketKey) (types.Fee, bool) { return types.Fee{}, true }
+
+func noTOB(key types.MarketKey) (types.TopOfBook, bool) { return types.TopOfBook{}, false }
+
+func TestWalkAsks(t *testing.T) {
+	asks := []types.Level{{Price: 100, Qty: 1}, {Price: 110, Qty: 2}}
+
+	baseOut, spent, worst := walkAsks(asks, 320, 0)
+	if math.Abs(baseOut-3) > 1e-9 {
+		t.Errorf("Expected to buy 3 units, got %f", baseOut)
+	}
+	if math.Abs(spent-320) > 1e-9 {
+		t.Errorf("Expected to spend 320, got %f", spent)
+	}
+	if worst != 110 {
+		t.Errorf("Expected worst price 110, got %f", worst)
+	}
+
+	// More quote than the ladder holds only spends what is available
+	baseOut, spent, _ = walkAsks(asks, 1000, 0)
+	if math.Abs(baseOut-3) > 1e-9 || math.Abs(spent-320) > 1e-9 {
+		t.Errorf("Expected ladder to cap at 3 units / 320 quote, got %f / %f", baseOut, spent)
+	}
+}
+
+func TestWalkBids(t *testing.T) {
+	bids := []types.Level{{Price: 100, Qty: 1}, {Price: 90, Qty: 2}}
+
+	quoteOut, sold, worst := walkBids(bids, 2, 0)
+	if math.Abs(quoteOut-190) > 1e-9 {
+		t.Errorf("Expected 190 quote, got %f", quoteOut)
+	}
+	if sold != 2 {
+		t.Errorf("Expected to sell 2 units, got %f", sold)
+	}
+	if worst != 90 {
+		t.Errorf("Expected worst price 90, got %f", worst)
+	}
+
+	// Slippage haircuts every level
+	quoteOut, _, worst = walkBids(bids, 1, 100)
+	if math.Abs(quoteOut-99) > 1e-9 || math.Abs(worst-99) > 1e-9 {
+		t.Errorf("Expected slipped fill at 99, got %f at %f", quoteOut, worst)
+	}
+}
+
+func TestDepthSimulatorUsesVWAP(t *testing.T) {
+	books := depthTestBooks(0.01)
+	sim := NewDepthSimulator(1.0, 0, 0, func(key types.MarketKey) (types.OrderBook, bool) {
+		ob, ok := books[key.Symbol]
+		return ob, ok
+	})
+
+	// 30 USDT fills entirely at the best ask
+	small, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 30)
+	if !ok {
+		t.Fatal("Expected a profitable plan at top-of-book size")
+	}
+
+	// 300 USDT has to walk into the 3100 level, which kills the edge
+	_, ok = sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 300)
+	if ok {
+		t.Error("Expected the second ask level to make the plan unprofitable")
+	}
+
+	if small.Legs[0].LimitPrice != 3000.0 {
+		t.Errorf("Expected limit price 3000, got %f", small.Legs[0].LimitPrice)
+	}
+	if small.Legs[0].Side != types.SideBuy || small.Legs[1].Side != types.SideSell || small.Legs[2].Side != types.SideSell {
+		t.Errorf("Unexpected leg sides: %+v", small.Legs)
+	}
+	if math.Abs(small.Legs[1].Qty-small.Legs[0].Qty) > 1e-12 {
+		t.Errorf("Expected the ETH bought to be sold on the next leg, got %f vs %f", small.Legs[0].Qty, small.Legs[1].Qty)
+	}
+	if dec := small.Decision; dec == nil || len(dec.Quotes) != 3 || dec.Quotes[0].AskPx != 3000.0 || dec.Quotes[0].AskSz != 0.01 || dec.Quotes[2].BidPx != 50100.0 || dec.MarketIds[1] != 1 {
+		t.Errorf("Expected the top of each book priced from, got %+v", small.Decision)
+	}
+}
+
+func TestDepthSimulatorShrinksToBook(t *testing.T) {
+	books := depthTestBooks(1)
+	// Thin BTCUSDT bid: only 0.005 BTC (~250 USDT) can be sold
+	books["BTCUSDT"] = types.OrderBook{
+		Bids: []types.Level{{Price: 50100.0, Qty: 0.005}},
+		Asks: []types.Level{{Price: 50110.0, Qty: 10}},
+	}
+	sim := NewDepthSimulator(1.0, 0, 0.5, func(key types.MarketKey) (types.OrderBook, bool) {
+		ob, ok := books[key.Symbol]
+		return ob, ok
+	})
+
+	plan, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 400)
+	if !ok {
+		t.Fatal("Expected the plan to be shrunk rather than dropped")
+	}
+	if plan.Legs[2].Qty > 0.005+1e-12 {
+		t.Errorf("Expected last leg capped at book size 0.005, got %f", plan.Legs[2].Qty)
+	}
+	spent := plan.Legs[0].Qty * plan.Legs[0].LimitPrice
+	if spent >= 400 || spent < 200 {
+		t.Errorf("Expected shrunk notional between 200 and 400, got %f", spent)
+	}
+	if plan.ExpectedProfitQuote <= 0 {
+		t.Errorf("Expected positive profit, got %f", plan.ExpectedProfitQuote)
+	}
+
+	// Requiring at least 90% of the target drops the plan
+	sim.MinFillRatio = 0.9
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 400); ok {
+		t.Error("Expected plan to be rejected below MinFillRatio")
+	}
+}
+
+func TestDepthSimulatorMissingBook(t *testing.T) {
+	books := depthTestBooks(1)
+	delete(books, "ETHBTC")
+	sim := NewDepthSimulator(1.0, 0, 0, func(key types.MarketKey) (types.OrderBook, bool) {
+		ob, ok := books[key.Symbol]
+		return ob, ok
+	})
+
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 30); ok {
+		t.Error("Expected no plan when a leg has no book")
+	}
+
+	books["ETHBTC"] = types.OrderBook{Asks: []types.Level{{Price: 0.0606, Qty: 1}}}
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 30); ok {
+		t.Error("Expected no plan when a leg has an empty side")
+	}
+}
+
+func TestDepthSimulatorAppliesFees(t *testing.T) {
+	books := depthTestBooks(1)
+	sim := NewDepthSimulator(1.0, 0, 0, func(key types.MarketKey) (types.OrderBook, bool) {
+		ob, ok := books[key.Symbol]
+		return ob, ok
+	})
+	highFees := func(key types.MarketKey) (types.Fee, bool) { return types.Fee{TakerBp: 50}, true }
+
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, highFees, 30); ok {
+		t.Error("Expected 1.5% in fees to wipe out a 1% edge")
+	}
+}
@@ -0,0 +1,96 @@
+package profit
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// Reasons a cycle is rejected for its quote timestamps.
+const (
+	RejectStale       = "stale"
+	RejectSkew        = "skew"
+	RejectNoTimestamp = "no_timestamp"
+)
+
+// Freshness rejects cycles priced from quotes that are too old, or taken too
+// far apart to have been on the books at the same time. Limits of zero are
+// off. A nil *Freshness accepts every cycle.
+type Freshness struct {
+	MaxAge         time.Duration
+	ExchangeMaxAge map[string]time.Duration // overrides MaxAge per exchange
+	MaxSkew        time.Duration
+	Now            func() time.Time
+
+	mu         sync.Mutex
+	rejections map[string]uint64
+}
+
+func NewFreshness(maxAge, maxSkew time.Duration) *Freshness {
+	return &Freshness{MaxAge: maxAge, ExchangeMaxAge: make(map[string]time.Duration), MaxSkew: maxSkew, Now: time.Now, rejections: make(map[string]uint64)}
+}
+
+func (f *Freshness) SetExchangeMaxAge(exchange string, maxAge time.Duration) {
+	f.ExchangeMaxAge[strings.ToUpper(exchange)] = maxAge
+}
+
+// Rejections returns how many cycles were rejected, by reason.
+func (f *Freshness) Rejections() map[string]uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	cp := make(map[string]uint64, len(f.rejections))
+	for k, v := range f.rejections {
+		cp[k] = v
+	}
+	return cp
+}
+
+// check reports whether the quotes of t's legs, taken at tsNs, are fresh
+// enough to trade together. Quotes stamped in the future count as fresh.
+func (f *Freshness) check(t types.Cycle, markets []types.Market, tsNs []int64) bool {
+	if f == nil {
+		return true
+	}
+	now := f.Now().UnixNano()
+	var oldest, newest int64
+	for i, ts := range tsNs {
+		maxAge := f.maxAge(markets[t.MarketIds[i]].Exchange)
+		if ts <= 0 {
+			if maxAge > 0 || f.MaxSkew > 0 {
+				f.reject(RejectNoTimestamp)
+				return false
+			}
+			continue
+		}
+		if maxAge > 0 && now-ts > int64(maxAge) {
+			f.reject(RejectStale)
+			return false
+		}
+		if oldest == 0 || ts < oldest {
+			oldest = ts
+		}
+		if ts > newest {
+			newest = ts
+		}
+	}
+	if f.MaxSkew > 0 && newest-oldest > int64(f.MaxSkew) {
+		f.reject(RejectSkew)
+		return false
+	}
+	return true
+}
+
+func (f *Freshness) maxAge(exchange string) time.Duration {
+	if d, ok := f.ExchangeMaxAge[strings.ToUpper(exchange)]; ok {
+		return d
+	}
+	return f.MaxAge
+}
+
+func (f *Freshness) reject(reason string) {
+	f.mu.Lock()
+	f.rejections[reason]++
+	f.mu.Unlock()
+}
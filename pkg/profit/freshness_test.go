@@ -0,0 +1,129 @@
+package profit
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+var freshnessTestNow = time.Unix(1700000000, 0)
+
+func newTestFreshness(maxAge, maxSkew time.Duration) *Freshness {
+	f := NewFreshness(maxAge, maxSkew)
+	f.Now = func() time.Time { return freshnessTestNow }
+	return f
+}
+
+// ago stamps a quote the given time before freshnessTestNow.
+func ago(d time.Duration) int64 {
+	return freshnessTestNow.Add(-d).UnixNano()
+}
+
+func TestFreshnessCheck(t *testing.T) {
+	markets := crossTestMarkets() // ETHBTC is on KUCOIN
+
+	tests := []struct {
+		name     string
+		maxAge   time.Duration
+		kucoin   time.Duration // KUCOIN override, 0 for none
+		maxSkew  time.Duration
+		ts       []int64
+		expected string // rejection reason, "" when accepted
+	}{
+		{"All fresh", time.Second, 0, 0, []int64{ago(100 * time.Millisecond), ago(900 * time.Millisecond), ago(0)}, ""},
+		{"One leg too old", time.Second, 0, 0, []int64{ago(0), ago(0), ago(2 * time.Second)}, RejectStale},
+		{"Exchange allows older quotes", time.Second, 5 * time.Second, 0, []int64{ago(0), ago(3 * time.Second), ago(0)}, ""},
+		{"Exchange limit still applies", time.Second, 5 * time.Second, 0, []int64{ago(0), ago(6 * time.Second), ago(0)}, RejectStale},
+		{"Exchange limit tighter than global", time.Minute, 100 * time.Millisecond, 0, []int64{ago(0), ago(time.Second), ago(0)}, RejectStale},
+		{"Legs too far apart", 0, 0, 500 * time.Millisecond, []int64{ago(time.Hour), ago(time.Hour + time.Second), ago(time.Hour)}, RejectSkew},
+		{"Legs close together", 0, 0, 500 * time.Millisecond, []int64{ago(time.Hour), ago(time.Hour + 400*time.Millisecond), ago(time.Hour)}, ""},
+		{"Missing timestamp", time.Second, 0, 0, []int64{ago(0), 0, ago(0)}, RejectNoTimestamp},
+		{"Missing timestamp with checks off", 0, 0, 0, []int64{ago(0), 0, ago(0)}, ""},
+		{"Future quotes are fresh", time.Second, 0, 0, []int64{ago(-time.Second), ago(0), ago(0)}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTestFreshness(tt.maxAge, tt.maxSkew)
+			if tt.kucoin > 0 {
+				f.SetExchangeMaxAge("kucoin", tt.kucoin)
+			}
+			ok := f.check(depthTestTriangle(), markets, tt.ts)
+			if ok != (tt.expected == "") {
+				t.Fatalf("Expected accepted=%v, got %v", tt.expected == "", ok)
+			}
+			expected := map[string]uint64{}
+			if tt.expected != "" {
+				expected[tt.expected] = 1
+			}
+			if got := f.Rejections(); !reflect.DeepEqual(got, expected) {
+				t.Errorf("Expected rejections %v, got %v", expected, got)
+			}
+		})
+	}
+
+	var none *Freshness
+	if !none.check(depthTestTriangle(), markets, []int64{0, 0, 0}) {
+		t.Error("Expected a nil guard to accept every cycle")
+	}
+}
+
+func TestTOBSimulatorRejectsStaleQuotes(t *testing.T) {
+	sim := NewTOBSimulator(1.0, 0)
+	sim.Freshness = newTestFreshness(time.Second, 0)
+
+	stamped := func(stale string) func(types.MarketKey) (types.TopOfBook, bool) {
+		return func(key types.MarketKey) (types.TopOfBook, bool) {
+			tob, ok := depthTestTOB(key)
+			tob.TsNs = ago(10 * time.Millisecond)
+			if key.Symbol == stale {
+				tob.TsNs = ago(time.Minute)
+			}
+			return tob, ok
+		}
+	}
+
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), stamped(""), noFees, 100); !ok {
+		t.Fatal("Expected a plan from fresh quotes")
+	}
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), stamped("ETHBTC"), noFees, 100); ok {
+		t.Error("Expected a stale ETHBTC quote to reject the cycle")
+	}
+	if got := sim.Freshness.Rejections(); got[RejectStale] != 1 {
+		t.Errorf("Expected 1 stale rejection, got %v", got)
+	}
+}
+
+func TestDepthSimulatorRejectsSkewedBooks(t *testing.T) {
+	books := depthTestBooks(10)
+	for symbol, ob := range books {
+		ob.TsNs = ago(0)
+		if symbol == "BTCUSDT" {
+			ob.TsNs = ago(2 * time.Second)
+		}
+		books[symbol] = ob
+	}
+	sim := NewDepthSimulator(1.0, 0, 0, func(key types.MarketKey) (types.OrderBook, bool) {
+		ob, ok := books[key.Symbol]
+		return ob, ok
+	})
+	sim.Freshness = newTestFreshness(0, time.Second)
+
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 100); ok {
+		t.Error("Expected books two seconds apart to reject the cycle")
+	}
+	opt := NewSizeOptimizer(sim, map[string]SizeBounds{"USDT": {Min: 20, Max: 500}})
+	if _, ok := opt.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 100); ok {
+		t.Error("Expected the optimizer to reject the same cycle")
+	}
+	if got := sim.Freshness.Rejections(); got[RejectSkew] != 2 {
+		t.Errorf("Expected 2 skew rejections, got %v", got)
+	}
+
+	sim.Freshness.MaxSkew = 3 * time.Second
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 100); !ok {
+		t.Error("Expected a wider skew limit to accept the cycle")
+	}
+}
@@ -0,0 +1,79 @@
+package profit
+
+import (
+	"math"
+	"strings"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// Inventory is what we hold, per venue and asset. Cycles are rotated so their
+// first leg spends a held asset, and sized no larger than its balance. A nil
+// *Inventory evaluates cycles as indexed, at the amount the caller passed.
+type Inventory struct {
+	Balances map[string]map[string]float64
+	// TradeAmounts is the amount to trade per start asset. A held asset
+	// missing here never starts a cycle.
+	TradeAmounts map[string]float64
+}
+
+func NewInventory() *Inventory {
+	return &Inventory{Balances: make(map[string]map[string]float64), TradeAmounts: make(map[string]float64)}
+}
+
+func (inv *Inventory) SetBalance(exchange, asset string, amount float64) {
+	ex := strings.ToUpper(exchange)
+	if inv.Balances[ex] == nil {
+		inv.Balances[ex] = make(map[string]float64)
+	}
+	inv.Balances[ex][strings.ToUpper(asset)] = amount
+}
+
+func (inv *Inventory) SetTradeAmount(asset string, amount float64) {
+	inv.TradeAmounts[strings.ToUpper(asset)] = amount
+}
+
+func (inv *Inventory) Balance(exchange, asset string) float64 {
+	return inv.Balances[strings.ToUpper(exchange)][strings.ToUpper(asset)]
+}
+
+// start rotates t to begin with the first leg, in cycle order, that spends
+// an asset we hold on that leg's venue. It returns the rotated cycle, the
+// amount to trade and the balance that amount is capped at. Cycles that
+// touch no held asset with a trade amount are dropped. target only sizes
+// cycles when inv is nil.
+func (inv *Inventory) start(t types.Cycle, markets []types.Market, target float64) (types.Cycle, float64, float64, bool) {
+	if inv == nil {
+		return t, target, math.Inf(1), true
+	}
+	for r := 0; r < t.Len(); r++ {
+		c := rotateCycle(t, markets, r)
+		asset := startAsset(c, markets)
+		balance := inv.Balance(markets[c.MarketIds[0]].Exchange, asset)
+		if balance <= 0 {
+			continue
+		}
+		// The caller's target is picked by the updated market's quote, not
+		// by this asset, and spending the whole balance instead would size
+		// the trade at random
+		amount, ok := inv.TradeAmounts[strings.ToUpper(asset)]
+		if !ok || amount <= 0 {
+			continue
+		}
+		return c, math.Min(amount, balance), balance, true
+	}
+	return t, 0, 0, false
+}
+
+// rotateCycle returns t starting at leg r. The legs and their directions are
+// unchanged, so the rotated cycle trades the same loop.
+func rotateCycle(t types.Cycle, markets []types.Market, r int) types.Cycle {
+	n := t.Len()
+	c := types.Cycle{MarketIds: make([]int, n), Dirs: make([]int8, n)}
+	for i := 0; i < n; i++ {
+		c.MarketIds[i] = t.MarketIds[(r+i)%n]
+		c.Dirs[i] = t.Dirs[(r+i)%n]
+	}
+	c.QuoteCcy = startAsset(c, markets)
+	return c
+}
@@ -0,0 +1,192 @@
+package profit
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+func depthTestTOB(key types.MarketKey) (types.TopOfBook, bool) {
+	ob, ok := depthTestBooks(10)[key.Symbol]
+	if !ok {
+		return types.TopOfBook{}, false
+	}
+	return types.TopOfBook{BidPx: ob.Bids[0].Price, BidSz: ob.Bids[0].Qty, AskPx: ob.Asks[0].Price, AskSz: ob.Asks[0].Qty}, true
+}
+
+func TestInventoryStart(t *testing.T) {
+	markets := depthTestMarkets()
+
+	tests := []struct {
+		name     string
+		balances map[string]float64 // BINANCE balances
+		amounts  map[string]float64
+		ok       bool
+		expected types.Cycle
+		amount   float64
+	}{
+		{
+			"Held start asset keeps the cycle",
+			map[string]float64{"USDT": 500, "BTC": 1},
+			map[string]float64{"USDT": 100},
+			true,
+			types.Cycle{MarketIds: []int{0, 1, 2}, Dirs: []int8{1, -1, -1}, QuoteCcy: "USDT"},
+			100,
+		},
+		{
+			"Rotates to the held asset",
+			map[string]float64{"BTC": 0.5},
+			map[string]float64{"btc": 0.01},
+			true,
+			types.Cycle{MarketIds: []int{2, 0, 1}, Dirs: []int8{-1, 1, -1}, QuoteCcy: "BTC"},
+			0.01,
+		},
+		{
+			"Caps the amount at the balance",
+			map[string]float64{"USDT": 40},
+			map[string]float64{"USDT": 100},
+			true,
+			types.Cycle{MarketIds: []int{0, 1, 2}, Dirs: []int8{1, -1, -1}, QuoteCcy: "USDT"},
+			40,
+		},
+		{
+			"Skips a rotated asset without a trade amount",
+			map[string]float64{"BTC": 5},
+			nil,
+			false,
+			types.Cycle{},
+			0,
+		},
+		{
+			"Rotates past an unsized asset to one with a trade amount",
+			map[string]float64{"ETH": 2, "BTC": 5},
+			map[string]float64{"BTC": 0.01},
+			true,
+			types.Cycle{MarketIds: []int{2, 0, 1}, Dirs: []int8{-1, 1, -1}, QuoteCcy: "BTC"},
+			0.01,
+		},
+		{
+			"Skips a held start asset without a trade amount",
+			map[string]float64{"USDT": 500},
+			nil,
+			false,
+			types.Cycle{},
+			0,
+		},
+		{"Zero balance is not held", map[string]float64{"USDT": 0}, map[string]float64{"USDT": 100}, false, types.Cycle{}, 0},
+		{"Nothing held", nil, nil, false, types.Cycle{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv := NewInventory()
+			for asset, amount := range tt.balances {
+				inv.SetBalance("binance", asset, amount)
+			}
+			for asset, amount := range tt.amounts {
+				inv.SetTradeAmount(asset, amount)
+			}
+			// Balances on another venue never count
+			inv.SetBalance("KUCOIN", "ETH", 10)
+
+			c, amount, _, ok := inv.start(depthTestTriangle(), markets, 100)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok=%v, got %v", tt.ok, ok)
+			}
+			if !ok {
+				return
+			}
+			if !reflect.DeepEqual(c, tt.expected) {
+				t.Errorf("Expected cycle %+v, got %+v", tt.expected, c)
+			}
+			if math.Abs(amount-tt.amount) > 1e-12 {
+				t.Errorf("Expected amount %v, got %v", tt.amount, amount)
+			}
+		})
+	}
+
+	// A cycle that already starts in BTC, priced after a USDT market moved:
+	// the caller's 100 is USDT and must not size the BTC leg
+	inv := NewInventory()
+	inv.SetBalance("BINANCE", "BTC", 0.5)
+	inv.SetTradeAmount("USDT", 100)
+	if c, amount, _, ok := inv.start(rotateCycle(depthTestTriangle(), markets, 2), markets, 100); ok {
+		t.Errorf("Expected a BTC start without a BTC trade amount to be skipped, got %+v sized %v", c, amount)
+	}
+
+	var none *Inventory
+	if c, amount, _, ok := none.start(depthTestTriangle(), markets, 100); !ok || amount != 100 || !reflect.DeepEqual(c, depthTestTriangle()) {
+		t.Error("Expected a nil inventory to leave the cycle and amount alone")
+	}
+}
+
+func TestTOBSimulatorStartsInHeldAsset(t *testing.T) {
+	sim := NewTOBSimulator(1.0, 0)
+	sim.Inventory = NewInventory()
+	sim.Inventory.SetBalance("BINANCE", "BTC", 0.01)
+	sim.Inventory.SetTradeAmount("BTC", 0.05)
+
+	plan, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), depthTestTOB, noFees, 100)
+	if !ok {
+		t.Fatal("Expected a profitable plan starting in BTC")
+	}
+	if plan.QuoteCurrency != "BTC" {
+		t.Errorf("Expected profit in BTC, got %s", plan.QuoteCurrency)
+	}
+	if plan.Legs[0].Market != "BTCUSDT" || plan.Legs[0].Side != types.SideSell || plan.Legs[0].Qty != 0.01 {
+		t.Errorf("Expected to start by selling the 0.01 BTC held, got %+v", plan.Legs[0])
+	}
+
+	// 0.01 BTC -> 501 USDT -> 0.167 ETH -> BTC
+	expected := 0.01*50100.0/3000.0*0.0605 - 0.01
+	if math.Abs(plan.ExpectedProfitQuote-expected) > 1e-12 {
+		t.Errorf("Expected profit %v BTC, got %v", expected, plan.ExpectedProfitQuote)
+	}
+
+	sim.Inventory = NewInventory()
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), depthTestTOB, noFees, 100); ok {
+		t.Error("Expected no plan without any inventory")
+	}
+}
+
+func TestDepthSimulatorCapsAtBalance(t *testing.T) {
+	books := depthTestBooks(10)
+	sim := NewDepthSimulator(1.0, 0, 0.5, func(key types.MarketKey) (types.OrderBook, bool) {
+		ob, ok := books[key.Symbol]
+		return ob, ok
+	})
+	sim.Inventory = NewInventory()
+	sim.Inventory.SetBalance("BINANCE", "USDT", 60)
+	sim.Inventory.SetTradeAmount("USDT", 1000)
+
+	plan, ok := sim.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 1000)
+	if !ok {
+		t.Fatal("Expected a profitable plan")
+	}
+	if spent := plan.Legs[0].Qty * 3000.0; math.Abs(spent-60) > 1e-9 {
+		t.Errorf("Expected to spend the 60 USDT held, got %f", spent)
+	}
+}
+
+func TestSizeOptimizerCapsAtBalance(t *testing.T) {
+	opt := newSizingTestOptimizer(map[string]SizeBounds{"USDT": {Min: 20, Max: 5000}})
+	opt.Depth.Inventory = NewInventory()
+	opt.Depth.Inventory.SetBalance("BINANCE", "USDT", 60)
+	opt.Depth.Inventory.SetTradeAmount("USDT", 100)
+
+	plan, ok := opt.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 100)
+	if !ok {
+		t.Fatal("Expected a profitable plan")
+	}
+	if spent := plan.Legs[0].Qty * 3000.0; spent > 60+1e-6 {
+		t.Errorf("Expected size capped at the 60 USDT held, got %f", spent)
+	}
+
+	// A balance below the minimum size leaves nothing to search
+	opt.Depth.Inventory.SetBalance("BINANCE", "USDT", 10)
+	if _, ok := opt.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 100); ok {
+		t.Error("Expected no plan when the balance is below the minimum size")
+	}
+}
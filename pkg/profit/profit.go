@@ -2,50 +2,62 @@ package profit
 
 import (
 	"math"
-	"strings"
 
 	"github.com/armagg/circular-arbitrage-finder/pkg/types"
 )
 
 
 type Simulator interface {
-	EvaluateTOB(t types.Triangle, markets []types.Market, tobBySymbol func(symbol string) (types.TopOfBook, bool), feesBySymbol func(symbol string) (types.Fee, bool), targetQuote float64) (types.Plan, bool)
+	EvaluateTOB(t types.Cycle, markets []types.Market, tobByMarket func(key types.MarketKey) (types.TopOfBook, bool), feesByMarket func(key types.MarketKey) (types.Fee, bool), targetQuote float64) (types.Plan, bool)
 }
 
 type TOBSimulator struct {
 	MinEdge    float64
 	SlippageBp float64
+	Transfers  *Transfers
+	Inventory  *Inventory
+	Freshness  *Freshness
 }
 
 func NewTOBSimulator(minEdge, slippageBp float64) *TOBSimulator {
 	return &TOBSimulator{MinEdge: minEdge, SlippageBp: slippageBp}
 }
 
-func (s *TOBSimulator) EvaluateTOB(t types.Triangle, markets []types.Market, tobBySymbol func(symbol string) (types.TopOfBook, bool), feesBySymbol func(symbol string) (types.Fee, bool), targetQuote float64) (types.Plan, bool) {
-
-	tob := make([]types.TopOfBook, 3)
-	fee := make([]types.Fee, 3)
-	symbols := make([]string, 3)
+func (s *TOBSimulator) EvaluateTOB(t types.Cycle, markets []types.Market, tobByMarket func(key types.MarketKey) (types.TopOfBook, bool), feesByMarket func(key types.MarketKey) (types.Fee, bool), targetQuote float64) (types.Plan, bool) {
+	if !validCycle(t, markets) {
+		return types.Plan{}, false
+	}
+	t, targetQuote, _, ok := s.Inventory.start(t, markets, targetQuote)
+	if !ok {
+		return types.Plan{}, false
+	}
+	n := t.Len()
+	tob := make([]types.TopOfBook, n)
+	fee := make([]types.Fee, n)
+	ts := make([]int64, n)
 	for i, mid := range t.MarketIds {
-		m := markets[mid]
-		symbols[i] = strings.ToUpper(m.Symbol)
-		v, ok := tobBySymbol(symbols[i])
+		key := markets[mid].Key()
+		v, ok := tobByMarket(key)
 		if !ok || v.BidPx <= 0 || v.AskPx <= 0 {
 			return types.Plan{}, false
 		}
 		tob[i] = v
-		f, ok := feesBySymbol(symbols[i])
+		ts[i] = v.TsNs
+		f, ok := feesByMarket(key)
 		if !ok {
 			f = types.Fee{}
 		}
 		fee[i] = f
 	}
+	if !s.Freshness.check(t, markets, ts) {
+		return types.Plan{}, false
+	}
 
 
 
 
 	rate := 1.0
-	for i := 0; i < 3; i++ {
+	for i := 0; i < n; i++ {
 		mid := t.MarketIds[i]
 		m := markets[mid]
 		if t.Dirs[i] > 0 {
@@ -61,6 +73,7 @@ func (s *TOBSimulator) EvaluateTOB(t types.Triangle, markets []types.Market, tob
 
 			rate *= px * feeMul
 		}
+		rate *= s.Transfers.hopRate(t, markets, i)
 		_ = m
 	}
 
@@ -70,27 +83,47 @@ func (s *TOBSimulator) EvaluateTOB(t types.Triangle, markets []types.Market, tob
 
 
 
-	legs := [3]types.TriangleLeg{}
+	// Quantities are floored to the step size and prices moved onto the tick
+	// grid, so each leg only carries what the previous one actually delivered.
+	legs := make([]types.Leg, n)
 	value := targetQuote
-	for i := 0; i < 3; i++ {
+	spent := 0.0
+	for i := 0; i < n; i++ {
 		m := markets[t.MarketIds[i]]
+		feeMul := 1.0 - fee[i].TakerBp/10000.0
 		if t.Dirs[i] > 0 {
-			px := tob[i].AskPx * (1.0 + s.SlippageBp/10000.0)
-			qty := value / px
-			legs[i] = types.TriangleLeg{Market: m.Symbol, Side: types.SideBuy, Qty: qty, LimitPrice: px}
-
-			value = qty
+			px := roundToTick(tob[i].AskPx*(1.0+s.SlippageBp/10000.0), m.PriceTick, types.SideBuy)
+			qty := floorToStep(value/px, m.StepSize)
+			if !meetsLotRules(m, qty, px) {
+				return types.Plan{}, false
+			}
+			legs[i] = types.Leg{Exchange: m.Exchange, Market: m.Symbol, Side: types.SideBuy, Qty: qty, LimitPrice: px}
+			if i == 0 {
+				spent = qty * px
+			}
+			value = qty * feeMul
 		} else {
-			px := tob[i].BidPx * (1.0 - s.SlippageBp/10000.0)
-			qty := value
-			legs[i] = types.TriangleLeg{Market: m.Symbol, Side: types.SideSell, Qty: qty, LimitPrice: px}
-
-			value = qty * px
+			px := roundToTick(tob[i].BidPx*(1.0-s.SlippageBp/10000.0), m.PriceTick, types.SideSell)
+			qty := floorToStep(value, m.StepSize)
+			if !meetsLotRules(m, qty, px) {
+				return types.Plan{}, false
+			}
+			legs[i] = types.Leg{Exchange: m.Exchange, Market: m.Symbol, Side: types.SideSell, Qty: qty, LimitPrice: px}
+			if i == 0 {
+				spent = qty
+			}
+			value = qty * px * feeMul
+		}
+		var ok bool
+		if value, ok = s.Transfers.hop(t, markets, i, value); !ok {
+			return types.Plan{}, false
 		}
 	}
 
-	expectedProfit := value - targetQuote
-	if !isFinite(expectedProfit) || expectedProfit <= 0 {
+	// Rounding eats into the edge, so MinEdge is checked again on what the
+	// rounded legs actually spend and deliver.
+	expectedProfit := value - spent
+	if spent <= 0 || !isFinite(expectedProfit) || expectedProfit <= 0 || value/spent <= s.MinEdge {
 		return types.Plan{}, false
 	}
 
@@ -98,14 +131,47 @@ func (s *TOBSimulator) EvaluateTOB(t types.Triangle, markets []types.Market, tob
 		Exchange:            markets[t.MarketIds[0]].Exchange,
 		Legs:                legs,
 		ExpectedProfitQuote: expectedProfit,
-		QuoteCurrency:       markets[t.MarketIds[0]].Quote,
+		QuoteCurrency:       startAsset(t, markets),
 		ValidMs:             250,
 		MaxSlippageBp:       s.SlippageBp,
 		PlanID:              "",
+		Decision:            legDecision(t, tob, fee),
 	}
 	return plan, true
 }
 
+// legDecision records the quote and fee each leg of t was priced from, so
+// the plan carries exactly what the simulator saw.
+func legDecision(t types.Cycle, quotes []types.TopOfBook, fees []types.Fee) *types.Decision {
+	return &types.Decision{
+		MarketIds: append([]int(nil), t.MarketIds...),
+		Quotes:    quotes,
+		Fees:      fees,
+	}
+}
+
+// validCycle reports whether every leg of t points at a known market.
+func validCycle(t types.Cycle, markets []types.Market) bool {
+	if t.Len() < 2 || len(t.Dirs) != t.Len() {
+		return false
+	}
+	for _, mid := range t.MarketIds {
+		if mid < 0 || mid >= len(markets) {
+			return false
+		}
+	}
+	return true
+}
+
+// startAsset is what the first leg spends, and what profit is measured in.
+func startAsset(t types.Cycle, markets []types.Market) string {
+	m := markets[t.MarketIds[0]]
+	if t.Dirs[0] > 0 {
+		return m.Quote
+	}
+	return m.Base
+}
+
 func isFinite(v float64) bool {
 	return !math.IsNaN(v) && !math.IsInf(v, 0)
 }
@@ -63,16 +63,16 @@ func TestTOBSimulatorEvaluateTOB(t *testing.T) {
 	}
 
 	// Create test triangle
-	triangle := types.Triangle{
-		MarketIds: [3]int{0, 1, 2},
-		Dirs:      [3]int8{1, -1, -1},
+	triangle := types.Cycle{
+		MarketIds: []int{0, 1, 2},
+		Dirs:      []int8{1, -1, -1},
 		QuoteCcy:  "USDT",
 	}
 
 	// Create mock functions with prices that should create arbitrage
 	// Use prices that create a clear arbitrage opportunity
-	tobBySymbol := func(symbol string) (types.TopOfBook, bool) {
-		switch symbol {
+	tobByMarket := func(key types.MarketKey) (types.TopOfBook, bool) {
+		switch key.Symbol {
 		case "BTCUSDT":
 			return types.TopOfBook{BidPx: 50000.0, AskPx: 50000.0, BidSz: 2.1, AskSz: 1.8}, true
 		case "ETHBTC":
@@ -84,13 +84,13 @@ func TestTOBSimulatorEvaluateTOB(t *testing.T) {
 		}
 	}
 
-	feeBySymbol := func(symbol string) (types.Fee, bool) {
+	feeByMarket := func(key types.MarketKey) (types.Fee, bool) {
 		return types.Fee{TakerBp: 0.1, MakerBp: 0.05}, true
 	}
 
 	targetQuote := 1000.0
 
-	plan, found := sim.EvaluateTOB(triangle, markets, tobBySymbol, feeBySymbol, targetQuote)
+	plan, found := sim.EvaluateTOB(triangle, markets, tobByMarket, feeByMarket, targetQuote)
 
 	// The function should execute without panicking
 	// Arbitrage detection may or may not find profit depending on exact calculations
@@ -111,6 +111,47 @@ func TestTOBSimulatorEvaluateTOB(t *testing.T) {
 	}
 }
 
+func TestTOBSimulatorRecordsLegSnapshot(t *testing.T) {
+	sim := NewTOBSimulator(1.0, 0)
+	markets := []types.Market{
+		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+	}
+	quotes := map[string]types.TopOfBook{
+		"ETHUSDT": {BidPx: 2999.0, AskPx: 3000.0},
+		"ETHBTC":  {BidPx: 0.0605, AskPx: 0.0606},
+		"BTCUSDT": {BidPx: 50100.0, AskPx: 50110.0},
+	}
+	// Every read sees a newer book, as a live store would
+	var seq uint64
+	tobByMarket := func(key types.MarketKey) (types.TopOfBook, bool) {
+		seq++
+		q := quotes[key.Symbol]
+		q.Seq = seq
+		return q, true
+	}
+	feeByMarket := func(key types.MarketKey) (types.Fee, bool) {
+		return types.Fee{TakerBp: float64(len(key.Symbol))}, true
+	}
+
+	plan, ok := sim.EvaluateTOB(types.Cycle{MarketIds: []int{0, 1, 2}, Dirs: []int8{1, -1, -1}, QuoteCcy: "USDT"}, markets, tobByMarket, feeByMarket, 1000.0)
+	if !ok {
+		t.Fatal("Expected a profitable plan")
+	}
+	dec := plan.Decision
+	if dec == nil || len(dec.Quotes) != 3 || len(dec.Fees) != 3 {
+		t.Fatalf("Expected a snapshot per leg, got %+v", dec)
+	}
+	for i, l := range plan.Legs {
+		want := quotes[l.Market]
+		want.Seq = uint64(i + 1)
+		if dec.Quotes[i] != want || dec.Fees[i].TakerBp != float64(len(l.Market)) || dec.MarketIds[i] != i {
+			t.Errorf("Leg %d: expected the quote read while pricing %+v, got %+v %+v", i, want, dec.Quotes[i], dec.Fees[i])
+		}
+	}
+}
+
 func TestTOBSimulatorEvaluateTOBNoProfit(t *testing.T) {
 	sim := NewTOBSimulator(0.01, 5.0) // High minimum edge
 
@@ -120,15 +161,15 @@ func TestTOBSimulatorEvaluateTOBNoProfit(t *testing.T) {
 		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
 	}
 
-	triangle := types.Triangle{
-		MarketIds: [3]int{0, 1, 2},
-		Dirs:      [3]int8{1, -1, -1},
+	triangle := types.Cycle{
+		MarketIds: []int{0, 1, 2},
+		Dirs:      []int8{1, -1, -1},
 		QuoteCcy:  "USDT",
 	}
 
 	// Prices that result in no profit
-	tobBySymbol := func(symbol string) (types.TopOfBook, bool) {
-		switch symbol {
+	tobByMarket := func(key types.MarketKey) (types.TopOfBook, bool) {
+		switch key.Symbol {
 		case "BTCUSDT":
 			return types.TopOfBook{BidPx: 50000.0, AskPx: 50000.0, BidSz: 2.1, AskSz: 1.8}, true
 		case "ETHBTC":
@@ -140,13 +181,13 @@ func TestTOBSimulatorEvaluateTOBNoProfit(t *testing.T) {
 		}
 	}
 
-	feeBySymbol := func(symbol string) (types.Fee, bool) {
+	feeByMarket := func(key types.MarketKey) (types.Fee, bool) {
 		return types.Fee{TakerBp: 0.1, MakerBp: 0.05}, true
 	}
 
 	targetQuote := 1000.0
 
-	_, found := sim.EvaluateTOB(triangle, markets, tobBySymbol, feeBySymbol, targetQuote)
+	_, found := sim.EvaluateTOB(triangle, markets, tobByMarket, feeByMarket, targetQuote)
 
 	if found {
 		t.Error("Expected no profitable arbitrage to be found")
@@ -160,27 +201,27 @@ func TestTOBSimulatorEvaluateTOBMissingData(t *testing.T) {
 		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
 	}
 
-	triangle := types.Triangle{
-		MarketIds: [3]int{0, 1, 2},
-		Dirs:      [3]int8{1, -1, -1},
+	triangle := types.Cycle{
+		MarketIds: []int{0, 1, 2},
+		Dirs:      []int8{1, -1, -1},
 		QuoteCcy:  "USDT",
 	}
 
 	// Mock function that returns missing data
-	tobBySymbol := func(symbol string) (types.TopOfBook, bool) {
-		if symbol == "BTCUSDT" {
+	tobByMarket := func(key types.MarketKey) (types.TopOfBook, bool) {
+		if key.Symbol == "BTCUSDT" {
 			return types.TopOfBook{BidPx: 0, AskPx: 0}, true // Invalid prices
 		}
 		return types.TopOfBook{}, false
 	}
 
-	feeBySymbol := func(symbol string) (types.Fee, bool) {
+	feeByMarket := func(key types.MarketKey) (types.Fee, bool) {
 		return types.Fee{TakerBp: 0.1, MakerBp: 0.05}, true
 	}
 
 	targetQuote := 1000.0
 
-	_, found := sim.EvaluateTOB(triangle, markets, tobBySymbol, feeBySymbol, targetQuote)
+	_, found := sim.EvaluateTOB(triangle, markets, tobByMarket, feeByMarket, targetQuote)
 
 	if found {
 		t.Error("Expected no arbitrage when data is missing or invalid")
@@ -196,15 +237,15 @@ func TestTOBSimulatorComplexArbitrage(t *testing.T) {
 		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
 	}
 
-	triangle := types.Triangle{
-		MarketIds: [3]int{0, 1, 2},
-		Dirs:      [3]int8{1, -1, -1},
+	triangle := types.Cycle{
+		MarketIds: []int{0, 1, 2},
+		Dirs:      []int8{1, -1, -1},
 		QuoteCcy:  "USDT",
 	}
 
 	// Realistic prices that should create arbitrage opportunity
-	tobBySymbol := func(symbol string) (types.TopOfBook, bool) {
-		switch symbol {
+	tobByMarket := func(key types.MarketKey) (types.TopOfBook, bool) {
+		switch key.Symbol {
 		case "BTCUSDT":
 			return types.TopOfBook{BidPx: 49800.0, AskPx: 49850.0, BidSz: 2.1, AskSz: 1.8}, true
 		case "ETHBTC":
@@ -216,13 +257,13 @@ func TestTOBSimulatorComplexArbitrage(t *testing.T) {
 		}
 	}
 
-	feeBySymbol := func(symbol string) (types.Fee, bool) {
+	feeByMarket := func(key types.MarketKey) (types.Fee, bool) {
 		return types.Fee{TakerBp: 0.08, MakerBp: 0.04}, true
 	}
 
 	targetQuote := 1000.0
 
-	plan, found := sim.EvaluateTOB(triangle, markets, tobBySymbol, feeBySymbol, targetQuote)
+	plan, found := sim.EvaluateTOB(triangle, markets, tobByMarket, feeByMarket, targetQuote)
 
 	// The function should execute without panicking
 	t.Logf("Complex arbitrage found: %v, profit: %f", found, plan.ExpectedProfitQuote)
@@ -273,23 +314,23 @@ func TestTOBSimulatorTriangleDirections(t *testing.T) {
 	// Test different triangle directions
 	testCases := []struct {
 		name       string
-		directions [3]int8
+		directions []int8
 	}{
-		{"Forward arbitrage", [3]int8{1, -1, -1}},
-		{"Reverse arbitrage", [3]int8{-1, 1, 1}},
-		{"Mixed directions", [3]int8{1, 1, -1}},
+		{"Forward arbitrage", []int8{1, -1, -1}},
+		{"Reverse arbitrage", []int8{-1, 1, 1}},
+		{"Mixed directions", []int8{1, 1, -1}},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			triangle := types.Triangle{
-				MarketIds: [3]int{0, 1, 2},
+			triangle := types.Cycle{
+				MarketIds: []int{0, 1, 2},
 				Dirs:      tc.directions,
 				QuoteCcy:  "USDT",
 			}
 
-			tobBySymbol := func(symbol string) (types.TopOfBook, bool) {
-				switch symbol {
+			tobByMarket := func(key types.MarketKey) (types.TopOfBook, bool) {
+				switch key.Symbol {
 				case "BTCUSDT":
 					return types.TopOfBook{BidPx: 50000.0, AskPx: 50010.0}, true
 				case "ETHBTC":
@@ -301,11 +342,11 @@ func TestTOBSimulatorTriangleDirections(t *testing.T) {
 				}
 			}
 
-			feeBySymbol := func(symbol string) (types.Fee, bool) {
+			feeByMarket := func(key types.MarketKey) (types.Fee, bool) {
 				return types.Fee{TakerBp: 0.1, MakerBp: 0.05}, true
 			}
 
-			_, found := sim.EvaluateTOB(triangle, markets, tobBySymbol, feeBySymbol, 1000.0)
+			_, found := sim.EvaluateTOB(triangle, markets, tobByMarket, feeByMarket, 1000.0)
 
 			// We don't assert on 'found' since different directions may or may not be profitable
 			// The important thing is that the function doesn't panic and returns a valid result
@@ -324,15 +365,15 @@ func TestTOBSimulatorEdgeCases(t *testing.T) {
 		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
 	}
 
-	triangle := types.Triangle{
-		MarketIds: [3]int{0, 1, 2},
-		Dirs:      [3]int8{1, -1, -1},
+	triangle := types.Cycle{
+		MarketIds: []int{0, 1, 2},
+		Dirs:      []int8{1, -1, -1},
 		QuoteCcy:  "USDT",
 	}
 
 	t.Run("Zero prices", func(t *testing.T) {
-		tobBySymbol := func(symbol string) (types.TopOfBook, bool) {
-			switch symbol {
+		tobByMarket := func(key types.MarketKey) (types.TopOfBook, bool) {
+			switch key.Symbol {
 			case "BTCUSDT":
 				return types.TopOfBook{BidPx: 0, AskPx: 0}, true
 			case "ETHBTC":
@@ -343,19 +384,19 @@ func TestTOBSimulatorEdgeCases(t *testing.T) {
 				return types.TopOfBook{}, false
 			}
 		}
-		feeBySymbol := func(symbol string) (types.Fee, bool) {
+		feeByMarket := func(key types.MarketKey) (types.Fee, bool) {
 			return types.Fee{TakerBp: 0.1, MakerBp: 0.05}, true
 		}
 
-		_, found := sim.EvaluateTOB(triangle, markets, tobBySymbol, feeBySymbol, 1000.0)
+		_, found := sim.EvaluateTOB(triangle, markets, tobByMarket, feeByMarket, 1000.0)
 		if found {
 			t.Error("Should not find arbitrage with zero prices")
 		}
 	})
 
 	t.Run("Negative prices", func(t *testing.T) {
-		tobBySymbol := func(symbol string) (types.TopOfBook, bool) {
-			switch symbol {
+		tobByMarket := func(key types.MarketKey) (types.TopOfBook, bool) {
+			switch key.Symbol {
 			case "BTCUSDT":
 				return types.TopOfBook{BidPx: -100, AskPx: -90}, true
 			case "ETHBTC":
@@ -366,11 +407,11 @@ func TestTOBSimulatorEdgeCases(t *testing.T) {
 				return types.TopOfBook{}, false
 			}
 		}
-		feeBySymbol := func(symbol string) (types.Fee, bool) {
+		feeByMarket := func(key types.MarketKey) (types.Fee, bool) {
 			return types.Fee{TakerBp: 0.1, MakerBp: 0.05}, true
 		}
 
-		_, found := sim.EvaluateTOB(triangle, markets, tobBySymbol, feeBySymbol, 1000.0)
+		_, found := sim.EvaluateTOB(triangle, markets, tobByMarket, feeByMarket, 1000.0)
 		if found {
 			t.Error("Should not find arbitrage with negative prices")
 		}
@@ -380,8 +421,8 @@ func TestTOBSimulatorEdgeCases(t *testing.T) {
 		// This tests the isFinite check
 		simHighSlippage := NewTOBSimulator(0.001, 100.0) // Very high slippage
 
-		tobBySymbol := func(symbol string) (types.TopOfBook, bool) {
-			switch symbol {
+		tobByMarket := func(key types.MarketKey) (types.TopOfBook, bool) {
+			switch key.Symbol {
 			case "BTCUSDT":
 				return types.TopOfBook{BidPx: 1e-100, AskPx: 1e100}, true // Extreme values
 			case "ETHBTC":
@@ -392,16 +433,105 @@ func TestTOBSimulatorEdgeCases(t *testing.T) {
 				return types.TopOfBook{}, false
 			}
 		}
-		feeBySymbol := func(symbol string) (types.Fee, bool) {
+		feeByMarket := func(key types.MarketKey) (types.Fee, bool) {
 			return types.Fee{TakerBp: 0.1, MakerBp: 0.05}, true
 		}
 
-		_, found := simHighSlippage.EvaluateTOB(triangle, markets, tobBySymbol, feeBySymbol, 1000.0)
+		_, found := simHighSlippage.EvaluateTOB(triangle, markets, tobByMarket, feeByMarket, 1000.0)
 		// The function should handle infinite results gracefully
 		t.Logf("Infinite result test: profitable = %v", found)
 	})
 }
 
+// USDT -> BTC -> ETH -> USDC -> USDT: ETH is 3000 USDT through BTC but
+// bid at 3030 USDC, a 1% edge that needs four hops.
+func fourLegTestMarkets() []types.Market {
+	return []types.Market{
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC"},
+		{Exchange: "binance", Symbol: "ETHUSDC", Base: "ETH", Quote: "USDC"},
+		{Exchange: "binance", Symbol: "USDCUSDT", Base: "USDC", Quote: "USDT"},
+	}
+}
+
+func fourLegTestCycle() types.Cycle {
+	return types.Cycle{
+		MarketIds: []int{0, 1, 2, 3},
+		Dirs:      []int8{1, 1, -1, -1},
+		QuoteCcy:  "USDT",
+	}
+}
+
+func fourLegTestTOB(key types.MarketKey) (types.TopOfBook, bool) {
+	switch key.Symbol {
+	case "BTCUSDT":
+		return types.TopOfBook{BidPx: 49990, AskPx: 50000, BidSz: 10, AskSz: 10}, true
+	case "ETHBTC":
+		return types.TopOfBook{BidPx: 0.0599, AskPx: 0.06, BidSz: 100, AskSz: 100}, true
+	case "ETHUSDC":
+		return types.TopOfBook{BidPx: 3030, AskPx: 3031, BidSz: 10, AskSz: 10}, true
+	case "USDCUSDT":
+		return types.TopOfBook{BidPx: 1.0, AskPx: 1.0001, BidSz: 1e6, AskSz: 1e6}, true
+	}
+	return types.TopOfBook{}, false
+}
+
+func TestTOBSimulatorFourLegCycle(t *testing.T) {
+	sim := NewTOBSimulator(1.0, 0)
+
+	plan, ok := sim.EvaluateTOB(fourLegTestCycle(), fourLegTestMarkets(), fourLegTestTOB, noFees, 1000)
+	if !ok {
+		t.Fatal("Expected a profitable four-leg plan")
+	}
+	if len(plan.Legs) != 4 {
+		t.Fatalf("Expected 4 legs, got %d", len(plan.Legs))
+	}
+
+	expectedSides := []types.Side{types.SideBuy, types.SideBuy, types.SideSell, types.SideSell}
+	for i, leg := range plan.Legs {
+		if leg.Side != expectedSides[i] {
+			t.Errorf("Leg %d: expected %s, got %s", i, expectedSides[i], leg.Side)
+		}
+	}
+	if math.Abs(plan.ExpectedProfitQuote-10) > 1e-6 {
+		t.Errorf("Expected profit 10 USDT, got %f", plan.ExpectedProfitQuote)
+	}
+	if plan.QuoteCurrency != "USDT" {
+		t.Errorf("Expected USDT quote currency, got %s", plan.QuoteCurrency)
+	}
+
+	// A mismatched direction list is rejected rather than read out of range
+	broken := fourLegTestCycle()
+	broken.Dirs = broken.Dirs[:3]
+	if _, ok := sim.EvaluateTOB(broken, fourLegTestMarkets(), fourLegTestTOB, noFees, 1000); ok {
+		t.Error("Expected a cycle with missing directions to be rejected")
+	}
+}
+
+func TestDepthSimulatorFourLegCycle(t *testing.T) {
+	sim := NewDepthSimulator(1.0, 0, 0, func(key types.MarketKey) (types.OrderBook, bool) {
+		tob, ok := fourLegTestTOB(key)
+		if !ok {
+			return types.OrderBook{}, false
+		}
+		return types.OrderBook{
+			Bids: []types.Level{{Price: tob.BidPx, Qty: tob.BidSz}},
+			Asks: []types.Level{{Price: tob.AskPx, Qty: tob.AskSz}},
+		}, true
+	})
+
+	plan, ok := sim.EvaluateTOB(fourLegTestCycle(), fourLegTestMarkets(), noTOB, noFees, 1000)
+	if !ok {
+		t.Fatal("Expected a profitable four-leg plan")
+	}
+	if len(plan.Legs) != 4 || plan.Legs[3].Market != "USDCUSDT" {
+		t.Errorf("Expected the last leg on USDCUSDT, got %+v", plan.Legs)
+	}
+	if math.Abs(plan.ExpectedProfitQuote-10) > 1e-6 {
+		t.Errorf("Expected profit 10 USDT, got %f", plan.ExpectedProfitQuote)
+	}
+}
+
 // Benchmark tests
 func BenchmarkTOBSimulatorEvaluateTOB(b *testing.B) {
 	sim := NewTOBSimulator(0.001, 5.0)
@@ -412,14 +542,14 @@ func BenchmarkTOBSimulatorEvaluateTOB(b *testing.B) {
 		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
 	}
 
-	triangle := types.Triangle{
-		MarketIds: [3]int{0, 1, 2},
-		Dirs:      [3]int8{1, -1, -1},
+	triangle := types.Cycle{
+		MarketIds: []int{0, 1, 2},
+		Dirs:      []int8{1, -1, -1},
 		QuoteCcy:  "USDT",
 	}
 
-	tobBySymbol := func(symbol string) (types.TopOfBook, bool) {
-		switch symbol {
+	tobByMarket := func(key types.MarketKey) (types.TopOfBook, bool) {
+		switch key.Symbol {
 		case "BTCUSDT":
 			return types.TopOfBook{BidPx: 50000.0, AskPx: 50010.0}, true
 		case "ETHBTC":
@@ -431,7 +561,7 @@ func BenchmarkTOBSimulatorEvaluateTOB(b *testing.B) {
 		}
 	}
 
-	feeBySymbol := func(symbol string) (types.Fee, bool) {
+	feeByMarket := func(key types.MarketKey) (types.Fee, bool) {
 		return types.Fee{TakerBp: 0.1, MakerBp: 0.05}, true
 	}
 
@@ -439,7 +569,7 @@ func BenchmarkTOBSimulatorEvaluateTOB(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		sim.EvaluateTOB(triangle, markets, tobBySymbol, feeBySymbol, targetQuote)
+		sim.EvaluateTOB(triangle, markets, tobByMarket, feeByMarket, targetQuote)
 	}
 }
 
@@ -0,0 +1,60 @@
+package profit
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// floorToStep rounds qty down to a multiple of step. A zero step means the
+// market has no lot rule and qty is returned unchanged.
+func floorToStep(qty, step float64) float64 {
+	if step <= 0 {
+		return qty
+	}
+	n := math.Floor(qty/step + 1e-9)
+	return roundToIncrement(n*step, step)
+}
+
+// roundToTick moves px onto the tick grid on the side that keeps the order
+// marketable: buys round up, sells round down.
+func roundToTick(px, tick float64, side types.Side) float64 {
+	if tick <= 0 {
+		return px
+	}
+	var n float64
+	if side == types.SideBuy {
+		n = math.Ceil(px/tick - 1e-9)
+	} else {
+		n = math.Floor(px/tick + 1e-9)
+	}
+	return roundToIncrement(n*tick, tick)
+}
+
+// meetsLotRules reports whether a leg of qty at px is accepted by the market.
+func meetsLotRules(m types.Market, qty, px float64) bool {
+	if qty <= 0 || px <= 0 {
+		return false
+	}
+	if m.MinQty > 0 && qty < m.MinQty-1e-12 {
+		return false
+	}
+	if m.MinNotional > 0 && qty*px < m.MinNotional-1e-12 {
+		return false
+	}
+	return true
+}
+
+// roundToIncrement trims float noise such as 0.30000000000000004 by rounding
+// v to the number of decimals used by inc.
+func roundToIncrement(v, inc float64) float64 {
+	s := strconv.FormatFloat(inc, 'f', -1, 64)
+	dot := strings.IndexByte(s, '.')
+	if dot < 0 {
+		return math.Round(v)
+	}
+	p := math.Pow(10, float64(len(s)-dot-1))
+	return math.Round(v*p) / p
+}
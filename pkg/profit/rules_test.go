@@ -0,0 +1,182 @@
+package profit
+
+import (
+	"math"
+	"testing"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+func TestFloorToStep(t *testing.T) {
+	tests := []struct {
+		name     string
+		qty      float64
+		step     float64
+		expected float64
+	}{
+		{"No step", 0.0332778, 0, 0.0332778},
+		{"BTC step", 0.0332778, 0.0001, 0.0332},
+		{"Exact multiple", 0.3, 0.1, 0.3},
+		{"Whole units", 17.9, 1, 17},
+		{"Below one step", 0.00005, 0.0001, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := floorToStep(tt.qty, tt.step)
+			if result != tt.expected {
+				t.Errorf("floorToStep(%v, %v) = %v, expected %v", tt.qty, tt.step, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRoundToTick(t *testing.T) {
+	tests := []struct {
+		name     string
+		px       float64
+		tick     float64
+		side     types.Side
+		expected float64
+	}{
+		{"No tick", 50012.345, 0, types.SideBuy, 50012.345},
+		{"Buy rounds up", 50012.341, 0.01, types.SideBuy, 50012.35},
+		{"Sell rounds down", 50012.349, 0.01, types.SideSell, 50012.34},
+		{"On grid buy", 0.060512, 0.000001, types.SideBuy, 0.060512},
+		{"On grid sell", 0.060512, 0.000001, types.SideSell, 0.060512},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := roundToTick(tt.px, tt.tick, tt.side)
+			if result != tt.expected {
+				t.Errorf("roundToTick(%v, %v, %s) = %v, expected %v", tt.px, tt.tick, tt.side, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMeetsLotRules(t *testing.T) {
+	m := types.Market{Symbol: "BTCUSDT", MinQty: 0.001, MinNotional: 10}
+
+	if !meetsLotRules(m, 0.001, 50000) {
+		t.Error("Expected minimum qty above min notional to pass")
+	}
+	if meetsLotRules(m, 0.0009, 50000) {
+		t.Error("Expected qty below MinQty to fail")
+	}
+	if meetsLotRules(m, 0.001, 5000) {
+		t.Error("Expected notional below MinNotional to fail")
+	}
+	if !meetsLotRules(types.Market{}, 1e-12, 1) {
+		t.Error("Expected a market without rules to accept any positive qty")
+	}
+	if meetsLotRules(types.Market{}, 0, 1) {
+		t.Error("Expected zero qty to fail")
+	}
+}
+
+func lotTestMarkets() []types.Market {
+	return []types.Market{
+		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT", MinQty: 0.001, StepSize: 0.001, MinNotional: 10, PriceTick: 0.01},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC", MinQty: 0.001, StepSize: 0.001, MinNotional: 0.0001, PriceTick: 0.000001},
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT", MinQty: 0.00001, StepSize: 0.00001, MinNotional: 10, PriceTick: 0.01},
+	}
+}
+
+func lotTestTOB(key types.MarketKey) (types.TopOfBook, bool) {
+	switch key.Symbol {
+	case "ETHUSDT":
+		return types.TopOfBook{BidPx: 2999.0, AskPx: 3000.0, BidSz: 10, AskSz: 10}, true
+	case "ETHBTC":
+		return types.TopOfBook{BidPx: 0.0605, AskPx: 0.0606, BidSz: 100, AskSz: 100}, true
+	case "BTCUSDT":
+		return types.TopOfBook{BidPx: 50100.0, AskPx: 50110.0, BidSz: 10, AskSz: 10}, true
+	}
+	return types.TopOfBook{}, false
+}
+
+func assertLegsOnGrid(t *testing.T, plan types.Plan, markets []types.Market) {
+	t.Helper()
+	for i, leg := range plan.Legs {
+		m := markets[i]
+		if q := leg.Qty / m.StepSize; math.Abs(q-math.Round(q)) > 1e-6 {
+			t.Errorf("Leg %d qty %v is not a multiple of step %v", i, leg.Qty, m.StepSize)
+		}
+		if p := leg.LimitPrice / m.PriceTick; math.Abs(p-math.Round(p)) > 1e-6 {
+			t.Errorf("Leg %d price %v is not on tick %v", i, leg.LimitPrice, m.PriceTick)
+		}
+		if leg.Qty < m.MinQty || leg.Qty*leg.LimitPrice < m.MinNotional {
+			t.Errorf("Leg %d violates minimums: qty %v notional %v", i, leg.Qty, leg.Qty*leg.LimitPrice)
+		}
+	}
+}
+
+func TestTOBSimulatorEnforcesLotRules(t *testing.T) {
+	sim := NewTOBSimulator(1.0, 3.0)
+	markets := lotTestMarkets()
+
+	plan, ok := sim.EvaluateTOB(depthTestTriangle(), markets, lotTestTOB, noFees, 100)
+	if !ok {
+		t.Fatal("Expected a profitable plan")
+	}
+	assertLegsOnGrid(t, plan, markets)
+
+	// Quantities flow from leg to leg: ETH bought is what gets sold for BTC
+	if plan.Legs[1].Qty > plan.Legs[0].Qty {
+		t.Errorf("Leg 2 sells %v ETH but only %v was bought", plan.Legs[1].Qty, plan.Legs[0].Qty)
+	}
+
+	// 5 USDT is below the 10 USDT min notional of the first leg
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), markets, lotTestTOB, noFees, 5); ok {
+		t.Error("Expected plan below min notional to be dropped")
+	}
+}
+
+func TestTOBSimulatorChecksEdgeAfterRounding(t *testing.T) {
+	markets := []types.Market{
+		{Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT", StepSize: 0.0001},
+		{Exchange: "binance", Symbol: "ETHBTC", Base: "ETH", Quote: "BTC", StepSize: 0.0001},
+		{Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT", StepSize: 0.00005},
+	}
+	tob := func(key types.MarketKey) (types.TopOfBook, bool) {
+		switch key.Symbol {
+		case "ETHUSDT":
+			return types.TopOfBook{BidPx: 2999.0, AskPx: 3000.0}, true
+		case "ETHBTC":
+			return types.TopOfBook{BidPx: 0.0605, AskPx: 0.0606}, true
+		case "BTCUSDT":
+			return types.TopOfBook{BidPx: 49700.0, AskPx: 49710.0}, true
+		}
+		return types.TopOfBook{}, false
+	}
+
+	// 0.0605 * 49700 / 3000 is a 0.228% edge before rounding; flooring the
+	// BTC leg to 0.00005 leaves about 0.155%
+	plan, ok := NewTOBSimulator(1.001, 0).EvaluateTOB(depthTestTriangle(), markets, tob, noFees, 1000)
+	if !ok || plan.Edge() <= 0.001 || plan.Edge() >= 0.002 {
+		t.Fatalf("Expected a plan with an edge between 0.1%% and 0.2%%, got %v %f", ok, plan.Edge())
+	}
+	if _, ok := NewTOBSimulator(1.002, 0).EvaluateTOB(depthTestTriangle(), markets, tob, noFees, 1000); ok {
+		t.Error("Expected a plan whose rounded edge falls below MinEdge to be dropped")
+	}
+}
+
+func TestDepthSimulatorEnforcesLotRules(t *testing.T) {
+	books := depthTestBooks(1)
+	sim := NewDepthSimulator(1.0, 3.0, 0, func(key types.MarketKey) (types.OrderBook, bool) {
+		ob, ok := books[key.Symbol]
+		return ob, ok
+	})
+	markets := lotTestMarkets()
+
+	plan, ok := sim.EvaluateTOB(depthTestTriangle(), markets, noTOB, noFees, 100)
+	if !ok {
+		t.Fatal("Expected a profitable plan")
+	}
+	assertLegsOnGrid(t, plan, markets)
+
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), markets, noTOB, noFees, 5); ok {
+		t.Error("Expected plan below min notional to be dropped")
+	}
+}
@@ -0,0 +1,119 @@
+package profit
+
+import (
+	"math"
+	"strings"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// defaultSizeIterations is enough golden-section steps to narrow a range to
+// about 1e-7 of its width.
+const defaultSizeIterations = 32
+
+var invPhi = (math.Sqrt(5) - 1) / 2
+
+// SizeBounds limits the notional, in the cycle's start asset, that the
+// optimizer may choose.
+type SizeBounds struct {
+	Min float64
+	Max float64
+}
+
+// SizeOptimizer searches the trade size with the highest absolute profit
+// instead of evaluating a single fixed amount. Cycles whose start asset has no
+// bounds configured are evaluated at targetQuote like the DepthSimulator. The
+// search never goes above the balance held when Depth has an Inventory.
+type SizeOptimizer struct {
+	Depth      *DepthSimulator
+	Bounds     map[string]SizeBounds
+	Iterations int
+}
+
+func NewSizeOptimizer(depth *DepthSimulator, bounds map[string]SizeBounds) *SizeOptimizer {
+	b := make(map[string]SizeBounds, len(bounds))
+	for q, v := range bounds {
+		b[strings.ToUpper(q)] = v
+	}
+	return &SizeOptimizer{Depth: depth, Bounds: b, Iterations: defaultSizeIterations}
+}
+
+func (s *SizeOptimizer) EvaluateTOB(t types.Cycle, markets []types.Market, tobByMarket func(key types.MarketKey) (types.TopOfBook, bool), feesByMarket func(key types.MarketKey) (types.Fee, bool), targetQuote float64) (types.Plan, bool) {
+	if !validCycle(t, markets) {
+		return types.Plan{}, false
+	}
+	t, amount, balance, ok := s.Depth.Inventory.start(t, markets, targetQuote)
+	if !ok {
+		return types.Plan{}, false
+	}
+	bounds, ok := s.Bounds[strings.ToUpper(startAsset(t, markets))]
+	if !ok || bounds.Max <= 0 || bounds.Max < bounds.Min {
+		return s.Depth.EvaluateTOB(t, markets, tobByMarket, feesByMarket, amount)
+	}
+	bounds.Max = math.Min(bounds.Max, balance)
+
+	d := s.Depth
+	p, ok := d.load(t, markets, feesByMarket)
+	if !ok || d.bestRate(t, markets, p) <= d.MinEdge {
+		return types.Plan{}, false
+	}
+
+	// Never search beyond what the books can absorb.
+	hi, _, ok := d.fit(t, markets, p, bounds.Max)
+	if !ok || hi < bounds.Min {
+		return types.Plan{}, false
+	}
+	lo := math.Max(bounds.Min, 0)
+
+	size := s.search(t, markets, p, lo, hi)
+	f := d.walk(t, markets, p, size)
+	if !f.valid || f.fill < 1.0-1e-9 {
+		return types.Plan{}, false
+	}
+	return d.makePlan(t, markets, p, f)
+}
+
+// search runs a golden-section search for the most profitable size in
+// [lo, hi]. Walking a ladder only ever worsens the marginal price, so profit
+// is concave in size (up to lot rounding) and the search converges on the
+// global maximum.
+func (s *SizeOptimizer) search(t types.Cycle, markets []types.Market, p *depthPath, lo, hi float64) float64 {
+	profitAt := func(amount float64) float64 {
+		if amount <= 0 {
+			return 0
+		}
+		f := s.Depth.walk(t, markets, p, amount)
+		if !f.valid || f.fill < 1.0-1e-9 {
+			return math.Inf(-1)
+		}
+		return f.value - f.cost
+	}
+
+	iterations := s.Iterations
+	if iterations <= 0 {
+		iterations = defaultSizeIterations
+	}
+	a, b := lo, hi
+	c := b - invPhi*(b-a)
+	d := a + invPhi*(b-a)
+	fc, fd := profitAt(c), profitAt(d)
+	for i := 0; i < iterations; i++ {
+		if fc >= fd {
+			b, d, fd = d, c, fc
+			c = b - invPhi*(b-a)
+			fc = profitAt(c)
+		} else {
+			a, c, fc = c, d, fd
+			d = a + invPhi*(b-a)
+			fd = profitAt(d)
+		}
+	}
+
+	best, bestProfit := (a+b)/2, profitAt((a+b)/2)
+	for _, x := range []float64{lo, hi} {
+		if px := profitAt(x); px > bestProfit {
+			best, bestProfit = x, px
+		}
+	}
+	return best
+}
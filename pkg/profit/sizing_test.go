@@ -0,0 +1,85 @@
+package profit
+
+import (
+	"math"
+	"testing"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// Profit grows through the 3000 and 3020 ask levels and turns negative once
+// the 3100 level is touched, so the best size is exactly 0.1 ETH (301 USDT).
+func sizingTestBooks() map[string]types.OrderBook {
+	books := depthTestBooks(0.05)
+	books["ETHUSDT"] = types.OrderBook{
+		Bids: []types.Level{{Price: 2999.0, Qty: 10}},
+		Asks: []types.Level{{Price: 3000.0, Qty: 0.05}, {Price: 3020.0, Qty: 0.05}, {Price: 3100.0, Qty: 10}},
+	}
+	return books
+}
+
+func newSizingTestOptimizer(bounds map[string]SizeBounds) *SizeOptimizer {
+	books := sizingTestBooks()
+	depth := NewDepthSimulator(1.0, 0, 0, func(key types.MarketKey) (types.OrderBook, bool) {
+		ob, ok := books[key.Symbol]
+		return ob, ok
+	})
+	return NewSizeOptimizer(depth, bounds)
+}
+
+func TestSizeOptimizerFindsBestSize(t *testing.T) {
+	opt := newSizingTestOptimizer(map[string]SizeBounds{"usdt": {Min: 20, Max: 5000}})
+
+	plan, ok := opt.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 100)
+	if !ok {
+		t.Fatal("Expected a profitable plan")
+	}
+	if math.Abs(plan.Legs[0].Qty-0.1) > 1e-4 {
+		t.Errorf("Expected optimizer to buy ~0.1 ETH, got %f", plan.Legs[0].Qty)
+	}
+
+	// The optimum must beat both the configured minimum and the fixed amount
+	fixed, ok := opt.Depth.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 100)
+	if !ok {
+		t.Fatal("Expected the fixed-size plan to be profitable")
+	}
+	if plan.ExpectedProfitQuote <= fixed.ExpectedProfitQuote {
+		t.Errorf("Expected optimized profit %f to exceed fixed-size profit %f", plan.ExpectedProfitQuote, fixed.ExpectedProfitQuote)
+	}
+}
+
+func TestSizeOptimizerRespectsMax(t *testing.T) {
+	opt := newSizingTestOptimizer(map[string]SizeBounds{"USDT": {Min: 20, Max: 60}})
+
+	plan, ok := opt.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 100)
+	if !ok {
+		t.Fatal("Expected a profitable plan")
+	}
+	spent := plan.Legs[0].Qty * 3000.0
+	if spent > 60+1e-6 {
+		t.Errorf("Expected size capped at 60, got %f", spent)
+	}
+	if spent < 59 {
+		t.Errorf("Expected the whole 60 to be used on a profitable level, got %f", spent)
+	}
+}
+
+func TestSizeOptimizerMinAboveCapacity(t *testing.T) {
+	opt := newSizingTestOptimizer(map[string]SizeBounds{"USDT": {Min: 1e9, Max: 2e9}})
+
+	if _, ok := opt.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 100); ok {
+		t.Error("Expected no plan when the books cannot reach the minimum size")
+	}
+}
+
+func TestSizeOptimizerWithoutBounds(t *testing.T) {
+	opt := newSizingTestOptimizer(nil)
+
+	plan, ok := opt.EvaluateTOB(depthTestTriangle(), depthTestMarkets(), noTOB, noFees, 30)
+	if !ok {
+		t.Fatal("Expected a profitable plan")
+	}
+	if math.Abs(plan.Legs[0].Qty-0.01) > 1e-9 {
+		t.Errorf("Expected fallback to the 30 USDT target, got %f ETH", plan.Legs[0].Qty)
+	}
+}
@@ -0,0 +1,110 @@
+package profit
+
+import (
+	"strings"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+// TransferCost is what moving an asset between venues costs: a flat
+// withdrawal fee in units of the asset plus a proportional part in bp.
+type TransferCost struct {
+	Fixed float64
+	Bp    float64
+}
+
+// Transfers prices the hops of a cross-exchange cycle. Assets without a
+// configured cost are treated as immovable, so cycles that need them are
+// dropped. A nil *Transfers only allows cycles that stay on one venue.
+type Transfers struct {
+	Assets    map[string]TransferCost
+	LatencyBp map[[2]string]float64
+}
+
+func NewTransfers() *Transfers {
+	return &Transfers{Assets: make(map[string]TransferCost), LatencyBp: make(map[[2]string]float64)}
+}
+
+func (tr *Transfers) SetAsset(asset string, c TransferCost) {
+	tr.Assets[strings.ToUpper(asset)] = c
+}
+
+// SetLatency sets the penalty charged for the time an asset spends in
+// flight between two venues. It applies in both directions.
+func (tr *Transfers) SetLatency(venueA, venueB string, bp float64) {
+	tr.LatencyBp[venuePair(venueA, venueB)] = bp
+}
+
+// Apply returns what is left of amount after moving it from one venue to
+// another. Moves within a venue are free.
+func (tr *Transfers) Apply(asset, from, to string, amount float64) (float64, bool) {
+	if strings.EqualFold(from, to) {
+		return amount, true
+	}
+	rate, fixed, ok := tr.cost(asset, from, to)
+	if !ok {
+		return 0, false
+	}
+	out := amount*rate - fixed
+	if out <= 0 {
+		return 0, false
+	}
+	return out, true
+}
+
+func (tr *Transfers) cost(asset, from, to string) (rate, fixed float64, ok bool) {
+	if tr == nil {
+		return 0, 0, false
+	}
+	c, ok := tr.Assets[strings.ToUpper(asset)]
+	if !ok {
+		return 0, 0, false
+	}
+	bp := c.Bp + tr.LatencyBp[venuePair(from, to)]
+	return 1.0 - bp/10000.0, c.Fixed, true
+}
+
+// hop moves what leg i delivered onto the venue of the next leg. After the
+// last leg the proceeds go back to the venue the cycle started on.
+func (tr *Transfers) hop(t types.Cycle, markets []types.Market, i int, amount float64) (float64, bool) {
+	asset, from, to := hopRoute(t, markets, i)
+	return tr.Apply(asset, from, to, amount)
+}
+
+// Rate is the share of an asset left after moving it between venues,
+// ignoring the fixed fee, so it never understates what a move keeps. It is
+// false for assets that cannot be moved.
+func (tr *Transfers) Rate(asset, from, to string) (float64, bool) {
+	if strings.EqualFold(from, to) {
+		return 1.0, true
+	}
+	rate, _, ok := tr.cost(asset, from, to)
+	return rate, ok
+}
+
+// hopRate is the proportional part of hop, used by the top-of-book prefilters.
+func (tr *Transfers) hopRate(t types.Cycle, markets []types.Market, i int) float64 {
+	rate, ok := tr.Rate(hopRoute(t, markets, i))
+	if !ok {
+		return 0
+	}
+	return rate
+}
+
+func hopRoute(t types.Cycle, markets []types.Market, i int) (asset, from, to string) {
+	m := markets[t.MarketIds[i]]
+	next := markets[t.MarketIds[(i+1)%t.Len()]]
+	asset = m.Quote
+	if t.Dirs[i] > 0 {
+		asset = m.Base
+	}
+	return asset, m.Exchange, next.Exchange
+}
+
+func venuePair(a, b string) [2]string {
+	a, b = strings.ToUpper(a), strings.ToUpper(b)
+	if b < a {
+		a, b = b, a
+	}
+	return [2]string{a, b}
+}
@@ -0,0 +1,146 @@
+package profit
+
+import (
+	"math"
+	"testing"
+
+	"github.com/armagg/circular-arbitrage-finder/pkg/types"
+)
+
+func crossTestTransfers() *Transfers {
+	tr := NewTransfers()
+	tr.SetAsset("eth", TransferCost{Fixed: 0.001, Bp: 0})
+	tr.SetAsset("BTC", TransferCost{Fixed: 0, Bp: 2})
+	tr.SetAsset("USDT", TransferCost{Fixed: 1, Bp: 0})
+	tr.SetLatency("KUCOIN", "binance", 3)
+	return tr
+}
+
+// Same triangle as depthTestMarkets, with the ETHBTC leg on another venue:
+// ETH moves BINANCE -> KUCOIN, BTC moves KUCOIN -> BINANCE.
+func crossTestMarkets() []types.Market {
+	markets := depthTestMarkets()
+	markets[1].Exchange = "kucoin"
+	return markets
+}
+
+func crossTestTOB(key types.MarketKey) (types.TopOfBook, bool) {
+	switch key.Symbol {
+	case "ETHUSDT":
+		return types.TopOfBook{BidPx: 2999.0, AskPx: 3000.0, BidSz: 10, AskSz: 10}, true
+	case "ETHBTC":
+		return types.TopOfBook{BidPx: 0.0605, AskPx: 0.0606, BidSz: 100, AskSz: 100}, true
+	case "BTCUSDT":
+		return types.TopOfBook{BidPx: 50100.0, AskPx: 50110.0, BidSz: 10, AskSz: 10}, true
+	}
+	return types.TopOfBook{}, false
+}
+
+func TestTransfersApply(t *testing.T) {
+	tr := crossTestTransfers()
+
+	tests := []struct {
+		name     string
+		asset    string
+		from     string
+		to       string
+		amount   float64
+		expected float64
+		ok       bool
+	}{
+		{"Same venue is free", "XRP", "binance", "BINANCE", 10, 10, true},
+		{"Fixed fee plus latency", "ETH", "BINANCE", "KUCOIN", 1, 1*(1-3.0/10000) - 0.001, true},
+		{"Latency is symmetric", "BTC", "KUCOIN", "BINANCE", 1, 1 - 5.0/10000, true},
+		{"No latency configured", "USDT", "BINANCE", "OKX", 100, 99, true},
+		{"Unknown asset", "XRP", "BINANCE", "KUCOIN", 10, 0, false},
+		{"Fee eats everything", "USDT", "BINANCE", "OKX", 0.5, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tr.Apply(tt.asset, tt.from, tt.to, tt.amount)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok=%v, got %v", tt.ok, ok)
+			}
+			if math.Abs(got-tt.expected) > 1e-12 {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+
+	var none *Transfers
+	if _, ok := none.Apply("BTC", "BINANCE", "KUCOIN", 1); ok {
+		t.Error("Expected a nil model to refuse cross-venue moves")
+	}
+	if got, ok := none.Apply("BTC", "BINANCE", "BINANCE", 1); !ok || got != 1 {
+		t.Error("Expected a nil model to allow moves within a venue")
+	}
+}
+
+func TestTOBSimulatorCrossExchange(t *testing.T) {
+	markets := crossTestMarkets()
+	sim := NewTOBSimulator(1.0, 0)
+
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), markets, crossTestTOB, noFees, 1000); ok {
+		t.Error("Expected a cross-venue cycle to be dropped without a transfer model")
+	}
+
+	sim.Transfers = crossTestTransfers()
+	plan, ok := sim.EvaluateTOB(depthTestTriangle(), markets, crossTestTOB, noFees, 1000)
+	if !ok {
+		t.Fatal("Expected a profitable cross-exchange plan")
+	}
+
+	wantVenues := []string{"binance", "kucoin", "binance"}
+	for i, leg := range plan.Legs {
+		if leg.Exchange != wantVenues[i] {
+			t.Errorf("Leg %d: expected exchange %s, got %s", i, wantVenues[i], leg.Exchange)
+		}
+	}
+
+	// The ETH hop costs 0.001 ETH and 3bp, the BTC hop 5bp
+	eth := 1000.0 / 3000.0
+	eth = eth*(1-3.0/10000) - 0.001
+	btc := eth * 0.0605 * (1 - 5.0/10000)
+	expected := btc*50100.0 - 1000.0
+	if math.Abs(plan.ExpectedProfitQuote-expected) > 1e-6 {
+		t.Errorf("Expected profit %f after transfer costs, got %f", expected, plan.ExpectedProfitQuote)
+	}
+
+	same, ok := NewTOBSimulator(1.0, 0).EvaluateTOB(depthTestTriangle(), depthTestMarkets(), crossTestTOB, noFees, 1000)
+	if !ok || same.ExpectedProfitQuote <= plan.ExpectedProfitQuote {
+		t.Error("Expected transfer costs to reduce profit against the single-venue cycle")
+	}
+}
+
+func TestDepthSimulatorCrossExchange(t *testing.T) {
+	books := depthTestBooks(10)
+	sim := NewDepthSimulator(1.0, 0, 0, func(key types.MarketKey) (types.OrderBook, bool) {
+		ob, ok := books[key.Symbol]
+		return ob, ok
+	})
+	markets := crossTestMarkets()
+
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), markets, noTOB, noFees, 1000); ok {
+		t.Error("Expected a cross-venue cycle to be dropped without a transfer model")
+	}
+
+	sim.Transfers = crossTestTransfers()
+	plan, ok := sim.EvaluateTOB(depthTestTriangle(), markets, noTOB, noFees, 1000)
+	if !ok {
+		t.Fatal("Expected a profitable cross-exchange plan")
+	}
+	if plan.Legs[1].Exchange != "kucoin" {
+		t.Errorf("Expected the second leg on kucoin, got %s", plan.Legs[1].Exchange)
+	}
+	// The ETH hop's fixed fee leaves less to sell on the second venue
+	if plan.Legs[1].Qty >= plan.Legs[0].Qty-0.001+1e-12 {
+		t.Errorf("Expected leg 2 to sell less than leg 1 bought minus the transfer fee, got %f vs %f", plan.Legs[1].Qty, plan.Legs[0].Qty)
+	}
+
+	// A latency penalty that wipes out the ~1% edge drops the cycle
+	sim.Transfers.SetLatency("BINANCE", "KUCOIN", 100)
+	if _, ok := sim.EvaluateTOB(depthTestTriangle(), markets, noTOB, noFees, 1000); ok {
+		t.Error("Expected latency penalty to make the cycle unprofitable")
+	}
+}
@@ -8,52 +8,52 @@ import (
 
 type MarketRegistry struct {
 	mu      sync.RWMutex
-	markets map[string]types.Market
-	fees    map[string]types.Fee
+	markets map[types.MarketKey]types.Market
+	fees    map[types.MarketKey]types.Fee
 }
 
 func NewMarketRegistry() *MarketRegistry {
 	return &MarketRegistry{
-		markets: make(map[string]types.Market),
-		fees:    make(map[string]types.Fee),
+		markets: make(map[types.MarketKey]types.Market),
+		fees:    make(map[types.MarketKey]types.Fee),
 	}
 }
 
 func (r *MarketRegistry) UpsertMarket(m types.Market) {
 	r.mu.Lock()
-	r.markets[m.Symbol] = m
+	r.markets[m.Key()] = m
 	r.mu.Unlock()
 }
 
-func (r *MarketRegistry) GetMarket(symbol string) (types.Market, bool) {
+func (r *MarketRegistry) GetMarket(key types.MarketKey) (types.Market, bool) {
 	r.mu.RLock()
-	m, ok := r.markets[symbol]
+	m, ok := r.markets[key]
 	r.mu.RUnlock()
 	return m, ok
 }
 
-func (r *MarketRegistry) SetFee(symbol string, f types.Fee) {
+func (r *MarketRegistry) SetFee(key types.MarketKey, f types.Fee) {
 	r.mu.Lock()
-	r.fees[symbol] = f
+	r.fees[key] = f
 	r.mu.Unlock()
 }
 
-func (r *MarketRegistry) GetFee(symbol string) (types.Fee, bool) {
+func (r *MarketRegistry) GetFee(key types.MarketKey) (types.Fee, bool) {
 	r.mu.RLock()
-	f, ok := r.fees[symbol]
+	f, ok := r.fees[key]
 	r.mu.RUnlock()
 	return f, ok
 }
 
 
-func (r *MarketRegistry) Snapshot() (map[string]types.Market, map[string]types.Fee) {
+func (r *MarketRegistry) Snapshot() (map[types.MarketKey]types.Market, map[types.MarketKey]types.Fee) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
-	m := make(map[string]types.Market, len(r.markets))
+	m := make(map[types.MarketKey]types.Market, len(r.markets))
 	for k, v := range r.markets {
 		m[k] = v
 	}
-	f := make(map[string]types.Fee, len(r.fees))
+	f := make(map[types.MarketKey]types.Fee, len(r.fees))
 	for k, v := range r.fees {
 		f[k] = v
 	}
@@ -49,7 +49,7 @@ func TestMarketRegistryUpsertMarket(t *testing.T) {
 	reg.UpsertMarket(market)
 
 	// Verify the market was stored
-	retrieved, exists := reg.GetMarket("BTCUSDT")
+	retrieved, exists := reg.GetMarket(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("Expected market to exist after upsert")
 	}
@@ -87,7 +87,7 @@ func TestMarketRegistryUpsertMarketUpdate(t *testing.T) {
 	reg.UpsertMarket(market2)
 
 	// Verify the update
-	retrieved, exists := reg.GetMarket(symbol)
+	retrieved, exists := reg.GetMarket(types.NewMarketKey("binance", symbol))
 	if !exists {
 		t.Error("Expected market to exist after update")
 	}
@@ -101,7 +101,7 @@ func TestMarketRegistryGetMarket(t *testing.T) {
 	reg := NewMarketRegistry()
 
 	// Test getting non-existent market
-	_, exists := reg.GetMarket("NONEXISTENT")
+	_, exists := reg.GetMarket(types.NewMarketKey("binance", "NONEXISTENT"))
 	if exists {
 		t.Error("GetMarket should return false for non-existent market")
 	}
@@ -116,7 +116,7 @@ func TestMarketRegistryGetMarket(t *testing.T) {
 
 	reg.UpsertMarket(market)
 
-	retrieved, exists := reg.GetMarket("BTCUSDT")
+	retrieved, exists := reg.GetMarket(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("GetMarket should return true for existing market")
 	}
@@ -134,10 +134,10 @@ func TestMarketRegistrySetFee(t *testing.T) {
 		MakerBp: 0.05,
 	}
 
-	reg.SetFee("BTCUSDT", fee)
+	reg.SetFee(types.NewMarketKey("binance", "BTCUSDT"), fee)
 
 	// Verify the fee was stored
-	retrieved, exists := reg.GetFee("BTCUSDT")
+	retrieved, exists := reg.GetFee(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("Expected fee to exist after SetFee")
 	}
@@ -151,7 +151,7 @@ func TestMarketRegistryGetFee(t *testing.T) {
 	reg := NewMarketRegistry()
 
 	// Test getting non-existent fee
-	_, exists := reg.GetFee("NONEXISTENT")
+	_, exists := reg.GetFee(types.NewMarketKey("binance", "NONEXISTENT"))
 	if exists {
 		t.Error("GetFee should return false for non-existent fee")
 	}
@@ -162,9 +162,9 @@ func TestMarketRegistryGetFee(t *testing.T) {
 		MakerBp: 0.04,
 	}
 
-	reg.SetFee("BTCUSDT", fee)
+	reg.SetFee(types.NewMarketKey("binance", "BTCUSDT"), fee)
 
-	retrieved, exists := reg.GetFee("BTCUSDT")
+	retrieved, exists := reg.GetFee(types.NewMarketKey("binance", "BTCUSDT"))
 	if !exists {
 		t.Error("GetFee should return true for existing fee")
 	}
@@ -178,22 +178,22 @@ func TestMarketRegistrySnapshot(t *testing.T) {
 	reg := NewMarketRegistry()
 
 	// Add some test data
-	markets := map[string]types.Market{
-		"BTCUSDT": {Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
-		"ETHUSDT": {Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
+	markets := map[types.MarketKey]types.Market{
+		types.NewMarketKey("binance", "BTCUSDT"): {Exchange: "binance", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT"},
+		types.NewMarketKey("binance", "ETHUSDT"): {Exchange: "binance", Symbol: "ETHUSDT", Base: "ETH", Quote: "USDT"},
 	}
 
-	fees := map[string]types.Fee{
-		"BTCUSDT": {TakerBp: 0.1, MakerBp: 0.05},
-		"ETHUSDT": {TakerBp: 0.08, MakerBp: 0.04},
+	fees := map[types.MarketKey]types.Fee{
+		types.NewMarketKey("binance", "BTCUSDT"): {TakerBp: 0.1, MakerBp: 0.05},
+		types.NewMarketKey("binance", "ETHUSDT"): {TakerBp: 0.08, MakerBp: 0.04},
 	}
 
 	for _, market := range markets {
 		reg.UpsertMarket(market)
 	}
 
-	for symbol, fee := range fees {
-		reg.SetFee(symbol, fee)
+	for key, fee := range fees {
+		reg.SetFee(key, fee)
 	}
 
 	marketSnapshot, feeSnapshot := reg.Snapshot()
@@ -206,23 +206,23 @@ func TestMarketRegistrySnapshot(t *testing.T) {
 		t.Errorf("Fee snapshot should have %d items, got %d", len(fees), len(feeSnapshot))
 	}
 
-	for symbol, expectedMarket := range markets {
-		actualMarket, exists := marketSnapshot[symbol]
+	for key, expectedMarket := range markets {
+		actualMarket, exists := marketSnapshot[key]
 		if !exists {
-			t.Errorf("Market snapshot should contain symbol %s", symbol)
+			t.Errorf("Market snapshot should contain market %s", key)
 		}
 		if actualMarket != expectedMarket {
-			t.Errorf("Market snapshot data for %s should match original", symbol)
+			t.Errorf("Market snapshot data for %s should match original", key)
 		}
 	}
 
-	for symbol, expectedFee := range fees {
-		actualFee, exists := feeSnapshot[symbol]
+	for key, expectedFee := range fees {
+		actualFee, exists := feeSnapshot[key]
 		if !exists {
-			t.Errorf("Fee snapshot should contain symbol %s", symbol)
+			t.Errorf("Fee snapshot should contain market %s", key)
 		}
 		if actualFee != expectedFee {
-			t.Errorf("Fee snapshot data for %s should match original", symbol)
+			t.Errorf("Fee snapshot data for %s should match original", key)
 		}
 	}
 }
@@ -264,7 +264,7 @@ func TestMarketRegistryConcurrency(t *testing.T) {
 				}
 				reg.UpsertMarket(market)
 
-				_, _ = reg.GetMarket(symbol)
+				_, _ = reg.GetMarket(types.NewMarketKey("binance", symbol))
 			}
 		}(i)
 	}
@@ -280,9 +280,9 @@ func TestMarketRegistryConcurrency(t *testing.T) {
 					TakerBp: float64(id*j) * 0.001,
 					MakerBp: float64(id*j) * 0.0005,
 				}
-				reg.SetFee(symbol, fee)
+				reg.SetFee(types.NewMarketKey("binance", symbol), fee)
 
-				_, _ = reg.GetFee(symbol)
+				_, _ = reg.GetFee(types.NewMarketKey("binance", symbol))
 			}
 		}(i)
 	}
@@ -321,12 +321,12 @@ func TestMarketRegistryDataIntegrity(t *testing.T) {
 	}
 
 	reg.UpsertMarket(market)
-	reg.SetFee(symbol, fee)
+	reg.SetFee(types.NewMarketKey("binance", symbol), fee)
 
 	// Perform multiple reads
 	for i := 0; i < 10; i++ {
-		retrievedMarket, marketExists := reg.GetMarket(symbol)
-		retrievedFee, feeExists := reg.GetFee(symbol)
+		retrievedMarket, marketExists := reg.GetMarket(types.NewMarketKey("binance", symbol))
+		retrievedFee, feeExists := reg.GetFee(types.NewMarketKey("binance", symbol))
 
 		if !marketExists {
 			t.Errorf("Market should exist in iteration %d", i)
@@ -359,10 +359,10 @@ func TestMarketRegistryDataIntegrity(t *testing.T) {
 	}
 }
 
-func TestMarketRegistryCaseSensitivity(t *testing.T) {
+func TestMarketRegistryKeyNormalization(t *testing.T) {
 	reg := NewMarketRegistry()
 
-	// Test that symbol lookup is case-sensitive
+	// Keys built with NewMarketKey are upper-cased, so lookups ignore case
 	reg.UpsertMarket(types.Market{
 		Exchange: "binance",
 		Symbol:   "BTCUSDT",
@@ -370,28 +370,46 @@ func TestMarketRegistryCaseSensitivity(t *testing.T) {
 		Quote:    "USDT",
 	})
 
-	reg.SetFee("btcusdt", types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+	reg.SetFee(types.NewMarketKey("binance", "btcusdt"), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
 
-	// Should find with exact case
-	_, marketExists := reg.GetMarket("BTCUSDT")
+	_, marketExists := reg.GetMarket(types.NewMarketKey("BINANCE", "btcusdt"))
 	if !marketExists {
-		t.Error("Should find market with exact case")
+		t.Error("Should find market regardless of case")
 	}
 
-	// Should not find with different case (assuming case-sensitive)
-	_, marketExistsLower := reg.GetMarket("btcusdt")
-	if marketExistsLower {
-		t.Error("Should not find market with different case")
+	_, feeExists := reg.GetFee(types.NewMarketKey("Binance", "BTCUSDT"))
+	if !feeExists {
+		t.Error("Should find fee regardless of case")
 	}
 
-	_, feeExists := reg.GetFee("btcusdt")
-	if !feeExists {
-		t.Error("Should find fee with exact case")
+	// A hand-built key that skips normalization is a different market
+	_, rawExists := reg.GetMarket(types.MarketKey{Exchange: "binance", Symbol: "BTCUSDT"})
+	if rawExists {
+		t.Error("Should not find market with a non-normalized key")
+	}
+}
+
+func TestMarketRegistryExchangeScoped(t *testing.T) {
+	reg := NewMarketRegistry()
+
+	reg.UpsertMarket(types.Market{Exchange: "BINANCE", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT", PriceTick: 0.01})
+	reg.UpsertMarket(types.Market{Exchange: "KUCOIN", Symbol: "BTCUSDT", Base: "BTC", Quote: "USDT", PriceTick: 0.1})
+	reg.SetFee(types.NewMarketKey("BINANCE", "BTCUSDT"), types.Fee{TakerBp: 7})
+	reg.SetFee(types.NewMarketKey("KUCOIN", "BTCUSDT"), types.Fee{TakerBp: 10})
+
+	if m, _ := reg.GetMarket(types.NewMarketKey("BINANCE", "BTCUSDT")); m.PriceTick != 0.01 {
+		t.Errorf("Expected BINANCE market to keep its own rules, got tick %f", m.PriceTick)
+	}
+	if f, _ := reg.GetFee(types.NewMarketKey("BINANCE", "BTCUSDT")); f.TakerBp != 7 {
+		t.Errorf("Expected BINANCE fee 7, got %f", f.TakerBp)
+	}
+	if f, _ := reg.GetFee(types.NewMarketKey("KUCOIN", "BTCUSDT")); f.TakerBp != 10 {
+		t.Errorf("Expected KUCOIN fee 10, got %f", f.TakerBp)
 	}
 
-	_, feeExistsUpper := reg.GetFee("BTCUSDT")
-	if feeExistsUpper {
-		t.Error("Should not find fee with different case")
+	markets, fees := reg.Snapshot()
+	if len(markets) != 2 || len(fees) != 2 {
+		t.Errorf("Expected 2 markets and 2 fees, got %d and %d", len(markets), len(fees))
 	}
 }
 
@@ -403,9 +421,9 @@ func TestMarketRegistryZeroValues(t *testing.T) {
 	fee := types.Fee{}       // All fields zero
 
 	reg.UpsertMarket(market)
-	reg.SetFee("ZERO", fee)
+	reg.SetFee(types.NewMarketKey("", "ZERO"), fee)
 
-	retrievedMarket, marketExists := reg.GetMarket("")
+	retrievedMarket, marketExists := reg.GetMarket(types.MarketKey{})
 	if !marketExists {
 		t.Error("Should be able to store and retrieve market with zero values")
 	}
@@ -414,7 +432,7 @@ func TestMarketRegistryZeroValues(t *testing.T) {
 		t.Error("Zero value market should be stored and retrieved correctly")
 	}
 
-	retrievedFee, feeExists := reg.GetFee("ZERO")
+	retrievedFee, feeExists := reg.GetFee(types.NewMarketKey("", "ZERO"))
 	if !feeExists {
 		t.Error("Should be able to store and retrieve fee with zero values")
 	}
@@ -455,7 +473,7 @@ func BenchmarkMarketRegistryGetMarket(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		reg.GetMarket("BTCUSDT")
+		reg.GetMarket(types.NewMarketKey("binance", "BTCUSDT"))
 	}
 }
 
@@ -469,7 +487,7 @@ func BenchmarkMarketRegistrySetFee(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		reg.SetFee("BTCUSDT", fee)
+		reg.SetFee(types.NewMarketKey("binance", "BTCUSDT"), fee)
 	}
 }
 
@@ -485,7 +503,7 @@ func BenchmarkMarketRegistrySnapshot(b *testing.B) {
 			Base:     "BASE" + string(rune(i+65)),
 			Quote:    "USDT",
 		})
-		reg.SetFee(symbol, types.Fee{TakerBp: 0.1, MakerBp: 0.05})
+		reg.SetFee(types.NewMarketKey("binance", symbol), types.Fee{TakerBp: 0.1, MakerBp: 0.05})
 	}
 
 	b.ResetTimer()
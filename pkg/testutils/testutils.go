@@ -58,26 +58,26 @@ func (m *MockPublisher) SetPublishFunc(fn func(plan types.Plan) error) {
 
 // MockTOBProvider provides mock top-of-book data for testing
 type MockTOBProvider struct {
-	data map[string]types.TopOfBook
+	data map[types.MarketKey]types.TopOfBook
 }
 
 func NewMockTOBProvider() *MockTOBProvider {
 	return &MockTOBProvider{
-		data: make(map[string]types.TopOfBook),
+		data: make(map[types.MarketKey]types.TopOfBook),
 	}
 }
 
-func (m *MockTOBProvider) Set(symbol string, tob types.TopOfBook) {
-	m.data[symbol] = tob
+func (m *MockTOBProvider) Set(key types.MarketKey, tob types.TopOfBook) {
+	m.data[key] = tob
 }
 
-func (m *MockTOBProvider) Get(symbol string) (types.TopOfBook, bool) {
-	tob, exists := m.data[symbol]
+func (m *MockTOBProvider) Get(key types.MarketKey) (types.TopOfBook, bool) {
+	tob, exists := m.data[key]
 	return tob, exists
 }
 
-func (m *MockTOBProvider) GetAll() map[string]types.TopOfBook {
-	result := make(map[string]types.TopOfBook)
+func (m *MockTOBProvider) GetAll() map[types.MarketKey]types.TopOfBook {
+	result := make(map[types.MarketKey]types.TopOfBook)
 	for k, v := range m.data {
 		result[k] = v
 	}
@@ -85,31 +85,31 @@ func (m *MockTOBProvider) GetAll() map[string]types.TopOfBook {
 }
 
 func (m *MockTOBProvider) Clear() {
-	m.data = make(map[string]types.TopOfBook)
+	m.data = make(map[types.MarketKey]types.TopOfBook)
 }
 
 // MockFeeProvider provides mock fee data for testing
 type MockFeeProvider struct {
-	data map[string]types.Fee
+	data map[types.MarketKey]types.Fee
 }
 
 func NewMockFeeProvider() *MockFeeProvider {
 	return &MockFeeProvider{
-		data: make(map[string]types.Fee),
+		data: make(map[types.MarketKey]types.Fee),
 	}
 }
 
-func (m *MockFeeProvider) Set(symbol string, fee types.Fee) {
-	m.data[symbol] = fee
+func (m *MockFeeProvider) Set(key types.MarketKey, fee types.Fee) {
+	m.data[key] = fee
 }
 
-func (m *MockFeeProvider) Get(symbol string) (types.Fee, bool) {
-	fee, exists := m.data[symbol]
+func (m *MockFeeProvider) Get(key types.MarketKey) (types.Fee, bool) {
+	fee, exists := m.data[key]
 	return fee, exists
 }
 
 func (m *MockFeeProvider) Clear() {
-	m.data = make(map[string]types.Fee)
+	m.data = make(map[types.MarketKey]types.Fee)
 }
 
 // CreateTestMarkets creates a set of test markets for common testing scenarios
@@ -163,7 +163,7 @@ func CreateTestMarkets() []types.Market {
 }
 
 // CreateTestTriangle creates a test triangle from the given markets
-func CreateTestTriangle(markets []types.Market, marketIds [3]int) types.Triangle {
+func CreateTestTriangle(markets []types.Market, marketIds []int) types.Cycle {
 	if len(markets) < 3 || len(marketIds) != 3 {
 		panic("Need at least 3 markets and 3 market IDs")
 	}
@@ -174,9 +174,9 @@ func CreateTestTriangle(markets []types.Market, marketIds [3]int) types.Triangle
 	// Market 0: BTCUSDT, Market 1: ETHUSDT, Market 2: ETHBTC
 	// To arbitrage: Buy BTC, Sell ETH->BTC, Sell ETH->USDT
 	// This gives us: USDT -> BTC -> ETH -> USDT (back to USDT)
-	return types.Triangle{
+	return types.Cycle{
 		MarketIds: marketIds,
-		Dirs:      [3]int8{1, 1, -1},  // Buy BTCUSDT, Buy ETHBTC, Sell ETHUSDT
+		Dirs:      []int8{1, 1, -1},  // Buy BTCUSDT, Buy ETHBTC, Sell ETHUSDT
 		QuoteCcy:  quoteCcy,
 	}
 }
@@ -208,35 +208,35 @@ func CreateTestOrderBook(symbol string, bidPrice, askPrice float64) types.OrderB
 }
 
 // CreateProfitableArbitragePrices creates a set of prices that should result in profitable arbitrage
-func CreateProfitableArbitragePrices() map[string]types.TopOfBook {
+func CreateProfitableArbitragePrices() map[types.MarketKey]types.TopOfBook {
 	// Create prices that will result in profitable arbitrage for graph's triangle [1, 2, 0] with directions [1, -1, -1]
 	// Market 1: ETHUSDT (Buy), Market 2: ETHBTC (Sell), Market 0: BTCUSDT (Sell)
 	// Path: USDT -> ETH -> BTC -> USDT
 
 	// Set prices to create clear arbitrage opportunity for this specific triangle
 	// Need to create a rate > 0.001 (0.1%) after fees and slippage
-	return map[string]types.TopOfBook{
-		"ETHUSDT": {BidPx: 3000.0, AskPx: 3005.0, BidSz: 10.0, AskSz: 8.0},      // Buy ETH at 3005 USDT (lower ask price)
-		"ETHBTC":  {BidPx: 0.0605, AskPx: 0.0610, BidSz: 65.0, AskSz: 62.0},     // Sell ETH at 0.0605 BTC (higher bid price)
-		"BTCUSDT": {BidPx: 50100.0, AskPx: 50200.0, BidSz: 2.1, AskSz: 1.8},     // Sell BTC at 50100 USDT (higher bid price)
+	return map[types.MarketKey]types.TopOfBook{
+		types.NewMarketKey("binance", "ETHUSDT"): {BidPx: 3000.0, AskPx: 3005.0, BidSz: 10.0, AskSz: 8.0},      // Buy ETH at 3005 USDT (lower ask price)
+		types.NewMarketKey("binance", "ETHBTC"):  {BidPx: 0.0605, AskPx: 0.0610, BidSz: 65.0, AskSz: 62.0},     // Sell ETH at 0.0605 BTC (higher bid price)
+		types.NewMarketKey("binance", "BTCUSDT"): {BidPx: 50100.0, AskPx: 50200.0, BidSz: 2.1, AskSz: 1.8},     // Sell BTC at 50100 USDT (higher bid price)
 	}
 }
 
 // CreateNoArbitragePrices creates a set of prices that should NOT result in profitable arbitrage
-func CreateNoArbitragePrices() map[string]types.TopOfBook {
-	return map[string]types.TopOfBook{
-		"BTCUSDT": {BidPx: 50000.0, AskPx: 50000.0, BidSz: 2.1, AskSz: 1.8},
-		"ETHUSDT": {BidPx: 3000.0, AskPx: 3000.0, BidSz: 10.0, AskSz: 8.0},
-		"ETHBTC":  {BidPx: 0.06, AskPx: 0.06, BidSz: 65.0, AskSz: 62.0},
+func CreateNoArbitragePrices() map[types.MarketKey]types.TopOfBook {
+	return map[types.MarketKey]types.TopOfBook{
+		types.NewMarketKey("binance", "BTCUSDT"): {BidPx: 50000.0, AskPx: 50000.0, BidSz: 2.1, AskSz: 1.8},
+		types.NewMarketKey("binance", "ETHUSDT"): {BidPx: 3000.0, AskPx: 3000.0, BidSz: 10.0, AskSz: 8.0},
+		types.NewMarketKey("binance", "ETHBTC"):  {BidPx: 0.06, AskPx: 0.06, BidSz: 65.0, AskSz: 62.0},
 	}
 }
 
 // SetupTestTriangle creates a complete test setup with triangle markets
-func SetupTestTriangle() (markets []types.Market, triangle types.Triangle) {
+func SetupTestTriangle() (markets []types.Market, triangle types.Cycle) {
 	markets = CreateTestMarkets()
 
 	// Create triangle from first 3 markets
-	triangle = CreateTestTriangle(markets, [3]int{0, 1, 2})
+	triangle = CreateTestTriangle(markets, []int{0, 1, 2})
 
 	return markets, triangle
 }
@@ -307,15 +307,15 @@ func CreateTestConfig() map[string]interface{} {
 // TestDataBuilder helps build test data in a fluent way
 type TestDataBuilder struct {
 	markets []types.Market
-	tobData map[string]types.TopOfBook
-	feeData map[string]types.Fee
+	tobData map[types.MarketKey]types.TopOfBook
+	feeData map[types.MarketKey]types.Fee
 }
 
 func NewTestDataBuilder() *TestDataBuilder {
 	return &TestDataBuilder{
 		markets: make([]types.Market, 0),
-		tobData: make(map[string]types.TopOfBook),
-		feeData: make(map[string]types.Fee),
+		tobData: make(map[types.MarketKey]types.TopOfBook),
+		feeData: make(map[types.MarketKey]types.Fee),
 	}
 }
 
@@ -324,16 +324,16 @@ func (b *TestDataBuilder) WithMarket(market types.Market) *TestDataBuilder {
 	return b
 }
 
-func (b *TestDataBuilder) WithTOB(symbol string, tob types.TopOfBook) *TestDataBuilder {
-	b.tobData[symbol] = tob
+func (b *TestDataBuilder) WithTOB(key types.MarketKey, tob types.TopOfBook) *TestDataBuilder {
+	b.tobData[key] = tob
 	return b
 }
 
-func (b *TestDataBuilder) WithFee(symbol string, fee types.Fee) *TestDataBuilder {
-	b.feeData[symbol] = fee
+func (b *TestDataBuilder) WithFee(key types.MarketKey, fee types.Fee) *TestDataBuilder {
+	b.feeData[key] = fee
 	return b
 }
 
-func (b *TestDataBuilder) Build() ([]types.Market, map[string]types.TopOfBook, map[string]types.Fee) {
+func (b *TestDataBuilder) Build() ([]types.Market, map[types.MarketKey]types.TopOfBook, map[types.MarketKey]types.Fee) {
 	return b.markets, b.tobData, b.feeData
 }
@@ -1,5 +1,7 @@
 package types
 
+import "strings"
+
 type Side string
 
 
@@ -21,9 +23,28 @@ type Market struct {
 	PriceTick   float64
 }
 
+// MarketKey identifies a market across exchanges. Always build it with
+// NewMarketKey or Market.Key so both parts are upper-cased.
+type MarketKey struct {
+	Exchange string
+	Symbol   string
+}
+
+func NewMarketKey(exchange, symbol string) MarketKey {
+	return MarketKey{Exchange: strings.ToUpper(exchange), Symbol: strings.ToUpper(symbol)}
+}
+
+func (k MarketKey) String() string {
+	return k.Exchange + ":" + k.Symbol
+}
+
+func (m Market) Key() MarketKey {
+	return NewMarketKey(m.Exchange, m.Symbol)
+}
+
 type Fee struct {
-	TakerBp float64
-	MakerBp float64
+	TakerBp float64 `json:"taker_bp"`
+	MakerBp float64 `json:"maker_bp"`
 }
 
 type Level struct {
@@ -32,12 +53,12 @@ type Level struct {
 }
 
 type TopOfBook struct {
-	BidPx float64 
-	BidSz float64
-	AskPx float64
-	AskSz float64
-	Seq   uint64
-	TsNs  int64 //
+	BidPx float64 `json:"bid_px"`
+	BidSz float64 `json:"bid_sz"`
+	AskPx float64 `json:"ask_px"`
+	AskSz float64 `json:"ask_sz"`
+	Seq   uint64  `json:"seq"`
+	TsNs  int64   `json:"ts_ns"`
 }
 
 type OrderBook struct {
@@ -47,25 +68,77 @@ type OrderBook struct {
 	TsNs int64
 }
 
-type Triangle struct {
-	MarketIds [3]int
-	Dirs      [3]int8
+// Cycle is a closed path through markets that starts and ends in QuoteCcy.
+// Leg i trades on market MarketIds[i]: Dirs[i] is +1 when it buys the base
+// with the quote and -1 when it sells the base for the quote.
+type Cycle struct {
+	MarketIds []int
+	Dirs      []int8
 	QuoteCcy  string
 }
 
-type TriangleLeg struct {
-	Market     string
-	Side       Side
-	Qty        float64
-	LimitPrice float64
+func (c Cycle) Len() int {
+	return len(c.MarketIds)
+}
+
+type Leg struct {
+	Exchange   string  `json:"exchange"`
+	Market     string  `json:"market"`
+	Side       Side    `json:"side"`
+	Qty        float64 `json:"qty"`
+	LimitPrice float64 `json:"limit_price"`
 }
 
 type Plan struct {
-	Exchange            string
-	Legs                [3]TriangleLeg
-	ExpectedProfitQuote float64
-	QuoteCurrency       string
-	ValidMs             uint64
-	MaxSlippageBp       float64
-	PlanID              string
+	Exchange            string    `json:"exchange"` // venue of the first leg; cross-exchange legs carry their own
+	Legs                []Leg     `json:"legs"`
+	ExpectedProfitQuote float64   `json:"expected_profit_quote"`
+	QuoteCurrency       string    `json:"quote_currency"`
+	ValidMs             uint64    `json:"valid_ms"`
+	MaxSlippageBp       float64   `json:"max_slippage_bp"`
+	PlanID              string    `json:"plan_id"`
+	Decision            *Decision `json:"decision,omitempty"` // what the plan was priced from; nil unless the simulator filled it
+}
+
+// Decision holds the quotes and fees each leg was priced from, in leg order,
+// and when the plan was found.
+type Decision struct {
+	MarketIds []int       `json:"market_ids"`
+	Quotes    []TopOfBook `json:"quotes"`
+	Fees      []Fee       `json:"fees"`
+	TsNs      int64       `json:"ts_ns"`
+}
+
+// Route names what a plan trades: each leg's venue, market and side in
+// order, so the two directions of a loop have different routes.
+func (p Plan) Route() string {
+	parts := make([]string, 0, len(p.Legs))
+	for _, l := range p.Legs {
+		exchange := l.Exchange
+		if exchange == "" {
+			exchange = p.Exchange
+		}
+		parts = append(parts, strings.ToUpper(exchange)+":"+l.Market+":"+string(l.Side))
+	}
+	return strings.Join(parts, ",")
+}
+
+// StartAmount is what the first leg spends, in the plan's quote currency.
+func (p Plan) StartAmount() float64 {
+	if len(p.Legs) == 0 {
+		return 0
+	}
+	if l := p.Legs[0]; l.Side == SideBuy {
+		return l.Qty * l.LimitPrice
+	}
+	return p.Legs[0].Qty
+}
+
+// Edge is the expected profit relative to what the first leg spends.
+func (p Plan) Edge() float64 {
+	start := p.StartAmount()
+	if start == 0 {
+		return 0
+	}
+	return p.ExpectedProfitQuote / start
 }
@@ -53,6 +53,25 @@ func TestMarket(t *testing.T) {
 	}
 }
 
+func TestMarketKey(t *testing.T) {
+	key := NewMarketKey("binance", "btcusdt")
+
+	if key.Exchange != "BINANCE" || key.Symbol != "BTCUSDT" {
+		t.Errorf("Expected upper-cased key, got %+v", key)
+	}
+	if key.String() != "BINANCE:BTCUSDT" {
+		t.Errorf("Expected BINANCE:BTCUSDT, got %s", key.String())
+	}
+
+	market := Market{Exchange: "Binance", Symbol: "BTCUSDT"}
+	if market.Key() != key {
+		t.Errorf("Expected market key %+v, got %+v", key, market.Key())
+	}
+	if NewMarketKey("KUCOIN", "BTCUSDT") == key {
+		t.Error("Expected keys on different exchanges to differ")
+	}
+}
+
 func TestFee(t *testing.T) {
 	fee := Fee{
 		TakerBp: 0.1,
@@ -152,15 +171,15 @@ func TestOrderBook(t *testing.T) {
 	}
 }
 
-func TestTriangle(t *testing.T) {
-	triangle := Triangle{
-		MarketIds: [3]int{0, 1, 2},
-		Dirs:      [3]int8{1, -1, -1},
+func TestCycle(t *testing.T) {
+	triangle := Cycle{
+		MarketIds: []int{0, 1, 2},
+		Dirs:      []int8{1, -1, -1},
 		QuoteCcy:  "USDT",
 	}
 
-	expectedMarketIds := [3]int{0, 1, 2}
-	expectedDirs := [3]int8{1, -1, -1}
+	expectedMarketIds := []int{0, 1, 2}
+	expectedDirs := []int8{1, -1, -1}
 
 	for i, id := range triangle.MarketIds {
 		if id != expectedMarketIds[i] {
@@ -177,10 +196,14 @@ func TestTriangle(t *testing.T) {
 	if triangle.QuoteCcy != "USDT" {
 		t.Errorf("Expected quote currency USDT, got %s", triangle.QuoteCcy)
 	}
+
+	if triangle.Len() != 3 {
+		t.Errorf("Expected 3 legs, got %d", triangle.Len())
+	}
 }
 
-func TestTriangleLeg(t *testing.T) {
-	leg := TriangleLeg{
+func TestLeg(t *testing.T) {
+	leg := Leg{
 		Market:     "BTCUSDT",
 		Side:       SideBuy,
 		Qty:        1.5,
@@ -202,7 +225,7 @@ func TestTriangleLeg(t *testing.T) {
 }
 
 func TestPlan(t *testing.T) {
-	legs := [3]TriangleLeg{
+	legs := []Leg{
 		{Market: "BTCUSDT", Side: SideBuy, Qty: 1.5, LimitPrice: 50000.0},
 		{Market: "ETHBTC", Side: SideSell, Qty: 1.5, LimitPrice: 0.03},
 		{Market: "ETHUSDT", Side: SideSell, Qty: 50.0, LimitPrice: 1500.0},
@@ -241,6 +264,32 @@ func TestPlan(t *testing.T) {
 	}
 }
 
+func TestPlanRoute(t *testing.T) {
+	plan := Plan{
+		Exchange: "binance",
+		Legs: []Leg{
+			{Market: "ETHUSDT", Side: SideBuy, Qty: 0.5, LimitPrice: 3000.0},
+			{Exchange: "kucoin", Market: "ETH-BTC", Side: SideSell, Qty: 0.5, LimitPrice: 0.06},
+			{Exchange: "BINANCE", Market: "BTCUSDT", Side: SideSell, Qty: 0.03, LimitPrice: 50100.0},
+		},
+	}
+
+	if got := plan.Route(); got != "BINANCE:ETHUSDT:BUY,KUCOIN:ETH-BTC:SELL,BINANCE:BTCUSDT:SELL" {
+		t.Errorf("Unexpected route %q", got)
+	}
+	if got := plan.StartAmount(); got != 1500.0 {
+		t.Errorf("Expected to spend 1500 USDT, got %f", got)
+	}
+
+	plan.Legs[0].Side = SideSell
+	if got := plan.StartAmount(); got != 0.5 {
+		t.Errorf("Expected to spend 0.5 ETH, got %f", got)
+	}
+	if (Plan{}).StartAmount() != 0 || (Plan{}).Route() != "" {
+		t.Error("Expected an empty plan to have no route or amount")
+	}
+}
+
 // Test data integrity and serialization compatibility
 func TestTypeCompatibility(t *testing.T) {
 	// Test that all types can be created and compared
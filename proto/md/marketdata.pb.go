@@ -131,6 +131,7 @@ type OrderBookDelta struct {
 	Bids       []*Level  `protobuf:"bytes,4,rep,name=bids,proto3" json:"bids,omitempty"`
 	Asks       []*Level  `protobuf:"bytes,5,rep,name=asks,proto3" json:"asks,omitempty"`
 	IsSnapshot bool      `protobuf:"varint,6,opt,name=is_snapshot,json=isSnapshot,proto3" json:"is_snapshot,omitempty"`
+	Stale      bool      `protobuf:"varint,7,opt,name=stale,proto3" json:"stale,omitempty"`
 }
 
 func (x *OrderBookDelta) Reset() {
@@ -205,6 +206,13 @@ func (x *OrderBookDelta) GetIsSnapshot() bool {
 	return false
 }
 
+func (x *OrderBookDelta) GetStale() bool {
+	if x != nil {
+		return x.Stale
+	}
+	return false
+}
+
 type StreamRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -255,7 +263,8 @@ type Ack struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Ok bool `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Ok     bool        `protobuf:"varint,1,opt,name=ok,proto3" json:"ok,omitempty"`
+	Resync []*MarketId `protobuf:"bytes,2,rep,name=resync,proto3" json:"resync,omitempty"`
 }
 
 func (x *Ack) Reset() {
@@ -295,6 +304,13 @@ func (x *Ack) GetOk() bool {
 	return false
 }
 
+func (x *Ack) GetResync() []*MarketId {
+	if x != nil {
+		return x.Resync
+	}
+	return nil
+}
+
 var File_proto_marketdata_proto protoreflect.FileDescriptor
 
 var file_proto_marketdata_proto_rawDesc = []byte{
@@ -306,7 +322,7 @@ var file_proto_marketdata_proto_rawDesc = []byte{
 	0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x73, 0x79, 0x6d, 0x62, 0x6f, 0x6c, 0x22, 0x2f, 0x0a, 0x05,
 	0x4c, 0x65, 0x76, 0x65, 0x6c, 0x12, 0x14, 0x0a, 0x05, 0x70, 0x72, 0x69, 0x63, 0x65, 0x18, 0x01,
 	0x20, 0x01, 0x28, 0x01, 0x52, 0x05, 0x70, 0x72, 0x69, 0x63, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x71,
-	0x74, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x71, 0x74, 0x79, 0x22, 0xc6, 0x01,
+	0x74, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x03, 0x71, 0x74, 0x79, 0x22, 0xdc, 0x01,
 	0x0a, 0x0e, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x42, 0x6f, 0x6f, 0x6b, 0x44, 0x65, 0x6c, 0x74, 0x61,
 	0x12, 0x24, 0x0a, 0x06, 0x6d, 0x61, 0x72, 0x6b, 0x65, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
 	0x32, 0x0c, 0x2e, 0x6d, 0x64, 0x2e, 0x4d, 0x61, 0x72, 0x6b, 0x65, 0x74, 0x49, 0x64, 0x52, 0x06,
@@ -319,22 +335,26 @@ var file_proto_marketdata_proto_rawDesc = []byte{
 	0x20, 0x03, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x6d, 0x64, 0x2e, 0x4c, 0x65, 0x76, 0x65, 0x6c, 0x52,
 	0x04, 0x61, 0x73, 0x6b, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x69, 0x73, 0x5f, 0x73, 0x6e, 0x61, 0x70,
 	0x73, 0x68, 0x6f, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x69, 0x73, 0x53, 0x6e,
-	0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x22, 0x37, 0x0a, 0x0d, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d,
-	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x26, 0x0a, 0x07, 0x6d, 0x61, 0x72, 0x6b, 0x65,
-	0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x6d, 0x64, 0x2e, 0x4d, 0x61,
-	0x72, 0x6b, 0x65, 0x74, 0x49, 0x64, 0x52, 0x07, 0x6d, 0x61, 0x72, 0x6b, 0x65, 0x74, 0x73, 0x22,
-	0x15, 0x0a, 0x03, 0x41, 0x63, 0x6b, 0x12, 0x0e, 0x0a, 0x02, 0x6f, 0x6b, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x08, 0x52, 0x02, 0x6f, 0x6b, 0x32, 0x49, 0x0a, 0x0d, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x42,
-	0x6f, 0x6f, 0x6b, 0x46, 0x65, 0x65, 0x64, 0x12, 0x38, 0x0a, 0x0b, 0x53, 0x74, 0x72, 0x65, 0x61,
-	0x6d, 0x42, 0x6f, 0x6f, 0x6b, 0x73, 0x12, 0x11, 0x2e, 0x6d, 0x64, 0x2e, 0x53, 0x74, 0x72, 0x65,
-	0x61, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x12, 0x2e, 0x6d, 0x64, 0x2e, 0x4f,
-	0x72, 0x64, 0x65, 0x72, 0x42, 0x6f, 0x6f, 0x6b, 0x44, 0x65, 0x6c, 0x74, 0x61, 0x28, 0x01, 0x30,
-	0x01, 0x32, 0x3f, 0x0a, 0x10, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x42, 0x6f, 0x6f, 0x6b, 0x49, 0x6e,
-	0x67, 0x72, 0x65, 0x73, 0x73, 0x12, 0x2b, 0x0a, 0x0a, 0x50, 0x75, 0x73, 0x68, 0x44, 0x65, 0x6c,
-	0x74, 0x61, 0x73, 0x12, 0x12, 0x2e, 0x6d, 0x64, 0x2e, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x42, 0x6f,
-	0x6f, 0x6b, 0x44, 0x65, 0x6c, 0x74, 0x61, 0x1a, 0x07, 0x2e, 0x6d, 0x64, 0x2e, 0x41, 0x63, 0x6b,
-	0x28, 0x01, 0x42, 0x0a, 0x5a, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x6d, 0x64, 0x62, 0x06,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x61, 0x70, 0x73, 0x68, 0x6f, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x6c, 0x65, 0x18,
+	0x07, 0x20, 0x01, 0x28, 0x08, 0x52, 0x05, 0x73, 0x74, 0x61, 0x6c, 0x65, 0x22, 0x37, 0x0a, 0x0d,
+	0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x26, 0x0a,
+	0x07, 0x6d, 0x61, 0x72, 0x6b, 0x65, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c,
+	0x2e, 0x6d, 0x64, 0x2e, 0x4d, 0x61, 0x72, 0x6b, 0x65, 0x74, 0x49, 0x64, 0x52, 0x07, 0x6d, 0x61,
+	0x72, 0x6b, 0x65, 0x74, 0x73, 0x22, 0x3b, 0x0a, 0x03, 0x41, 0x63, 0x6b, 0x12, 0x0e, 0x0a, 0x02,
+	0x6f, 0x6b, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x02, 0x6f, 0x6b, 0x12, 0x24, 0x0a, 0x06,
+	0x72, 0x65, 0x73, 0x79, 0x6e, 0x63, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x6d,
+	0x64, 0x2e, 0x4d, 0x61, 0x72, 0x6b, 0x65, 0x74, 0x49, 0x64, 0x52, 0x06, 0x72, 0x65, 0x73, 0x79,
+	0x6e, 0x63, 0x32, 0x49, 0x0a, 0x0d, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x42, 0x6f, 0x6f, 0x6b, 0x46,
+	0x65, 0x65, 0x64, 0x12, 0x38, 0x0a, 0x0b, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x42, 0x6f, 0x6f,
+	0x6b, 0x73, 0x12, 0x11, 0x2e, 0x6d, 0x64, 0x2e, 0x53, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x12, 0x2e, 0x6d, 0x64, 0x2e, 0x4f, 0x72, 0x64, 0x65, 0x72,
+	0x42, 0x6f, 0x6f, 0x6b, 0x44, 0x65, 0x6c, 0x74, 0x61, 0x28, 0x01, 0x30, 0x01, 0x32, 0x3f, 0x0a,
+	0x10, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x42, 0x6f, 0x6f, 0x6b, 0x49, 0x6e, 0x67, 0x72, 0x65, 0x73,
+	0x73, 0x12, 0x2b, 0x0a, 0x0a, 0x50, 0x75, 0x73, 0x68, 0x44, 0x65, 0x6c, 0x74, 0x61, 0x73, 0x12,
+	0x12, 0x2e, 0x6d, 0x64, 0x2e, 0x4f, 0x72, 0x64, 0x65, 0x72, 0x42, 0x6f, 0x6f, 0x6b, 0x44, 0x65,
+	0x6c, 0x74, 0x61, 0x1a, 0x07, 0x2e, 0x6d, 0x64, 0x2e, 0x41, 0x63, 0x6b, 0x28, 0x01, 0x42, 0x0a,
+	0x5a, 0x08, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x6d, 0x64, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
 }
 
 var (
@@ -362,14 +382,15 @@ var file_proto_marketdata_proto_depIdxs = []int32{
 	1,
 	1,
 	0,
+	0,
 	3,
 	2,
 	2,
 	4,
-	6,
-	4,
-	4,
-	4,
+	7,
+	5,
+	5,
+	5,
 	0,
 }
 